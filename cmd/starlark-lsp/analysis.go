@@ -0,0 +1,709 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the analysis of documents, and the queries
+// answered from it.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"go.starlark.net/lint"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// A document is an open file and the results of analyzing its text.
+type document struct {
+	uri      string
+	filename string
+	text     lines
+	diags    []diagnostic
+
+	// The results of analyzing the most recent text that parsed.
+	// If stale is set, the current text does not parse, and the
+	// positions in file may no longer correspond to it.
+	file    *syntax.File
+	pass    *lint.Pass                      // bindings of file
+	loads   map[*syntax.Ident]loadedName    // names bound by load statements
+	froms   map[*syntax.Ident]*syntax.Ident // maps each loaded name to its binding
+	modules map[string]*module              // loaded modules, by name
+	stale   bool
+}
+
+// A loadedName is a global of a module, bound by a load statement.
+type loadedName struct {
+	module string
+	name   string
+}
+
+// A module is the result of loading a module.
+type module struct {
+	filename string       // may be empty if the module has no file
+	file     *syntax.File // resolved syntax of the file, or nil
+	globals  starlark.StringDict
+	err      error
+}
+
+// def returns the def statement that binds the named global of m,
+// or nil if there is none.
+func (m *module) def(name string) *syntax.DefStmt {
+	if m.file != nil {
+		for _, stmt := range m.file.Stmts {
+			if def, ok := stmt.(*syntax.DefStmt); ok && def.Name.Name == name {
+				return def
+			}
+		}
+	}
+	return nil
+}
+
+// A capture is a lint check that records its pass,
+// which provides the bindings of the file.
+type capture struct{ pass *lint.Pass }
+
+func (c *capture) Name() string        { return "capture" }
+func (c *capture) Run(pass *lint.Pass) { c.pass = pass }
+
+// analyze parses and resolves the new text of doc, loads the modules
+// it names, and records the results and diagnostics.
+func (s *server) analyze(doc *document, text string) {
+	doc.text = strings.Split(text, "\n")
+	doc.diags = []diagnostic{} // non-nil, so that JSON output is [] not null
+
+	f, err := syntax.Parse(doc.filename, text, 0)
+	if err != nil {
+		errs, ok := err.(syntax.ErrorList)
+		if !ok {
+			errs = syntax.ErrorList{{Msg: err.Error()}}
+		}
+		for _, err := range errs {
+			doc.diags = append(doc.diags, doc.text.diagnostic(err.Pos, severityError, err.Msg))
+		}
+		doc.stale = true
+		return
+	}
+
+	c := new(capture)
+	for _, finding := range lint.File(s.opts, f, s.predeclared.Has, []lint.Check{c}) {
+		doc.diags = append(doc.diags, doc.text.diagnostic(finding.Pos, severityError, finding.Message))
+	}
+	doc.file, doc.pass, doc.stale = f, c.pass, false
+
+	doc.loads = make(map[*syntax.Ident]loadedName)
+	doc.froms = make(map[*syntax.Ident]*syntax.Ident)
+	doc.modules = make(map[string]*module)
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		name := load.ModuleName()
+		m, ok := doc.modules[name]
+		if !ok {
+			m = s.load(doc.filename, name)
+			doc.modules[name] = m
+		}
+		if m.err != nil {
+			doc.diags = append(doc.diags, doc.text.diagnostic(load.Module.TokenPos, severityWarning,
+				fmt.Sprintf("cannot load %s: %v", name, m.err)))
+		}
+		for i, from := range load.From {
+			doc.loads[load.To[i]] = loadedName{name, from.Name}
+			doc.froms[from] = load.To[i]
+			if m.err == nil && !m.globals.Has(from.Name) {
+				doc.diags = append(doc.diags, doc.text.diagnostic(from.NamePos, severityError,
+					fmt.Sprintf("load: name %s not found in module %s", from.Name, name)))
+			}
+		}
+	}
+	sort.SliceStable(doc.diags, func(i, j int) bool {
+		x, y := doc.diags[i].Range.Start, doc.diags[j].Range.Start
+		if x.Line != y.Line {
+			return x.Line < y.Line
+		}
+		return x.Character < y.Character
+	})
+}
+
+// identAt returns the identifier at position p of the current text,
+// and the identifier that binds it, or nil if there is none.
+func (doc *document) identAt(p position) (id, bind *syntax.Ident) {
+	if doc.file == nil || doc.stale {
+		return nil, nil
+	}
+	line, col := int32(p.Line+1), doc.text.column(p)
+	syntax.Walk(doc.file, func(n syntax.Node) bool {
+		if x, ok := n.(*syntax.Ident); ok && x.NamePos.Line == line &&
+			x.NamePos.Col <= col && col <= x.NamePos.Col+int32(utf8.RuneCountInString(x.Name)) {
+			id = x
+		}
+		return id == nil
+	})
+	if id == nil {
+		return nil, nil
+	}
+	if to, ok := doc.froms[id]; ok {
+		return id, to // the name of a global in a load statement
+	}
+	return id, doc.pass.Binding(id)
+}
+
+// moduleAt returns the module whose name appears in
+// a load statement at position p, or nil if there is none.
+func (doc *document) moduleAt(p position) *module {
+	if doc.file == nil || doc.stale {
+		return nil
+	}
+	line, col := int32(p.Line+1), doc.text.column(p)
+	for _, stmt := range doc.file.Stmts {
+		if load, ok := stmt.(*syntax.LoadStmt); ok {
+			start, end := load.Module.Span()
+			if start.Line == line && start.Col <= col && col <= end.Col {
+				return doc.modules[load.ModuleName()]
+			}
+		}
+	}
+	return nil
+}
+
+// definition returns the location of the binding of the name at p.
+// The binding of a loaded name is its definition in the loaded module.
+func (s *server) definition(doc *document, p position) *location {
+	if m := doc.moduleAt(p); m != nil && m.filename != "" {
+		return &location{URI: filenameToURI(m.filename)}
+	}
+	_, bind := doc.identAt(p)
+	if bind == nil {
+		return nil
+	}
+	if l, ok := doc.loads[bind]; ok && doc.modules[l.module].filename != "" {
+		if loc := s.globalLocation(doc.modules[l.module].filename, l.name); loc != nil {
+			return loc
+		}
+	}
+	return &location{doc.uri, doc.text.span(bind)}
+}
+
+// globalLocation returns the location of the first binding of the
+// global name in the named file, or nil if it cannot be found.
+func (s *server) globalLocation(filename, name string) *location {
+	var src []byte
+	for _, doc := range s.docs {
+		if doc.filename == filename {
+			src = []byte(strings.Join(doc.text, "\n"))
+		}
+	}
+	if src == nil {
+		var err error
+		if src, err = ioutil.ReadFile(filename); err != nil {
+			return nil
+		}
+	}
+	f, err := syntax.Parse(filename, src, 0)
+	if err != nil {
+		return nil
+	}
+	for _, stmt := range f.Stmts {
+		var ids []*syntax.Ident
+		switch stmt := stmt.(type) {
+		case *syntax.DefStmt:
+			ids = []*syntax.Ident{stmt.Name}
+		case *syntax.AssignStmt:
+			if stmt.Op == syntax.EQ {
+				ids = targets(stmt.LHS)
+			}
+		case *syntax.LoadStmt:
+			ids = stmt.To
+		}
+		for _, id := range ids {
+			if id.Name == name {
+				text := lines(strings.Split(string(src), "\n"))
+				return &location{filenameToURI(filename), text.span(id)}
+			}
+		}
+	}
+	return nil
+}
+
+// targets returns the identifiers bound by the assignment target lhs.
+func targets(lhs syntax.Expr) []*syntax.Ident {
+	switch lhs := lhs.(type) {
+	case *syntax.Ident:
+		return []*syntax.Ident{lhs}
+	case *syntax.ParenExpr:
+		return targets(lhs.X)
+	case *syntax.ListExpr:
+		var ids []*syntax.Ident
+		for _, x := range lhs.List {
+			ids = append(ids, targets(x)...)
+		}
+		return ids
+	case *syntax.TupleExpr:
+		var ids []*syntax.Ident
+		for _, x := range lhs.List {
+			ids = append(ids, targets(x)...)
+		}
+		return ids
+	}
+	return nil
+}
+
+// references returns the locations of all identifiers in the document
+// that refer to the same binding as the name at p, in order.
+func (s *server) references(doc *document, p position, includeDecl bool) []location {
+	_, bind := doc.identAt(p)
+	if bind == nil {
+		return nil
+	}
+	var ids []*syntax.Ident
+	syntax.Walk(doc.file, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok && doc.pass.Binding(id) == bind && (includeDecl || id != bind) {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	sort.Slice(ids, func(i, j int) bool {
+		x, y := ids[i].NamePos, ids[j].NamePos
+		if x.Line != y.Line {
+			return x.Line < y.Line
+		}
+		return x.Col < y.Col
+	})
+	locs := []location{}
+	for _, id := range ids {
+		locs = append(locs, location{doc.uri, doc.text.span(id)})
+	}
+	return locs
+}
+
+// hover describes the name at p.
+func (s *server) hover(doc *document, p position) *hover {
+	id, bind := doc.identAt(p)
+	if id == nil {
+		return nil
+	}
+	var text string
+	if l, ok := doc.loads[bind]; ok {
+		m := doc.modules[l.module]
+		if def := m.def(l.name); def != nil {
+			text = describeBinding(m.file, def.Name)
+		} else {
+			text = describe(fmt.Sprintf("loaded from %q", l.module), id.Name, m.globals[l.name])
+		}
+	} else if bind != nil {
+		text = describeBinding(doc.file, bind)
+	} else {
+		switch resolve.Scope(id.Scope) {
+		case resolve.Universal:
+			text = describe("builtin", id.Name, starlark.Universe[id.Name])
+		case resolve.Predeclared:
+			text = describe("predeclared", id.Name, s.predeclared[id.Name])
+		default:
+			return nil // e.g. a field name
+		}
+	}
+	return &hover{markupContent{"markdown", text}, doc.text.span(id)}
+}
+
+// describe returns a Markdown description of the value v of a name.
+func describe(kind, name string, v starlark.Value) string {
+	if fn, ok := v.(*starlark.Function); ok {
+		params := make([]string, fn.NumParams())
+		for i := range params {
+			params[i], _ = fn.Param(i)
+		}
+		if fn.HasKwargs() {
+			params[len(params)-1] = "**" + params[len(params)-1]
+		}
+		if fn.HasVarargs() {
+			i := len(params) - 1
+			if fn.HasKwargs() {
+				i--
+			}
+			params[i] = "*" + params[i]
+		}
+		sig := fmt.Sprintf("def %s(%s)", fn.Name(), strings.Join(params, ", "))
+		return markdown(sig, fn.Doc())
+	}
+	if v == nil {
+		return code(fmt.Sprintf("(%s) %s", kind, name))
+	}
+	return code(fmt.Sprintf("(%s) %s: %s", kind, name, v.Type()))
+}
+
+// describeBinding returns a Markdown description of the
+// name bound by the identifier bind in file f.
+func describeBinding(f *syntax.File, bind *syntax.Ident) string {
+	var text string
+	syntax.Walk(f, func(n syntax.Node) bool {
+		var fn *syntax.Function
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			if n.Name == bind {
+				params := make([]string, len(n.Params))
+				for i, param := range n.Params {
+					params[i] = syntax.FormatExpr(param)
+				}
+				sig := fmt.Sprintf("def %s(%s)", bind.Name, strings.Join(params, ", "))
+				var doc string
+				if stmt, ok := n.Body[0].(*syntax.ExprStmt); ok {
+					if lit, ok := stmt.X.(*syntax.Literal); ok && lit.Token == syntax.STRING {
+						doc = lit.Value.(string)
+					}
+				}
+				text = markdown(sig, doc)
+			}
+			fn = &n.Function
+		case *syntax.LambdaExpr:
+			fn = &n.Function
+		}
+		if fn != nil {
+			for _, param := range fn.Params {
+				if paramIdent(param) == bind {
+					text = code(fmt.Sprintf("(parameter) %s", bind.Name))
+				}
+			}
+		}
+		return text == ""
+	})
+	if text != "" {
+		return text
+	}
+	if resolve.Scope(bind.Scope) == resolve.Global {
+		return code(fmt.Sprintf("(global variable) %s", bind.Name))
+	}
+	return code(fmt.Sprintf("(local variable) %s", bind.Name))
+}
+
+// paramIdent returns the identifier of a parameter.
+func paramIdent(param syntax.Expr) *syntax.Ident {
+	switch param := param.(type) {
+	case *syntax.Ident:
+		return param
+	case *syntax.BinaryExpr:
+		return param.X.(*syntax.Ident)
+	case *syntax.UnaryExpr:
+		id, _ := param.X.(*syntax.Ident)
+		return id
+	}
+	return nil
+}
+
+// code returns Markdown for a Starlark code block.
+func code(text string) string {
+	return "```starlark\n" + text + "\n```"
+}
+
+// markdown returns Markdown for a function signature and its docstring.
+func markdown(sig, doc string) string {
+	if doc = dedent(doc); doc != "" {
+		return code(sig) + "\n\n" + doc
+	}
+	return code(sig)
+}
+
+// dedent removes the common indentation of all but the first
+// line of a docstring, and any leading and trailing blank lines.
+func dedent(doc string) string {
+	lines := strings.Split(doc, "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		if n := len(line) - len(strings.TrimLeft(line, " \t")); n < len(line) && (indent < 0 || n < indent) {
+			indent = n
+		}
+	}
+	for i := range lines[1:] {
+		if len(lines[i+1]) >= indent && indent > 0 {
+			lines[i+1] = lines[i+1][indent:]
+		} else {
+			lines[i+1] = strings.TrimLeft(lines[i+1], " \t")
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// keywords are the reserved words of Starlark.
+var keywords = []string{
+	"and", "break", "continue", "def", "elif", "else", "for", "if",
+	"in", "lambda", "load", "not", "or", "pass", "return", "while",
+}
+
+// completion returns the names that may complete the partial
+// identifier before p: the attributes of the receiver value if
+// the identifier follows a dot, or otherwise the names in scope.
+// It uses the most recent text that parsed.
+func (s *server) completion(doc *document, p position) []completionItem {
+	before := []rune(doc.text.line(p.Line))
+	if col := int(doc.text.column(p)) - 1; col < len(before) {
+		before = before[:col]
+	}
+	i := len(before)
+	for i > 0 && isIdent(before[i-1]) {
+		i--
+	}
+	prefix := string(before[i:])
+
+	items := []completionItem{}
+	seen := make(map[string]bool)
+	add := func(name string, kind int, detail string) {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			items = append(items, completionItem{Label: name, Kind: kind, Detail: detail})
+		}
+	}
+
+	if i > 0 && before[i-1] == '.' {
+		if recv, ok := s.receiver(doc, before[:i-1]).(starlark.HasAttrs); ok {
+			for _, name := range recv.AttrNames() {
+				v, _ := recv.Attr(name)
+				kind := kindField
+				if _, ok := v.(starlark.Callable); ok {
+					kind = kindMethod
+				}
+				add(name, kind, typeName(v))
+			}
+		}
+	} else {
+		if f := doc.file; f != nil {
+			line := int32(p.Line + 1)
+			// Locals of enclosing functions.
+			syntax.Walk(f, func(n syntax.Node) bool {
+				var fn *syntax.Function
+				switch n := n.(type) {
+				case *syntax.DefStmt:
+					fn = &n.Function
+				case *syntax.LambdaExpr:
+					fn = &n.Function
+				}
+				if fn != nil {
+					if start, end := fn.Span(); start.Line <= line && line <= end.Line {
+						for _, id := range fn.Locals {
+							add(id.Name, kindVariable, "")
+						}
+					}
+				}
+				return true
+			})
+			for _, id := range f.Globals {
+				if v := s.valueOf(doc, id.Name); v != nil {
+					add(id.Name, kindOf(v), typeName(v))
+				} else {
+					add(id.Name, kindVariable, "")
+				}
+			}
+		}
+		for name, v := range s.predeclared {
+			add(name, kindOf(v), typeName(v))
+		}
+		for name, v := range starlark.Universe {
+			add(name, kindOf(v), typeName(v))
+		}
+		for _, kw := range keywords {
+			add(kw, kindKeyword, "")
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func isIdent(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+
+func kindOf(v starlark.Value) int {
+	switch v.(type) {
+	case starlark.Callable:
+		return kindFunction
+	case starlark.HasAttrs:
+		if v.Type() == "module" {
+			return kindModule
+		}
+	}
+	return kindVariable
+}
+
+func typeName(v starlark.Value) string {
+	if v == nil {
+		return ""
+	}
+	return v.Type()
+}
+
+// receiver returns a value of the type of the expression that
+// ends the text before a dot, or nil if it is unknown. The
+// expression must be a string literal or a dotted name.
+func (s *server) receiver(doc *document, before []rune) starlark.Value {
+	end := len(before)
+	if end > 0 && (before[end-1] == '"' || before[end-1] == '\'') {
+		return starlark.String("")
+	}
+	var names []string
+	for {
+		i := end
+		for i > 0 && isIdent(before[i-1]) {
+			i--
+		}
+		if i == end {
+			return nil
+		}
+		names = append([]string{string(before[i:end])}, names...)
+		if i == 0 || before[i-1] != '.' {
+			break
+		}
+		end = i - 1
+	}
+	v := s.valueOf(doc, names[0])
+	for _, name := range names[1:] {
+		recv, ok := v.(starlark.HasAttrs)
+		if !ok {
+			return nil
+		}
+		if v, _ = recv.Attr(name); v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+// valueOf returns a value of the type of the global, predeclared,
+// or universal name, or nil if it is unknown. The type of a global
+// variable is known if it is loaded, or assigned a literal.
+func (s *server) valueOf(doc *document, name string) starlark.Value {
+	if doc.file != nil {
+		for _, stmt := range doc.file.Stmts {
+			switch stmt := stmt.(type) {
+			case *syntax.LoadStmt:
+				for _, id := range stmt.To {
+					if id.Name == name {
+						l := doc.loads[id]
+						return doc.modules[l.module].globals[l.name]
+					}
+				}
+			case *syntax.AssignStmt:
+				if id, ok := stmt.LHS.(*syntax.Ident); ok && stmt.Op == syntax.EQ && id.Name == name {
+					return zeroValue(stmt.RHS)
+				}
+			case *syntax.DefStmt:
+				if stmt.Name.Name == name {
+					return nil
+				}
+			}
+		}
+	}
+	if v, ok := s.predeclared[name]; ok {
+		return v
+	}
+	return starlark.Universe[name]
+}
+
+// zeroValue returns a value of the type of the literal expression e,
+// or nil if e is not a literal.
+func zeroValue(e syntax.Expr) starlark.Value {
+	switch e := e.(type) {
+	case *syntax.Literal:
+		switch e.Token {
+		case syntax.INT:
+			return starlark.MakeInt(0)
+		case syntax.FLOAT:
+			return starlark.Float(0)
+		case syntax.STRING:
+			return starlark.String("")
+		case syntax.BYTES:
+			return starlark.Bytes("")
+		}
+	case *syntax.ListExpr:
+		return starlark.NewList(nil)
+	case *syntax.DictExpr:
+		return new(starlark.Dict)
+	case *syntax.Comprehension:
+		if !e.Curly {
+			return starlark.NewList(nil)
+		} else if _, ok := e.Body.(*syntax.DictEntry); ok {
+			return new(starlark.Dict)
+		}
+		return new(starlark.Set)
+	}
+	return nil
+}
+
+// lines holds the text of a file, split into lines.
+//
+// Columns of syntax positions count runes, whereas
+// protocol positions count UTF-16 code units.
+type lines []string
+
+// line returns the text of the zero-based line i.
+func (text lines) line(i int) string {
+	if 0 <= i && i < len(text) {
+		return text[i]
+	}
+	return ""
+}
+
+// position converts a syntax position to a protocol position.
+func (text lines) position(pos syntax.Position) position {
+	if pos.Line < 1 {
+		return position{}
+	}
+	n, col := 0, int(pos.Col)-1
+	for _, r := range text.line(int(pos.Line) - 1) {
+		if col <= 0 {
+			break
+		}
+		n += utf16Len(r)
+		col--
+	}
+	if col > 0 {
+		n += col // beyond end of line
+	}
+	return position{int(pos.Line) - 1, n}
+}
+
+// column returns the 1-based column of the protocol position p.
+func (text lines) column(p position) int32 {
+	col, n := int32(1), 0
+	for _, r := range text.line(p.Line) {
+		if n >= p.Character {
+			break
+		}
+		n += utf16Len(r)
+		col++
+	}
+	return col
+}
+
+// span returns the range of the identifier id.
+func (text lines) span(id *syntax.Ident) textRange {
+	start := text.position(id.NamePos)
+	end := start
+	end.Character += len(utf16.Encode([]rune(id.Name)))
+	return textRange{start, end}
+}
+
+// diagnostic returns a diagnostic at the specified position.
+func (text lines) diagnostic(pos syntax.Position, severity int, msg string) diagnostic {
+	start := text.position(pos)
+	end := start
+	end.Character++
+	return diagnostic{
+		Range:    textRange{start, end},
+		Severity: severity,
+		Source:   "starlark",
+		Message:  msg,
+	}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the loading of modules named by load statements.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.starlark.net/loader"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// A loadFunc resolves the name of a module in a load statement of
+// the file from, and returns the module.
+type loadFunc func(from, name string) *module
+
+// A fileLoader loads modules from Starlark files, which it parses and
+// resolves but does not execute. Module names are resolved by the
+// rules of loader.Loader, within a root directory and a search path
+// relative to it. Each module is cached until its file, or the file
+// of a module it loads, is modified.
+type fileLoader struct {
+	opts        *resolve.Options
+	predeclared starlark.StringDict
+	root        string                // absolute
+	names       *loader.Loader        // resolves module names within root
+	cache       map[string]*loadEntry // nil entry => module is being loaded
+}
+
+type loadEntry struct {
+	modTime time.Time
+	deps    []string // files of the modules loaded by this one
+	module  *module
+}
+
+// newFileLoader returns a loader of the files within the absolute
+// directory root, which searches the directories of path, relative to
+// root, for modules whose names are neither labels nor relative.
+func newFileLoader(opts *resolve.Options, predeclared starlark.StringDict, root string, path []string) *fileLoader {
+	return &fileLoader{
+		opts:        opts,
+		predeclared: predeclared,
+		root:        root,
+		names:       &loader.Loader{FS: os.DirFS(root), Path: path},
+		cache:       make(map[string]*loadEntry),
+	}
+}
+
+func (l *fileLoader) load(from, name string) *module {
+	filename, err := l.locate(from, name)
+	if err != nil {
+		return &module{err: err}
+	}
+	return l.get(filename)
+}
+
+// locate returns the name of the file of a module loaded by the file
+// from. A file outside the root loads modules as if it were in the root.
+func (l *fileLoader) locate(from, module string) (string, error) {
+	rel, err := filepath.Rel(l.root, from)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = ""
+	}
+	name, err := l.names.Locate(filepath.ToSlash(rel), module)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(name)), nil
+}
+
+// get returns the module of the named file,
+// analyzing the file if it is absent or out of date.
+func (l *fileLoader) get(filename string) *module {
+	if e, ok := l.cache[filename]; ok {
+		if e == nil {
+			return &module{filename: filename, err: errors.New("cycle in load graph")}
+		}
+		if l.current(filename, make(map[string]bool)) {
+			return e.module
+		}
+	}
+
+	l.cache[filename] = nil // mark as in progress, to detect cycles
+	m := &module{filename: filename}
+	e := &loadEntry{module: m}
+	if info, err := os.Stat(filename); err == nil {
+		e.modTime = info.ModTime()
+	}
+	m.file, m.err = l.parse(filename)
+	if m.err == nil {
+		m.globals, m.err = globals(m.file, func(name string) *module {
+			dep := l.load(filename, name)
+			e.deps = append(e.deps, dep.filename)
+			return dep
+		})
+	}
+	l.cache[filename] = e
+	return m
+}
+
+// parse parses and resolves the named file.
+func (l *fileLoader) parse(filename string) (*syntax.File, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := syntax.Parse(filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolve.FileOptions(l.opts, f, l.predeclared.Has, starlark.Universe.Has); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// globals returns the globals of the resolved file f, which it finds
+// without executing f. A name bound by a load statement has the value
+// of the loaded global, and a name assigned a literal has a value of
+// its type, as in valueOf. The values of other names are nil.
+func globals(f *syntax.File, load func(name string) *module) (starlark.StringDict, error) {
+	globals := make(starlark.StringDict)
+	for _, id := range f.Globals {
+		globals[id.Name] = nil
+	}
+	for _, stmt := range f.Stmts {
+		switch stmt := stmt.(type) {
+		case *syntax.LoadStmt:
+			name := stmt.ModuleName()
+			m := load(name)
+			if m.err != nil {
+				return nil, fmt.Errorf("cannot load %s: %v", name, m.err)
+			}
+			for i, from := range stmt.From {
+				if !m.globals.Has(from.Name) {
+					return nil, fmt.Errorf("load: name %s not found in module %s", from.Name, name)
+				}
+				globals[stmt.To[i].Name] = m.globals[from.Name]
+			}
+		case *syntax.AssignStmt:
+			if id, ok := stmt.LHS.(*syntax.Ident); ok && stmt.Op == syntax.EQ && globals[id.Name] == nil {
+				globals[id.Name] = zeroValue(stmt.RHS)
+			}
+		}
+	}
+	return globals, nil
+}
+
+// current reports whether the cached entry for the named file,
+// and those of the modules it loads, are up to date.
+func (l *fileLoader) current(filename string, seen map[string]bool) bool {
+	if seen[filename] {
+		return true
+	}
+	seen[filename] = true
+	e := l.cache[filename]
+	if e == nil {
+		return false
+	}
+	info, err := os.Stat(filename)
+	if err != nil || !info.ModTime().Equal(e.modTime) {
+		return false
+	}
+	for _, dep := range e.deps {
+		if !l.current(dep, seen) {
+			return false
+		}
+	}
+	return true
+}
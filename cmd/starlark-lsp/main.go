@@ -0,0 +1,108 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The starlark-lsp command is a Language Server Protocol server for
+// Starlark. It communicates with an editor over its standard input
+// and output.
+//
+// Usage:
+//
+//	starlark-lsp [-root dir] [-path list] [-predeclared list] [dialect flags]
+//
+// The server reports syntax and resolver errors in open files as
+// diagnostics. It provides go-to-definition and find-references for
+// names, hover information showing the signatures and docstrings of
+// functions, and completion of names and of the attributes of modules
+// and of values of built-in types.
+//
+// The module named by a load statement is found as by the loader
+// package, within the -root directory, which defaults to the current
+// directory: "//pkg:file.star" is relative to the root, ":file.star"
+// and "./file.star" are relative to the loading file, and other names
+// are found in the directories of the comma-separated -path list,
+// relative to the root. Loaded modules are parsed and resolved, using
+// the same dialect and predeclared names as the open files, but they
+// are not executed, so the values of their globals are unknown unless
+// they are loaded or assigned literals. The json, math, and time
+// modules are predeclared, as in the starlark command, and the
+// comma-separated -predeclared list declares more names, whose values
+// are unknown.
+package main // import "go.starlark.net/cmd/starlark-lsp"
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkmath"
+	"go.starlark.net/starlarktime"
+)
+
+// flags
+var (
+	root        = flag.String("root", ".", "root `dir`ectory of loaded modules")
+	path        = flag.String("path", "", "comma-separated `list` of directories, relative to the root, to search for loaded modules")
+	predeclared = flag.String("predeclared", "", "comma-separated `list` of predeclared names")
+)
+
+// non-standard dialect flags
+var opts resolve.Options
+
+func init() {
+	flag.BoolVar(&opts.Float, "fp", opts.Float, "allow floating-point numbers")
+	flag.BoolVar(&opts.Set, "set", opts.Set, "allow set data type")
+	flag.BoolVar(&opts.Lambda, "lambda", opts.Lambda, "allow lambda expressions")
+	flag.BoolVar(&opts.NestedDef, "nesteddef", opts.NestedDef, "allow nested def statements")
+	flag.BoolVar(&opts.Bitwise, "bitwise", opts.Bitwise, "allow bitwise operations (&, |, ^, ~, <<, and >>)")
+	flag.BoolVar(&opts.Recursion, "recursion", opts.Recursion, "allow while statements and recursive functions")
+	flag.BoolVar(&opts.Try, "try", opts.Try, "allow try/except statements")
+}
+
+func main() {
+	log.SetPrefix("starlark-lsp: ")
+	log.SetFlags(0)
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// As in the starlark command, the standard modules are predeclared.
+	env := starlark.StringDict{
+		"json": starlarkjson.Module,
+		"math": starlarkmath.Module,
+		"time": starlarktime.Module,
+	}
+	for _, name := range split(*predeclared) {
+		env[name] = nil // value unknown
+	}
+	dir, err := filepath.Abs(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := newServer(os.Stdout, &opts, env, newFileLoader(&opts, env, dir, split(*path)).load)
+	if err := s.run(os.Stdin); err != nil {
+		log.Fatal(err)
+	}
+	if !s.shutdown {
+		os.Exit(1) // exit without shutdown request
+	}
+}
+
+// split returns the non-empty elements of a comma-separated list.
+func split(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
@@ -0,0 +1,241 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the server's main loop and request dispatch.
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+// A server is a language server for the Starlark files opened by a
+// single client. It handles one message at a time.
+type server struct {
+	out         io.Writer
+	opts        *resolve.Options    // dialect of all files
+	predeclared starlark.StringDict // predeclared names of all files
+	load        loadFunc
+
+	docs     map[string]*document // open documents, by URI
+	shutdown bool                 // a shutdown request has been received
+}
+
+func newServer(out io.Writer, opts *resolve.Options, predeclared starlark.StringDict, load loadFunc) *server {
+	return &server{
+		out:         out,
+		opts:        opts,
+		predeclared: predeclared,
+		load:        load,
+		docs:        make(map[string]*document),
+	}
+}
+
+// run reads and handles messages from in until it is closed or
+// the client sends an exit notification.
+func (s *server) run(in io.Reader) error {
+	r := bufio.NewReader(in)
+	for {
+		data, err := readMessage(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(data, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(&req)
+		if req.ID == nil {
+			// Notifications have no response.
+			if err != nil {
+				log.Printf("%s: %v", req.Method, err)
+			}
+			continue
+		}
+		if err := s.reply(req.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// reply sends the response to the request with the specified ID.
+func (s *server) reply(id *json.RawMessage, result interface{}, err error) error {
+	resp := response{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		rerr, ok := err.(*responseError)
+		if !ok {
+			rerr = &responseError{codeInvalidRequest, err.Error()}
+		}
+		resp.Error = rerr
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		raw := json.RawMessage(data)
+		resp.Result = &raw
+	}
+	return writeMessage(s.out, resp)
+}
+
+// notify sends a notification to the client.
+func (s *server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle handles a single request or notification,
+// and returns the result for the response.
+func (s *server) handle(req *request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full text on each change
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]string{"name": "starlark-lsp"},
+		}, nil
+
+	case "initialized", "$/cancelRequest", "textDocument/didSave":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			return nil, s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+
+	case "textDocument/definition", "textDocument/hover", "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		switch req.Method {
+		case "textDocument/definition":
+			return s.definition(doc, params.Position), nil
+		case "textDocument/hover":
+			return s.hover(doc, params.Position), nil
+		default:
+			return s.completion(doc, params.Position), nil
+		}
+
+	case "textDocument/references":
+		var params referenceParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.references(doc, params.Position, params.Context.IncludeDeclaration), nil
+	}
+
+	if strings.HasPrefix(req.Method, "$/") {
+		return nil, nil // optional notifications and requests may be ignored
+	}
+	return nil, &responseError{codeMethodNotFound, "method not found: " + req.Method}
+}
+
+func unmarshalParams(req *request, params interface{}) error {
+	if err := json.Unmarshal(req.Params, params); err != nil {
+		return &responseError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// document returns the open document with the specified URI.
+func (s *server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &responseError{codeInvalidParams, "document not open: " + uri}
+	}
+	return doc, nil
+}
+
+// update analyzes the new text of the document with the specified URI
+// and publishes its diagnostics.
+func (s *server) update(uri, text string) error {
+	doc, ok := s.docs[uri]
+	if !ok {
+		doc = &document{uri: uri, filename: uriToFilename(uri)}
+		s.docs[uri] = doc
+	}
+	s.analyze(doc, text)
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: doc.diags,
+	})
+}
+
+// uriToFilename returns the file name of a file URI.
+// Other URIs are returned unchanged.
+func uriToFilename(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return filepath.FromSlash(u.Path)
+	}
+	return uri
+}
+
+// filenameToURI returns the URI of the named file.
+func filenameToURI(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
@@ -0,0 +1,411 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkmath"
+)
+
+// A client is a stand-in for an editor, connected to a server by pipes.
+type client struct {
+	t      *testing.T
+	in     chan []byte    // messages from the server
+	out    io.WriteCloser // messages to the server
+	done   chan error     // result of server.run
+	nextID int
+	diags  map[string][]diagnostic // most recently published diagnostics, by URI
+}
+
+// startServer starts a server that loads modules from the root
+// directory, and returns a client connected to it.
+func startServer(t *testing.T, predeclared starlark.StringDict, root string) (*client, *server) {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	opts := &resolve.Options{Float: true}
+	s := newServer(serverOut, opts, predeclared, newFileLoader(opts, predeclared, root, nil).load)
+	c := &client{
+		t:     t,
+		in:    make(chan []byte, 100),
+		out:   clientOut,
+		done:  make(chan error, 1),
+		diags: make(map[string][]diagnostic),
+	}
+	go func() {
+		c.done <- s.run(serverIn)
+		serverOut.Close()
+	}()
+	// Read messages concurrently, as pipes are unbuffered
+	// and the server may send notifications at any time.
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			data, err := readMessage(r)
+			if err != nil {
+				close(c.in)
+				return
+			}
+			c.in <- data
+		}
+	}()
+	return c, s
+}
+
+// call sends a request and decodes the result of its response,
+// recording any diagnostics published in the meantime.
+func (c *client) call(method string, params, result interface{}) {
+	c.t.Helper()
+	c.nextID++
+	if err := writeMessage(c.out, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      c.nextID,
+		"method":  method,
+		"params":  params,
+	}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		data, ok := <-c.in
+		if !ok {
+			c.t.Fatalf("%s: connection closed", method)
+		}
+		var msg struct {
+			ID     *int
+			Method string
+			Params json.RawMessage
+			Result json.RawMessage
+			Error  *responseError
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.t.Fatal(err)
+		}
+		switch {
+		case msg.Method == "textDocument/publishDiagnostics":
+			var params publishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				c.t.Fatal(err)
+			}
+			c.diags[params.URI] = params.Diagnostics
+		case msg.ID != nil && *msg.ID == c.nextID:
+			if msg.Error != nil {
+				c.t.Fatalf("%s: %v", method, msg.Error)
+			}
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("%s: decoding result %s: %v", method, msg.Result, err)
+			}
+			return
+		default:
+			c.t.Fatalf("%s: unexpected message %s", method, data)
+		}
+	}
+}
+
+// notify sends a notification.
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	if err := writeMessage(c.out, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// sync waits for the server to handle all notifications sent so far.
+func (c *client) sync() {
+	c.t.Helper()
+	var result interface{}
+	c.call("$/sync", nil, &result)
+}
+
+func positionParams(uri string, line, char int) interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position{line, char},
+	}
+}
+
+const libSrc = `"""A library."""
+def greet(name, *args, **kwargs):
+    """Returns a greeting.
+
+    The name is required.
+    """
+    return "hello " + name
+
+answer = 42
+`
+
+const mainSrc = `load("lib.star", "greet", "missing", a="answer")
+load("nosuch.star", "z")
+
+def f(n):
+    """F doubles n."""
+    m = n * 2
+    return m + undefined
+
+s = "abc"
+print(greet(s), a, f(1), math.pi, z)
+`
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starlark-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib.star")
+	if err := ioutil.WriteFile(lib, []byte(libSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	libURI := filenameToURI(lib)
+	uri := filenameToURI(filepath.Join(dir, "main.star"))
+
+	c, s := startServer(t, starlark.StringDict{"math": starlarkmath.Module}, dir)
+
+	var init struct {
+		Capabilities map[string]interface{}
+	}
+	c.call("initialize", map[string]interface{}{}, &init)
+	if init.Capabilities["hoverProvider"] != true {
+		t.Errorf("initialize: capabilities = %v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	// diagnostics
+	checkDiags := func(want ...string) {
+		t.Helper()
+		c.sync()
+		var got []string
+		for i, d := range c.diags[uri] {
+			msg := fmt.Sprintf("%d:%d: %s", d.Range.Start.Line, d.Range.Start.Character, d.Message)
+			if i < len(want) && strings.HasSuffix(want[i], "...") && strings.HasPrefix(msg, strings.TrimSuffix(want[i], "...")) {
+				msg = want[i] // e.g. an operating system error
+			}
+			got = append(got, msg)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("diagnostics:\ngot  %q\nwant %q", got, want)
+		}
+	}
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "starlark", "version": 1, "text": "x = 1 +\ny = 2 3\n"},
+	})
+	checkDiags(
+		"1:0: got newline, want primary expression",
+		"1:7: got int literal, want newline")
+
+	change := func(text string) {
+		c.notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []interface{}{map[string]string{"text": text}},
+		})
+	}
+	change(mainSrc)
+	checkDiags(
+		"0:27: load: name missing not found in module lib.star",
+		`1:5: cannot load nosuch.star: module "nosuch.star" not found in search path`,
+		"6:15: undefined: undefined")
+
+	// definition
+	for _, test := range []struct {
+		line, char int
+		want       location
+	}{
+		{6, 11, location{uri, textRange{position{5, 4}, position{5, 5}}}},    // m
+		{9, 7, location{libURI, textRange{position{1, 4}, position{1, 9}}}},  // greet
+		{9, 16, location{libURI, textRange{position{8, 0}, position{8, 6}}}}, // a
+		{0, 8, location{URI: libURI}},                                        // "lib.star"
+		{0, 42, location{libURI, textRange{position{8, 0}, position{8, 6}}}}, // "answer"
+		{9, 20, location{uri, textRange{position{3, 4}, position{3, 5}}}},    // f
+		{9, 35, location{uri, textRange{position{1, 21}, position{1, 22}}}},  // z
+		{9, 13, location{uri, textRange{position{8, 0}, position{8, 1}}}},    // s
+	} {
+		var got *location
+		c.call("textDocument/definition", positionParams(uri, test.line, test.char), &got)
+		if got == nil || *got != test.want {
+			t.Errorf("definition at %d:%d = %v, want %v", test.line, test.char, got, test.want)
+		}
+	}
+	var none *location
+	c.call("textDocument/definition", positionParams(uri, 9, 2), &none) // print
+	if none != nil {
+		t.Errorf("definition of built-in = %v, want null", none)
+	}
+
+	// references
+	var refs []location
+	params := map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position{3, 6}, // n
+		"context":      map[string]bool{"includeDeclaration": true},
+	}
+	c.call("textDocument/references", params, &refs)
+	if want := []location{
+		{uri, textRange{position{3, 6}, position{3, 7}}},
+		{uri, textRange{position{5, 8}, position{5, 9}}},
+	}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("references = %v, want %v", refs, want)
+	}
+
+	// hover
+	for _, test := range []struct {
+		line, char int
+		want       string
+	}{
+		{9, 7, "```starlark\ndef greet(name, *args, **kwargs)\n```\n\nReturns a greeting.\n\nThe name is required."},
+		{9, 19, "```starlark\ndef f(n)\n```\n\nF doubles n."},
+		{5, 8, "```starlark\n(parameter) n\n```"},
+		{5, 4, "```starlark\n(local variable) m\n```"},
+		{8, 0, "```starlark\n(global variable) s\n```"},
+		{9, 2, "```starlark\n(builtin) print: builtin_function_or_method\n```"},
+		{9, 16, "```starlark\n(loaded from \"lib.star\") a: int\n```"},
+		{9, 34, "```starlark\n(loaded from \"nosuch.star\") z\n```"},
+	} {
+		var got hover
+		c.call("textDocument/hover", positionParams(uri, test.line, test.char), &got)
+		if got.Contents.Value != test.want {
+			t.Errorf("hover at %d:%d = %q, want %q", test.line, test.char, got.Contents.Value, test.want)
+		}
+	}
+
+	// completion, using the previous syntax tree if the text does not parse
+	change(mainSrc + "s.up\nmath.\n")
+	c.sync()
+	for _, test := range []struct {
+		line, char int
+		want, bad  string
+	}{
+		{10, 4, "upper", "lower"},
+		{11, 5, "sqrt", "upper"},
+		{10, 1, "sorted", "upper"},
+		{6, 12, "math", "n"},
+		{6, 12, "m", "f"},
+	} {
+		var items []completionItem
+		c.call("textDocument/completion", positionParams(uri, test.line, test.char), &items)
+		var found, bad bool
+		for _, item := range items {
+			found = found || item.Label == test.want
+			bad = bad || item.Label == test.bad
+		}
+		if !found || bad {
+			t.Errorf("completion at %d:%d = %v, want %s but not %s", test.line, test.char, items, test.want, test.bad)
+		}
+	}
+
+	// closing clears diagnostics
+	c.notify("textDocument/didClose", map[string]interface{}{"textDocument": map[string]string{"uri": uri}})
+	checkDiags()
+
+	var result interface{}
+	c.call("shutdown", nil, &result)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+	if !s.shutdown {
+		t.Error("server did not record shutdown request")
+	}
+}
+
+func TestFileLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starlark-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, src string) {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("pkg/a.star", `load(":b.star", "b")
+load("lib.star", l="lib")
+a = b
+x = compute()  # predeclared, and not called
+def f():
+    while True:
+        pass
+`)
+	write("pkg/b.star", `b = [1]`)
+	write("third_party/lib.star", `lib = "lib"`)
+	write("cycle.star", `load(":cycle.star", "x")`)
+	write("bad.star", `load("//pkg:b.star", "nosuch")`)
+
+	opts := &resolve.Options{Recursion: true}
+	predeclared := starlark.StringDict{"compute": nil}
+	l := newFileLoader(opts, predeclared, dir, []string{"third_party"})
+	globals := func(from, module string) string {
+		m := l.load(from, module)
+		if m.err != nil {
+			return m.err.Error()
+		}
+		var names []string
+		for _, name := range m.globals.Keys() {
+			names = append(names, fmt.Sprintf("%s:%s", name, typeName(m.globals[name])))
+		}
+		return strings.Join(names, " ")
+	}
+
+	main := filepath.Join(dir, "main.star")
+	for _, test := range []struct{ from, module, want string }{
+		{main, "//pkg:a.star", "a: b:list f: l:string x:"},
+		{main, "./pkg/b.star", "b:list"},
+		{filepath.Join(dir, "pkg", "c.star"), ":b.star", "b:list"},
+		{filepath.Join(os.TempDir(), "elsewhere.star"), "//pkg/b.star", "b:list"},
+		{main, "//cycle.star", "cannot load :cycle.star: cycle in load graph"},
+		{main, "//bad.star", "load: name nosuch not found in module //pkg:b.star"},
+		{main, "b.star", `module "b.star" not found in search path`},
+	} {
+		if got := globals(test.from, test.module); got != test.want {
+			t.Errorf("load(%q, %q) = %s, want %s", test.from, test.module, got, test.want)
+		}
+	}
+	if m := l.load(main, "//pkg:a.star"); m.def("f") == nil || m.def("a") != nil {
+		t.Errorf("module defs: f = %v, a = %v", m.def("f"), m.def("a"))
+	}
+
+	// Modifying a loaded module invalidates the modules that load it.
+	write("pkg/b.star", `b = "b"`)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "pkg", "b.star"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := globals(main, "//pkg:a.star"), "a: b:string f: l:string x:"; got != want {
+		t.Errorf("after modification, load = %s, want %s", got, want)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("Content-Length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}Content-Length: 3\r\n\r\n[1]"))
+	for _, want := range []string{"{}", "[1]"} {
+		data, err := readMessage(r)
+		if err != nil || string(data) != want {
+			t.Errorf("readMessage() = %q, %v, want %q", data, err, want)
+		}
+	}
+	if _, err := readMessage(r); err != io.EOF {
+		t.Errorf("readMessage() at end = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,473 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the 'starlark dap' subcommand, a debug adapter.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+const dapUsage = `usage: starlark dap [-listen address]
+
+Dap runs a debug adapter, which lets an editor debug a Starlark program
+using the Debug Adapter Protocol. It communicates over the standard
+input and output, or, with -listen, over a single connection accepted
+at the specified TCP address.
+
+The "program" argument of the launch request names the file to execute,
+and the "stopOnEntry" argument requests a stop at its first line. The
+dialect flags of the starlark command apply to the program.
+`
+
+func dapMain(args []string) int {
+	fs := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := fs.String("listen", "", "accept a connection at TCP `address` instead of using standard input and output")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, dapUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stdout
+	if *listen != "" {
+		ln, err := net.Listen("tcp", *listen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "starlark dap: %v\n", err)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "starlark dap: listening at %s\n", ln.Addr())
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "starlark dap: %v\n", err)
+			return 2
+		}
+		defer conn.Close()
+		in, out = conn, conn
+	}
+
+	if err := newDAPSession(out).serve(in); err != nil {
+		fmt.Fprintf(os.Stderr, "starlark dap: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// A dapRequest is a request from the client.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// A dapResponse is the response to a request.
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"` // "response"
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// A dapEvent is an event sent to the client.
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"` // "event"
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// The adapter presents the program's execution as a single thread.
+const dapThreadID = 1
+
+// A dapSession is a debugging session with a single client.
+type dapSession struct {
+	mu  sync.Mutex // guards out and seq
+	out io.Writer
+	seq int
+
+	program     string // absolute file name of the program
+	stopOnEntry bool
+	thread      *starlark.Thread
+	debugger    *debugger
+	running     bool
+	frames      []*starlark.Frame // frames of the stopped thread, from the top
+	terminated  chan struct{}     // closed when execution ends
+}
+
+func newDAPSession(out io.Writer) *dapSession {
+	s := &dapSession{
+		out:        out,
+		thread:     &starlark.Thread{Name: "main", Load: makeDebugLoad()},
+		terminated: make(chan struct{}),
+	}
+	s.debugger = newDebugger(func(reason string) {
+		s.send(&dapEvent{Type: "event", Event: "stopped", Body: map[string]interface{}{
+			"reason":            reason,
+			"threadId":          dapThreadID,
+			"allThreadsStopped": true,
+		}})
+	})
+	return s
+}
+
+// send sends a response or event to the client.
+func (s *dapSession) send(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *dapResponse:
+		msg.Seq = s.seq
+	case *dapEvent:
+		msg.Seq = s.seq
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err) // all messages are encodable
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// serve handles requests from in until the client disconnects.
+func (s *dapSession) serve(in io.Reader) error {
+	r := bufio.NewReader(in)
+	for {
+		data, err := readDAPMessage(r)
+		if err == io.EOF {
+			s.terminate()
+			return nil
+		} else if err != nil {
+			s.terminate()
+			return err
+		}
+		var req dapRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.terminate()
+			return fmt.Errorf("invalid message: %v", err)
+		}
+
+		body, err := s.handle(&req)
+		resp := &dapResponse{
+			Type:       "response",
+			RequestSeq: req.Seq,
+			Command:    req.Command,
+			Success:    err == nil,
+			Body:       body,
+		}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		s.send(resp)
+
+		switch req.Command {
+		case "initialize":
+			s.send(&dapEvent{Type: "event", Event: "initialized"})
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// readDAPMessage reads the content of the next message from r.
+func readDAPMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading message header: %v", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("reading message content: %v", err)
+	}
+	return data, nil
+}
+
+// handle handles a request and returns the body of the response.
+func (s *dapSession) handle(req *dapRequest) (interface{}, error) {
+	var args struct {
+		// launch
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+
+		// setBreakpoints
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int32 `json:"line"`
+		} `json:"breakpoints"`
+
+		// scopes, evaluate
+		FrameID int `json:"frameId"`
+
+		// variables
+		VariablesReference int `json:"variablesReference"`
+
+		// evaluate
+		Expression string `json:"expression"`
+	}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+
+	case "launch":
+		if args.Program == "" {
+			return nil, errors.New("launch: no program specified")
+		}
+		program, err := filepath.Abs(args.Program)
+		if err != nil {
+			return nil, err
+		}
+		s.program, s.stopOnEntry = program, args.StopOnEntry
+		return nil, nil
+
+	case "setBreakpoints":
+		filename, err := filepath.Abs(args.Source.Path)
+		if err != nil {
+			return nil, err
+		}
+		var lines []int32
+		var breakpoints []interface{}
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+			breakpoints = append(breakpoints, map[string]interface{}{"verified": true, "line": bp.Line})
+		}
+		s.debugger.setBreakpoints(filename, lines)
+		return map[string]interface{}{"breakpoints": breakpoints}, nil
+
+	case "setExceptionBreakpoints":
+		return nil, nil
+
+	case "configurationDone":
+		if s.program == "" {
+			return nil, errors.New("configurationDone: no launch request")
+		}
+		if !s.running {
+			s.running = true
+			go s.run()
+		}
+		return nil, nil
+
+	case "threads":
+		return map[string]interface{}{
+			"threads": []interface{}{map[string]interface{}{"id": dapThreadID, "name": "main"}},
+		}, nil
+
+	case "stackTrace":
+		s.frames = nil
+		for fr := s.debugger.stoppedFrame(); fr != nil; fr = fr.Parent() {
+			s.frames = append(s.frames, fr)
+		}
+		frames := []interface{}{}
+		for i, fr := range s.frames {
+			pos := fr.Position()
+			if pos.Col < 1 {
+				pos.Col = 1 // columns are 1-based
+			}
+			frame := map[string]interface{}{
+				"id":     i + 1,
+				"name":   fr.Callable().Name(),
+				"line":   pos.Line,
+				"column": pos.Col,
+			}
+			if _, ok := fr.Callable().(*starlark.Function); ok {
+				frame["source"] = map[string]interface{}{
+					"name": filepath.Base(pos.Filename()),
+					"path": pos.Filename(),
+				}
+			}
+			frames = append(frames, frame)
+		}
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		if _, err := s.frame(args.FrameID); err != nil {
+			return nil, err
+		}
+		// Variable references 2i-1 and 2i denote
+		// the locals and globals of frame i.
+		return map[string]interface{}{
+			"scopes": []interface{}{
+				map[string]interface{}{"name": "Locals", "variablesReference": 2*args.FrameID - 1},
+				map[string]interface{}{"name": "Globals", "variablesReference": 2 * args.FrameID},
+			},
+		}, nil
+
+	case "variables":
+		fr, err := s.frame((args.VariablesReference + 1) / 2)
+		if err != nil {
+			return nil, err
+		}
+		var vars starlark.StringDict
+		if fn, ok := fr.Callable().(*starlark.Function); ok {
+			if args.VariablesReference%2 == 1 {
+				vars = fr.Locals()
+			} else {
+				vars = fn.Globals()
+			}
+		}
+		variables := []interface{}{}
+		for _, name := range vars.Keys() {
+			v := vars[name]
+			variables = append(variables, map[string]interface{}{
+				"name":               name,
+				"value":              v.String(),
+				"type":               v.Type(),
+				"variablesReference": 0,
+			})
+		}
+		return map[string]interface{}{"variables": variables}, nil
+
+	case "evaluate":
+		fr, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		v, err := fr.Eval(&starlark.Thread{Name: "evaluate"}, args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": v.String(), "type": v.Type(), "variablesReference": 0}, nil
+
+	case "continue", "next", "stepIn", "stepOut":
+		mode := map[string]stepMode{
+			"continue": modeRun,
+			"next":     modeStepOver,
+			"stepIn":   modeStepIn,
+			"stepOut":  modeStepOut,
+		}[req.Command]
+		s.frames = nil
+		if !s.debugger.cont(mode) {
+			return nil, fmt.Errorf("%s: program is not stopped", req.Command)
+		}
+		if req.Command == "continue" {
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+
+	case "pause":
+		s.debugger.pause()
+		return nil, nil
+
+	case "terminate", "disconnect":
+		s.terminate()
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// frame returns the stack frame with the specified ID,
+// as reported by the most recent stackTrace request.
+func (s *dapSession) frame(id int) (*starlark.Frame, error) {
+	if id < 1 || id > len(s.frames) {
+		return nil, fmt.Errorf("invalid frame %d", id)
+	}
+	return s.frames[id-1], nil
+}
+
+// terminate stops execution of the program, if it is running,
+// and waits for it to end.
+func (s *dapSession) terminate() {
+	if !s.running {
+		return
+	}
+	s.thread.Cancel("terminated by debugger")
+	s.debugger.terminate()
+	<-s.terminated
+}
+
+// run executes the program, reporting its output and termination.
+func (s *dapSession) run() {
+	defer close(s.terminated)
+	output := func(category, text string) {
+		s.send(&dapEvent{Type: "event", Event: "output", Body: map[string]string{
+			"category": category,
+			"output":   text,
+		}})
+	}
+
+	s.debugger.entry = s.stopOnEntry
+	s.thread.Print = func(_ *starlark.Thread, msg string) { output("stdout", msg+"\n") }
+	s.thread.SetDebugHook(s.debugger.hook)
+	_, err := starlark.ExecFileOptions(&opts, s.thread, s.program, nil, modules)
+
+	exitCode := 0
+	if err != nil {
+		exitCode = 1
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			output("stderr", evalErr.Backtrace()+"\n")
+		} else {
+			output("stderr", err.Error()+"\n")
+		}
+	}
+	s.send(&dapEvent{Type: "event", Event: "exited", Body: map[string]int{"exitCode": exitCode}})
+	s.send(&dapEvent{Type: "event", Event: "terminated"})
+}
+
+// makeDebugLoad returns a load function that executes each module in
+// the loading thread, so that it may be debugged. The name of a module
+// is a file name relative to the directory of the loading file.
+func makeDebugLoad() func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	type entry struct {
+		globals starlark.StringDict
+		err     error
+	}
+	cache := make(map[string]*entry) // nil entry => module is being loaded
+	return func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		filename := module
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(filepath.Dir(thread.TopFrame().Position().Filename()), module)
+		}
+		e, ok := cache[filename]
+		if e == nil {
+			if ok {
+				return nil, fmt.Errorf("cycle in load graph")
+			}
+			cache[filename] = nil
+			e = new(entry)
+			e.globals, e.err = starlark.ExecFileOptions(&opts, thread, filename, nil, modules)
+			cache[filename] = e
+		}
+		return e.globals, e.err
+	}
+}
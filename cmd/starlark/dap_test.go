@@ -0,0 +1,340 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// A dapClient is a stand-in for an editor, connected to a debug adapter by pipes.
+type dapClient struct {
+	t       *testing.T
+	in      chan *dapMessage // messages from the adapter
+	out     io.WriteCloser   // messages to the adapter
+	done    chan error       // result of dapSession.serve
+	seq     int
+	events  []*dapMessage // events received but not yet awaited
+	outputs []string      // text of output events
+}
+
+// A dapMessage is a response or event received by the client.
+type dapMessage struct {
+	Type       string
+	RequestSeq int `json:"request_seq"`
+	Command    string
+	Success    bool
+	Message    string
+	Event      string
+	Body       json.RawMessage
+}
+
+// startDAP starts a debug adapter and returns a client connected to it.
+func startDAP(t *testing.T) *dapClient {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &dapClient{
+		t:    t,
+		in:   make(chan *dapMessage, 100),
+		out:  clientOut,
+		done: make(chan error, 1),
+	}
+	go func() {
+		c.done <- newDAPSession(serverOut).serve(serverIn)
+		serverOut.Close()
+	}()
+	// Read messages concurrently, as pipes are unbuffered
+	// and the adapter may send events at any time.
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			data, err := readDAPMessage(r)
+			if err != nil {
+				close(c.in)
+				return
+			}
+			msg := new(dapMessage)
+			if err := json.Unmarshal(data, msg); err != nil {
+				panic(err)
+			}
+			c.in <- msg
+		}
+	}()
+	return c
+}
+
+// receive returns the next message from the adapter,
+// recording the text of output events.
+func (c *dapClient) receive(what string) *dapMessage {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.in:
+		if !ok {
+			c.t.Fatalf("%s: connection closed", what)
+		}
+		if msg.Event == "output" {
+			var body struct{ Output string }
+			json.Unmarshal(msg.Body, &body)
+			c.outputs = append(c.outputs, body.Output)
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		c.t.Fatalf("%s: timed out", what)
+		return nil
+	}
+}
+
+// request sends a request, and decodes the body of its successful
+// response into body, if not nil. Events received in the meantime
+// are saved for wait.
+func (c *dapClient) request(command string, args, body interface{}) {
+	c.t.Helper()
+	if err := c.send(command, args); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.receive(command)
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("%s: unexpected response %+v", command, msg)
+		}
+		if !msg.Success {
+			c.t.Fatalf("%s: %s", command, msg.Message)
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatalf("%s: decoding body %s: %v", command, msg.Body, err)
+			}
+		}
+		return
+	}
+}
+
+// send sends a request without awaiting its response.
+func (c *dapClient) send(command string, args interface{}) error {
+	c.seq++
+	data, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// wait awaits the named event, discarding others,
+// and decodes its body into body, if not nil.
+func (c *dapClient) wait(event string, body interface{}) {
+	c.t.Helper()
+	for {
+		var msg *dapMessage
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.receive(event)
+		}
+		if msg.Type == "event" && msg.Event == event {
+			if body != nil {
+				if err := json.Unmarshal(msg.Body, body); err != nil {
+					c.t.Fatalf("%s: decoding body %s: %v", event, msg.Body, err)
+				}
+			}
+			return
+		}
+	}
+}
+
+// stopped awaits a stopped event, and returns its reason
+// and the function, file, and line of each frame of the stack.
+func (c *dapClient) stopped() (reason string, stack []string) {
+	c.t.Helper()
+	var event struct{ Reason string }
+	c.wait("stopped", &event)
+	var trace struct {
+		StackFrames []struct {
+			Name   string
+			Line   int
+			Source struct{ Name string }
+		}
+	}
+	c.request("stackTrace", map[string]int{"threadId": dapThreadID}, &trace)
+	for _, fr := range trace.StackFrames {
+		stack = append(stack, fmt.Sprintf("%s %s:%d", fr.Name, fr.Source.Name, fr.Line))
+	}
+	return event.Reason, stack
+}
+
+// evaluate returns the value of an expression in the specified frame.
+func (c *dapClient) evaluate(frame int, expr string) string {
+	c.t.Helper()
+	var result struct{ Result string }
+	c.request("evaluate", map[string]interface{}{"frameId": frame, "expression": expr}, &result)
+	return result.Result
+}
+
+// launch writes the files of a program to a new directory, and starts
+// it in a new adapter, with breakpoints at the specified lines of the
+// named file.
+func launch(t *testing.T, files map[string]string, bpfile string, bplines ...int) (*dapClient, string) {
+	dir, err := ioutil.TempDir("", "starlark-dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := startDAP(t)
+	c.request("initialize", map[string]string{"adapterID": "starlark"}, nil)
+	c.wait("initialized", nil)
+	c.request("launch", map[string]interface{}{"program": filepath.Join(dir, "main.star")}, nil)
+	var breakpoints []interface{}
+	for _, line := range bplines {
+		breakpoints = append(breakpoints, map[string]int{"line": line})
+	}
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": filepath.Join(dir, bpfile)},
+		"breakpoints": breakpoints,
+	}, nil)
+	c.request("configurationDone", nil, nil)
+	return c, dir
+}
+
+const dapLibSrc = `def double(x):
+    y = x * 2
+    return y
+`
+
+const dapMainSrc = `load("lib.star", "double")
+
+def f(n):
+    m = double(n)
+    return m + 1
+
+a = f(1)
+print("a =", a)
+b = f(a)
+`
+
+func TestDAP(t *testing.T) {
+	c, dir := launch(t, map[string]string{"lib.star": dapLibSrc, "main.star": dapMainSrc}, "main.star", 4)
+	defer os.RemoveAll(dir)
+
+	check := func(wantReason string, wantStack ...string) {
+		t.Helper()
+		reason, stack := c.stopped()
+		if reason != wantReason || strings.Join(stack, "; ") != strings.Join(wantStack, "; ") {
+			t.Errorf("stopped for %s at %q, want %s at %q", reason, stack, wantReason, wantStack)
+		}
+	}
+
+	// breakpoint
+	check("breakpoint", "f main.star:4", "<toplevel> main.star:7")
+	if got := c.evaluate(1, "n + 10"); got != "11" {
+		t.Errorf("evaluate n + 10 = %s, want 11", got)
+	}
+
+	// next steps over the call of double.
+	c.request("next", map[string]int{"threadId": dapThreadID}, nil)
+	check("step", "f main.star:5", "<toplevel> main.star:7")
+	if got := c.evaluate(1, "m"); got != "2" {
+		t.Errorf("evaluate m = %s, want 2", got)
+	}
+
+	// stepIn, then stepOut, of double.
+	c.request("continue", map[string]int{"threadId": dapThreadID}, nil)
+	check("breakpoint", "f main.star:4", "<toplevel> main.star:9")
+	c.request("stepIn", map[string]int{"threadId": dapThreadID}, nil)
+	check("step", "double lib.star:2", "f main.star:4", "<toplevel> main.star:9")
+	if got := c.evaluate(2, "n"); got != "3" {
+		t.Errorf("evaluate n in caller = %s, want 3", got)
+	}
+	c.request("stepOut", map[string]int{"threadId": dapThreadID}, nil)
+	check("step", "f main.star:5", "<toplevel> main.star:9")
+
+	// The output so far, and the globals.
+	if got := strings.Join(c.outputs, ""); got != "a = 3\n" {
+		t.Errorf("output = %q, want %q", got, "a = 3\n")
+	}
+	if got := c.evaluate(2, "a"); got != "3" {
+		t.Errorf("evaluate a = %s, want 3", got)
+	}
+
+	// disconnect terminates the stopped program.
+	c.request("disconnect", nil, nil)
+	c.wait("terminated", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
+
+// TestDebuggerTerminate checks that a thread that reaches a breakpoint
+// after terminate has found it running does not stop, as nothing
+// would resume it.
+func TestDebuggerTerminate(t *testing.T) {
+	var d *debugger
+	d = newDebugger(func(reason string) {
+		t.Errorf("thread stopped for %s after terminate", reason)
+		go d.cont(modeRun)
+	})
+	d.setBreakpoints("main.star", []int32{2})
+	d.terminate() // the thread is running, so there is nothing to resume
+
+	thread := new(starlark.Thread)
+	thread.SetDebugHook(d.hook)
+	if _, err := starlark.ExecFile(thread, "main.star", "x = 1\ny = 2\n", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDAPDisconnect checks that a disconnect request terminates a
+// program that is running or stopping, rather than deadlocking.
+func TestDAPDisconnect(t *testing.T) {
+	const src = `def f():
+    for i in range(1000000):
+        x = i
+f()
+`
+	for i := 0; i < 50; i++ {
+		c, dir := launch(t, map[string]string{"main.star": src}, "main.star", 3)
+		c.wait("stopped", nil)
+		// Resume the thread, which will stop again
+		// at once, and disconnect while it does so.
+		if err := c.send("continue", map[string]int{"threadId": dapThreadID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.send("disconnect", nil); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if msg := c.receive("disconnect"); msg.Type == "response" && msg.Command == "disconnect" {
+				break
+			}
+		}
+		if err := <-c.done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+		os.RemoveAll(dir)
+	}
+}
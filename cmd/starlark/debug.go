@@ -0,0 +1,137 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines a debugger, which controls the execution
+// of a Starlark thread through its debug hook.
+
+import (
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+// A stepMode determines where a resumed thread next stops.
+type stepMode int
+
+const (
+	modeRun      stepMode = iota // stop only at a breakpoint
+	modeStepIn                   // stop at the next line
+	modeStepOver                 // stop at the next line in the same or a calling function
+	modeStepOut                  // stop at the next line in a calling function
+	modePause                    // stop as soon as possible
+)
+
+// A debugger stops a thread at breakpoints and after steps,
+// and resumes it on command.
+type debugger struct {
+	stopped func(reason string) // called when the thread stops, before it blocks
+	resume  chan stepMode       // resumes the stopped thread
+
+	mu          sync.Mutex
+	breakpoints map[string]map[int32]bool // lines with breakpoints, by file name
+	mode        stepMode
+	depth       int             // depth of the frame in which the mode was set
+	entry       bool            // stop at the first line
+	frame       *starlark.Frame // frame of the stopped thread, or nil
+	done        bool            // the thread is terminating and must not stop
+}
+
+func newDebugger(stopped func(reason string)) *debugger {
+	return &debugger{
+		stopped:     stopped,
+		resume:      make(chan stepMode),
+		breakpoints: make(map[string]map[int32]bool),
+	}
+}
+
+// setBreakpoints replaces the breakpoints of the named file.
+func (d *debugger) setBreakpoints(filename string, lines []int32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bps := make(map[int32]bool)
+	for _, line := range lines {
+		bps[line] = true
+	}
+	d.breakpoints[filename] = bps
+}
+
+// pause requests that the running thread stop.
+func (d *debugger) pause() {
+	d.mu.Lock()
+	d.mode = modePause
+	d.mu.Unlock()
+}
+
+// cont resumes the stopped thread in the specified mode.
+// It reports whether the thread was stopped.
+func (d *debugger) cont(mode stepMode) bool {
+	d.mu.Lock()
+	stopped := d.frame != nil
+	d.frame = nil
+	d.mu.Unlock()
+	if stopped {
+		d.resume <- mode
+	}
+	return stopped
+}
+
+// terminate resumes the thread if it is stopped, and prevents it from
+// stopping again, so that it runs until it notices its cancellation.
+func (d *debugger) terminate() {
+	d.mu.Lock()
+	d.done = true
+	d.mu.Unlock()
+	d.cont(modeRun)
+}
+
+// stoppedFrame returns the frame of the stopped thread, or nil.
+func (d *debugger) stoppedFrame() *starlark.Frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.frame
+}
+
+// hook is the debug hook of the thread.
+// It blocks while the thread is stopped.
+func (d *debugger) hook(thread *starlark.Thread, fr *starlark.Frame) {
+	pos := fr.Position()
+	depth := 0
+	for f := fr; f != nil; f = f.Parent() {
+		depth++
+	}
+
+	d.mu.Lock()
+	var reason string
+	switch {
+	case d.done:
+		// Don't stop: terminate would not resume the thread
+		// if it has already found it running.
+	case d.entry:
+		reason = "entry"
+		d.entry = false
+	case d.breakpoints[pos.Filename()][pos.Line]:
+		reason = "breakpoint"
+	case d.mode == modeStepIn,
+		d.mode == modeStepOver && depth <= d.depth,
+		d.mode == modeStepOut && depth < d.depth:
+		reason = "step"
+	case d.mode == modePause:
+		reason = "pause"
+	}
+	if reason == "" {
+		d.mu.Unlock()
+		return
+	}
+	d.frame = fr
+	d.mu.Unlock()
+
+	d.stopped(reason)
+	mode := <-d.resume
+
+	d.mu.Lock()
+	d.mode, d.depth = mode, depth
+	d.mu.Unlock()
+}
@@ -0,0 +1,363 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the 'starlark disasm' subcommand.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/internal/compile"
+	"go.starlark.net/repl"
+	"go.starlark.net/starlark"
+)
+
+const disasmUsage = `usage: starlark disasm [-json] [-predeclared list] file ...
+
+Disasm prints the compiled form of each Starlark file: its tables of
+loads, names, constants, and globals, and for each function, its
+locals, free variables, and bytecode, with the source line of each
+instruction. A file may be a source file, or a compiled program such
+as those saved by the -cache flag.
+
+The dialect flags of the starlark command apply to source files.
+Names predeclared by the application in which a source file is
+executed may be declared by the comma-separated -predeclared list.
+The json, math, and time modules are always predeclared.
+`
+
+func disasmMain(args []string) int {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "print each program as a JSON object")
+	predeclared := fs.String("predeclared", "", "comma-separated `list` of predeclared names")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, disasmUsage)
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	isPredeclared := make(map[string]bool)
+	for name := range modules {
+		isPredeclared[name] = true
+	}
+	for _, name := range strings.Split(*predeclared, ",") {
+		isPredeclared[name] = true
+	}
+
+	status := 0
+	for _, filename := range fs.Args() {
+		prog, err := compileFile(filename, func(name string) bool { return isPredeclared[name] })
+		if err != nil {
+			repl.PrintError(err)
+			status = 1
+			continue
+		}
+		if err := writeDisasm(os.Stdout, prog, *jsonOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "starlark disasm: %v\n", err)
+			return 2
+		}
+	}
+	return status
+}
+
+// writeDisasm writes the disassembly of a program to out,
+// in text form or as a JSON object.
+func writeDisasm(out io.Writer, prog *compile.Program, jsonOutput bool) error {
+	d := disassemble(prog)
+	if jsonOutput {
+		data, err := json.MarshalIndent(d, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+	}
+	d.print(out, newSourceLines())
+	return nil
+}
+
+// compileFile returns the compiled program of the named file,
+// which is either a compiled program or a source file. The
+// isPredeclared function reports the predeclared names of a source file.
+func compileFile(filename string, isPredeclared func(string) bool) (*compile.Program, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("!sky")) {
+		// An entry of a starlark.ProgramCache is
+		// followed by the SHA-256 hash of the program.
+		if n := len(data) - sha256.Size; n > 0 {
+			if sum := sha256.Sum256(data[:n]); bytes.Equal(sum[:], data[n:]) {
+				data = data[:n]
+			}
+		}
+		return compile.DecodeProgram(data)
+	}
+	// Compile the source, then decode the encoded form
+	// to obtain the compiler's internal representation.
+	_, prog, err := starlark.SourceProgramOptions(&opts, filename, data, isPredeclared)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := prog.Write(&buf); err != nil {
+		return nil, err
+	}
+	return compile.DecodeProgram(buf.Bytes())
+}
+
+// A disasmProgram is the disassembly of a compiled program.
+// Its JSON encoding is the output of 'disasm -json'.
+type disasmProgram struct {
+	Filename  string           `json:"filename"`
+	Version   int              `json:"version"`
+	Options   []string         `json:"options"`
+	Loads     []disasmIdent    `json:"loads"`
+	Names     []string         `json:"names"`
+	Constants []disasmConstant `json:"constants"`
+	Globals   []disasmIdent    `json:"globals"`
+	Functions []disasmFunction `json:"functions"` // toplevel first
+}
+
+type disasmIdent struct {
+	Name string `json:"name"`
+	Pos  string `json:"pos"`
+}
+
+type disasmConstant struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type disasmFunction struct {
+	Name       string        `json:"name"`
+	Pos        string        `json:"pos"`
+	MaxStack   int           `json:"maxstack"`
+	NumParams  int           `json:"numparams"`
+	HasVarargs bool          `json:"hasvarargs"`
+	HasKwargs  bool          `json:"haskwargs"`
+	Locals     []disasmIdent `json:"locals"`
+	Freevars   []disasmIdent `json:"freevars"`
+	Code       []disasmInsn  `json:"code"`
+	CodeSize   int           `json:"codesize"`
+}
+
+type disasmInsn struct {
+	PC      uint32  `json:"pc"`
+	Op      string  `json:"op"`
+	Arg     *uint32 `json:"arg,omitempty"` // nil for an instruction without argument
+	Operand string  `json:"operand,omitempty"`
+	Line    int32   `json:"line"`
+	Col     int32   `json:"col"`
+}
+
+func disassemble(prog *compile.Program) *disasmProgram {
+	d := &disasmProgram{
+		Filename:  prog.Toplevel.Pos.Filename(),
+		Version:   compile.Version,
+		Options:   optionNames(prog),
+		Loads:     disasmIdents(prog.Loads),
+		Names:     append([]string{}, prog.Names...),
+		Constants: []disasmConstant{},
+		Globals:   disasmIdents(prog.Globals),
+	}
+	for _, c := range prog.Constants {
+		var dc disasmConstant
+		switch c := c.(type) {
+		case string:
+			dc = disasmConstant{"string", strconv.Quote(c)}
+		case compile.Bytes:
+			dc = disasmConstant{"bytes", "b" + strconv.Quote(string(c))}
+		case int64:
+			dc = disasmConstant{"int", fmt.Sprint(c)}
+		case *big.Int:
+			dc = disasmConstant{"bigint", c.String()}
+		case float64:
+			dc = disasmConstant{"float", strconv.FormatFloat(c, 'g', -1, 64)}
+		default:
+			dc = disasmConstant{fmt.Sprintf("%T", c), fmt.Sprint(c)}
+		}
+		d.Constants = append(d.Constants, dc)
+	}
+	d.Functions = append(d.Functions, disasmFunc(prog.Toplevel))
+	for _, fn := range prog.Functions {
+		d.Functions = append(d.Functions, disasmFunc(fn))
+	}
+	return d
+}
+
+// optionNames returns the names of the dialect flags
+// under which the program was compiled.
+func optionNames(prog *compile.Program) []string {
+	names := []string{}
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"nesteddef", prog.Options.NestedDef},
+		{"lambda", prog.Options.Lambda},
+		{"fp", prog.Options.Float},
+		{"set", prog.Options.Set},
+		{"globalreassign", prog.Options.GlobalReassign},
+		{"bitwise", prog.Options.Bitwise},
+		{"recursion", prog.Options.Recursion},
+		{"try", prog.Options.Try},
+	} {
+		if opt.set {
+			names = append(names, opt.name)
+		}
+	}
+	return names
+}
+
+func disasmIdents(ids []compile.Ident) []disasmIdent {
+	res := []disasmIdent{}
+	for _, id := range ids {
+		res = append(res, disasmIdent{id.Name, id.Pos.String()})
+	}
+	return res
+}
+
+func disasmFunc(fn *compile.Funcode) disasmFunction {
+	d := disasmFunction{
+		Name:       fn.Name,
+		Pos:        fn.Pos.String(),
+		MaxStack:   fn.MaxStack,
+		NumParams:  fn.NumParams,
+		HasVarargs: fn.HasVarargs,
+		HasKwargs:  fn.HasKwargs,
+		Locals:     disasmIdents(fn.Locals),
+		Freevars:   disasmIdents(fn.Freevars),
+		Code:       []disasmInsn{},
+		CodeSize:   len(fn.Code),
+	}
+	for pc := uint32(0); pc < uint32(len(fn.Code)); {
+		op, arg, next, ok := compile.DecodeOp(fn.Code, pc)
+		posn := fn.Position(pc)
+		insn := disasmInsn{PC: pc, Op: op.String(), Line: posn.Line, Col: posn.Col}
+		if !ok {
+			insn.Op = "truncated " + insn.Op
+		} else if op >= compile.OpcodeArgMin {
+			insn.Arg = &arg
+			insn.Operand = operand(fn, op, arg)
+		}
+		d.Code = append(d.Code, insn)
+		pc = next
+	}
+	return d
+}
+
+// operand returns fn.Operand(op, arg), or a description
+// of the problem if arg is out of range.
+func operand(fn *compile.Funcode, op compile.Opcode, arg uint32) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = "invalid operand"
+		}
+	}()
+	return fn.Operand(op, arg)
+}
+
+// print writes the disassembly to out in text form.
+func (d *disasmProgram) print(out io.Writer, src *sourceLines) {
+	fmt.Fprintf(out, "program %s\n", d.Filename)
+	fmt.Fprintf(out, "\tversion %d\n", d.Version)
+	fmt.Fprintf(out, "\toptions%s\n", strings.Join(append([]string{""}, d.Options...), " "))
+	fmt.Fprintf(out, "\tloads\n")
+	for i, id := range d.Loads {
+		fmt.Fprintf(out, "\t\t%d\t%q\t%s\n", i, id.Name, id.Pos)
+	}
+	fmt.Fprintf(out, "\tnames\n")
+	for i, name := range d.Names {
+		fmt.Fprintf(out, "\t\t%d\t%s\n", i, name)
+	}
+	fmt.Fprintf(out, "\tconstants\n")
+	for i, c := range d.Constants {
+		fmt.Fprintf(out, "\t\t%d\t%s\t%s\n", i, c.Type, c.Value)
+	}
+	fmt.Fprintf(out, "\tglobals\n")
+	for i, id := range d.Globals {
+		fmt.Fprintf(out, "\t\t%d\t%s\t%s\n", i, id.Name, id.Pos)
+	}
+
+	for _, fn := range d.Functions {
+		fmt.Fprintf(out, "\nfunction %s %s\n", fn.Name, fn.Pos)
+		params := fmt.Sprint(fn.NumParams)
+		if fn.HasVarargs {
+			params += " +varargs"
+		}
+		if fn.HasKwargs {
+			params += " +kwargs"
+		}
+		fmt.Fprintf(out, "\tparams %s\n", params)
+		fmt.Fprintf(out, "\tmaxstack %d\n", fn.MaxStack)
+		fmt.Fprintf(out, "\tlocals\n")
+		for i, id := range fn.Locals {
+			fmt.Fprintf(out, "\t\t%d\t%s\t%s\n", i, id.Name, id.Pos)
+		}
+		fmt.Fprintf(out, "\tfreevars\n")
+		for i, id := range fn.Freevars {
+			fmt.Fprintf(out, "\t\t%d\t%s\t%s\n", i, id.Name, id.Pos)
+		}
+		fmt.Fprintf(out, "\tcode (%d bytes)\n", fn.CodeSize)
+		line := int32(-1)
+		for _, insn := range fn.Code {
+			if insn.Line != line {
+				line = insn.Line
+				fmt.Fprintf(out, "\t\t# line %d: %s\n", line, src.line(d.Filename, line))
+			}
+			fmt.Fprintf(out, "\t\t%d\t%s", insn.PC, insn.Op)
+			if insn.Arg != nil {
+				fmt.Fprintf(out, "%*s\t%d", 10-len(insn.Op), "", *insn.Arg)
+				if insn.Operand != "" {
+					fmt.Fprintf(out, "\t; %s", insn.Operand)
+				}
+			}
+			fmt.Fprintf(out, "\n")
+		}
+	}
+	fmt.Fprintln(out)
+}
+
+// sourceLines provides the text of the lines of source files.
+type sourceLines struct {
+	files map[string][]string // nil for an unreadable file
+}
+
+func newSourceLines() *sourceLines {
+	return &sourceLines{files: make(map[string][]string)}
+}
+
+// line returns the text of the specified line of the named file,
+// or "" if it is unavailable.
+func (src *sourceLines) line(filename string, line int32) string {
+	lines, ok := src.files[filename]
+	if !ok {
+		if data, err := ioutil.ReadFile(filename); err == nil && !bytes.HasPrefix(data, []byte("!sky")) {
+			lines = strings.Split(string(data), "\n")
+		}
+		src.files[filename] = lines
+	}
+	if line < 1 || int(line) > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
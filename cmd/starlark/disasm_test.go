@@ -0,0 +1,102 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestDisasm checks the output of 'starlark disasm' and 'starlark
+// disasm -json' for a source file, and for the compiled program of
+// that file in a cache entry, against the same golden files.
+func TestDisasm(t *testing.T) {
+	const filename = "testdata/disasm.star"
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedir, err := ioutil.TempDir("", "disasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachedir)
+	if _, err := starlark.NewProgramCache(cachedir).SourceProgram(&opts, filename, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := filepath.Glob(filepath.Join(cachedir, "*.sky"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got cache entries %q (%v), want one", entries, err)
+	}
+
+	for _, test := range []struct {
+		json   bool
+		golden string
+	}{
+		{false, "testdata/disasm.golden"},
+		{true, "testdata/disasm.json.golden"},
+	} {
+		for _, file := range []string{filename, entries[0]} {
+			prog, err := compileFile(file, starlark.StringDict(nil).Has)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := writeDisasm(&buf, prog, test.json); err != nil {
+				t.Fatal(err)
+			}
+			if *update && file == filename {
+				if err := ioutil.WriteFile(test.golden, buf.Bytes(), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(test.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != string(want) {
+				t.Errorf("disassembly of %s differs from %s (run with -update to update it); got:\n%s", file, test.golden, got)
+			}
+		}
+	}
+}
+
+// TestDisasmPredeclared checks that a source file that uses
+// predeclared names can be disassembled.
+func TestDisasmPredeclared(t *testing.T) {
+	f, err := ioutil.TempFile("", "disasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("x = host_fn()\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := compileFile(f.Name(), starlark.StringDict(nil).Has); err == nil || !strings.Contains(err.Error(), "undefined: host_fn") {
+		t.Errorf("compiling without predeclared names returned error %v, want undefined: host_fn", err)
+	}
+	prog, err := compileFile(f.Name(), starlark.StringDict{"host_fn": nil}.Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeDisasm(&buf, prog, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "predeclared") || !strings.Contains(buf.String(), "host_fn") {
+		t.Errorf("disassembly does not load predeclared host_fn:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the 'starlark fmt' subcommand.
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"go.starlark.net/repl"
+	"go.starlark.net/syntax"
+)
+
+const formatUsage = `usage: starlark fmt [-w | -d] [file ...]
+
+Fmt prints the canonical formatting of each Starlark file, or of the
+standard input if no files are given.
+
+With -w, it instead rewrites each file that is not canonically formatted.
+With -d, it instead prints a diff for each such file, and exits with
+status 1 if there were any.
+`
+
+func formatMain(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write result to source file instead of standard output")
+	diff := fs.Bool("d", false, "print diffs instead of formatted source")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, formatUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *write && *diff {
+		fmt.Fprintln(os.Stderr, "starlark fmt: -w and -d are mutually exclusive")
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "starlark fmt: cannot use -w with standard input")
+			return 2
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "starlark fmt: %v\n", err)
+			return 2
+		}
+		return formatFile("<stdin>", src, false, *diff)
+	}
+
+	status := 0
+	for _, filename := range fs.Args() {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "starlark fmt: %v\n", err)
+			status = 2
+			continue
+		}
+		if st := formatFile(filename, src, *write, *diff); st > status {
+			status = st
+		}
+	}
+	return status
+}
+
+// formatFile formats a single file and returns an exit status.
+func formatFile(filename string, src []byte, write, diff bool) int {
+	f, err := syntax.Parse(filename, src, syntax.RetainComments)
+	if err != nil {
+		repl.PrintError(err)
+		return 2
+	}
+	out := syntax.Format(f)
+
+	switch {
+	case write:
+		if !bytes.Equal(src, out) {
+			if err := ioutil.WriteFile(filename, out, 0666); err != nil {
+				fmt.Fprintf(os.Stderr, "starlark fmt: %v\n", err)
+				return 2
+			}
+		}
+	case diff:
+		if !bytes.Equal(src, out) {
+			fmt.Printf("--- %s\n+++ %s (formatted)\n", filename, filename)
+			fmt.Print(unifiedDiff(string(src), string(out)))
+			return 1
+		}
+	default:
+		os.Stdout.Write(out)
+	}
+	return 0
+}
+
+// unifiedDiff returns the hunks of a unified diff between a and b,
+// with three lines of context.
+func unifiedDiff(a, b string) string {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// Compute the longest common subsequence by dynamic programming.
+	// lcs[i][j] is the length of the LCS of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Build the edit script: ' ' keep, '-' delete, '+' insert.
+	type edit struct {
+		op   byte
+		line string
+		i, j int // line indices in x and y before this edit
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	// Group the edits into hunks.
+	const context = 3
+	var buf bytes.Buffer
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk while changes are
+		// separated by at most 2*context lines.
+		end := k
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			n := end
+			for n < len(edits) && edits[n].op == ' ' {
+				n++
+			}
+			if n == len(edits) || n-end > 2*context {
+				end += context
+				if end > len(edits) {
+					end = len(edits)
+				}
+				break
+			}
+			end = n
+		}
+
+		var nx, ny int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				nx++
+			}
+			if e.op != '-' {
+				ny++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(edits[start].i, nx), hunkRange(edits[start].j, ny))
+		for _, e := range edits[start:end] {
+			buf.WriteByte(e.op)
+			buf.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = end
+	}
+	return buf.String()
+}
+
+// hunkRange formats the range of n lines starting at index start.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// splitLines splits s into lines, each retaining its newline.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n') + 1
+		if i == 0 {
+			i = len(s)
+		}
+		lines = append(lines, s[:i])
+		s = s[i:]
+	}
+	return lines
+}
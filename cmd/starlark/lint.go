@@ -0,0 +1,107 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the 'starlark lint' subcommand.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.starlark.net/lint"
+	"go.starlark.net/repl"
+	"go.starlark.net/syntax"
+)
+
+const lintUsage = `usage: starlark lint [-json] [-checks list] [-predeclared list] file ...
+
+Lint reports likely mistakes in Starlark files. It applies the checks
+named by the comma-separated -checks list, or all checks by default,
+and exits with status 1 if there are any findings.
+
+The dialect flags of the starlark command apply to linted files; for
+example, use 'starlark -lambda lint file.star' to permit lambda
+expressions. Names predeclared by the application in which the files
+are executed may be declared by the comma-separated -predeclared list.
+The json, math, and time modules are always predeclared.
+
+Checks:
+`
+
+func lintMain(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "print findings as a JSON array")
+	checkNames := fs.String("checks", "", "comma-separated `list` of checks to apply (default all)")
+	predeclared := fs.String("predeclared", "", "comma-separated `list` of predeclared names")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, lintUsage)
+		for _, check := range lint.Checks {
+			fmt.Fprintf(os.Stderr, "\t%s\n", check.Name())
+		}
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	checks := lint.Checks
+	if *checkNames != "" {
+		checks = nil
+	names:
+		for _, name := range strings.Split(*checkNames, ",") {
+			for _, check := range lint.Checks {
+				if check.Name() == name {
+					checks = append(checks, check)
+					continue names
+				}
+			}
+			fmt.Fprintf(os.Stderr, "starlark lint: unknown check %q\n", name)
+			return 2
+		}
+	}
+
+	isPredeclared := make(map[string]bool)
+	for name := range modules {
+		isPredeclared[name] = true
+	}
+	for _, name := range strings.Split(*predeclared, ",") {
+		isPredeclared[name] = true
+	}
+
+	status := 0
+	findings := []lint.Finding{} // non-nil, so that JSON output is [] not null
+	for _, filename := range fs.Args() {
+		f, err := syntax.Parse(filename, nil, 0)
+		if err != nil {
+			repl.PrintError(err)
+			status = 2
+			continue
+		}
+		findings = append(findings, lint.File(&opts, f, func(name string) bool { return isPredeclared[name] }, checks)...)
+	}
+
+	if *jsonOutput {
+		data, err := json.MarshalIndent(findings, "", "\t")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "starlark lint: %v\n", err)
+			return 2
+		}
+		fmt.Printf("%s\n", data)
+	} else {
+		for _, finding := range findings {
+			fmt.Println(finding)
+		}
+	}
+	if len(findings) > 0 && status == 0 {
+		status = 1
+	}
+	return status
+}
@@ -4,11 +4,19 @@
 
 // The starlark command interprets a Starlark file.
 // With no arguments, it starts a read-eval-print loop (REPL).
+//
+// The command also provides these subcommands:
+//
+//	starlark fmt [-w | -d] [file ...]   format Starlark source files
+//	starlark lint [-json] file ...       report likely mistakes in Starlark files
+//	starlark dap [-listen address]       run a debug adapter for editors
+//	starlark disasm [-json] file ...     print the bytecode of compiled Starlark files
 package main // import "go.starlark.net/cmd/starlark"
 
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"runtime/pprof"
@@ -18,23 +26,51 @@ import (
 	"go.starlark.net/repl"
 	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkmath"
+	"go.starlark.net/starlarktime"
 )
 
 // flags
 var (
-	cpuprofile = flag.String("cpuprofile", "", "gather CPU profile in this file")
+	cpuprofile = flag.String("cpuprofile", "", "gather Go CPU profile of the interpreter in this file")
+	profile    = flag.String("profile", "", "gather pprof profile of Starlark functions in this `file`")
 	showenv    = flag.Bool("showenv", false, "on success, print final global environment")
 	execprog   = flag.String("c", "", "execute program `prog`")
+	cachedir   = flag.String("cache", "", "cache compiled programs in directory `dir`")
+
+	coverprofile = flag.String("coverprofile", "", "write a coverage report of executed lines to `file`")
+	coverformat  = flag.String("coverformat", "go", "format of the coverage report: go (as for 'go test -coverprofile') or lcov")
 )
 
 // non-standard dialect flags
+var opts resolve.Options
+
+// modules are the standard modules, which are predeclared in the main
+// file, loaded modules, and the REPL alike.
+var modules = starlark.StringDict{
+	"json": starlarkjson.Module,
+	"math": starlarkmath.Module,
+	"time": starlarktime.Module,
+}
+
 func init() {
-	flag.BoolVar(&resolve.AllowFloat, "fp", resolve.AllowFloat, "allow floating-point numbers")
-	flag.BoolVar(&resolve.AllowSet, "set", resolve.AllowSet, "allow set data type")
-	flag.BoolVar(&resolve.AllowLambda, "lambda", resolve.AllowLambda, "allow lambda expressions")
-	flag.BoolVar(&resolve.AllowNestedDef, "nesteddef", resolve.AllowNestedDef, "allow nested def statements")
-	flag.BoolVar(&resolve.AllowBitwise, "bitwise", resolve.AllowBitwise, "allow bitwise operations (&, |, ^, ~, <<, and >>)")
-	flag.BoolVar(&resolve.AllowRecursion, "recursion", resolve.AllowRecursion, "allow while statements and recursive functions")
+	flag.BoolVar(&opts.Float, "fp", opts.Float, "allow floating-point numbers")
+	flag.BoolVar(&opts.Set, "set", opts.Set, "allow set data type")
+	flag.BoolVar(&opts.Lambda, "lambda", opts.Lambda, "allow lambda expressions")
+	flag.BoolVar(&opts.NestedDef, "nesteddef", opts.NestedDef, "allow nested def statements")
+	flag.BoolVar(&opts.Bitwise, "bitwise", opts.Bitwise, "allow bitwise operations (&, |, ^, ~, <<, and >>)")
+	flag.BoolVar(&opts.Recursion, "recursion", opts.Recursion, "allow while statements and recursive functions")
+	flag.BoolVar(&opts.Try, "try", opts.Try, "allow try/except statements")
+}
+
+// commands maps the name of each subcommand to its main function,
+// which returns the process exit status.
+var commands = map[string]func(args []string) int{
+	"dap":    dapMain,
+	"disasm": disasmMain,
+	"fmt":    formatMain,
+	"lint":   lintMain,
 }
 
 func main() {
@@ -42,6 +78,10 @@ func main() {
 	log.SetFlags(0)
 	flag.Parse()
 
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		os.Exit(cmd(flag.Args()[1:]))
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -53,26 +93,66 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	thread := &starlark.Thread{Load: repl.MakeLoad()}
+	if *profile != "" {
+		f, err := os.Create(*profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := starlark.StartProfile(f); err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := starlark.StopProfile(); err != nil {
+				log.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *coverformat != "go" && *coverformat != "lcov" {
+		log.Fatalf("invalid -coverformat %q, want go or lcov", *coverformat)
+	}
+
+	var progs *starlark.ProgramCache
+	if *cachedir != "" {
+		progs = starlark.NewProgramCache(*cachedir)
+	}
+
+	thread := &starlark.Thread{Load: repl.MakeLoadCache(&opts, progs, modules)}
 	globals := make(starlark.StringDict)
+	if *coverprofile != "" {
+		thread.SetCoverage(starlark.NewCoverage())
+	}
 
 	switch {
 	case flag.NArg() == 1 || *execprog != "":
 		var (
 			filename string
-			src      interface{}
+			src      []byte
 			err      error
 		)
 		if *execprog != "" {
 			// Execute provided program.
 			filename = "cmdline"
-			src = *execprog
+			src = []byte(*execprog)
 		} else {
 			// Execute specified file.
 			filename = flag.Arg(0)
+			src, err = ioutil.ReadFile(filename)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
 		thread.Name = "exec " + filename
-		globals, err = starlark.ExecFile(thread, filename, src, nil)
+		var prog *starlark.Program
+		prog, err = progs.SourceProgram(&opts, filename, src, modules)
+		if err == nil {
+			globals, err = prog.Init(thread, modules)
+			globals.Freeze()
+		}
+		writeCoverage(thread.Coverage())
 		if err != nil {
 			repl.PrintError(err)
 			os.Exit(1)
@@ -80,7 +160,11 @@ func main() {
 	case flag.NArg() == 0:
 		fmt.Println("Welcome to Starlark (go.starlark.net)")
 		thread.Name = "REPL"
-		repl.REPL(thread, globals)
+		for name, module := range modules {
+			globals[name] = module
+		}
+		repl.REPLOptions(&opts, thread, globals)
+		writeCoverage(thread.Coverage())
 	default:
 		log.Fatal("want at most one Starlark file name")
 	}
@@ -89,7 +173,7 @@ func main() {
 	if *showenv {
 		var names []string
 		for name := range globals {
-			if !strings.HasPrefix(name, "_") {
+			if !strings.HasPrefix(name, "_") && globals[name] != modules[name] {
 				names = append(names, name)
 			}
 		}
@@ -99,3 +183,26 @@ func main() {
 		}
 	}
 }
+
+// writeCoverage writes the coverage report, if any, to the file
+// specified by the -coverprofile flag.
+func writeCoverage(cov *starlark.Coverage) {
+	if cov == nil {
+		return
+	}
+	f, err := os.Create(*coverprofile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *coverformat == "lcov" {
+		err = cov.WriteLCOV(f)
+	} else {
+		err = cov.WriteGoCover(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
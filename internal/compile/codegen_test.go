@@ -59,12 +59,13 @@ func TestPlusFolding(t *testing.T) {
 			t.Errorf("#%d: %v", i, err)
 			continue
 		}
-		locals, err := resolve.Expr(expr, isPredeclared, isUniversal)
+		opts := new(resolve.Options)
+		locals, err := resolve.ExprOptions(opts, expr, isPredeclared, isUniversal)
 		if err != nil {
 			t.Errorf("#%d: %v", i, err)
 			continue
 		}
-		got := disassemble(Expr(expr, "<expr>", locals))
+		got := disassemble(Expr(opts, expr, "<expr>", locals))
 		if test.want != got {
 			t.Errorf("expression <<%s>> generated <<%s>>, want <<%s>>",
 				test.src, got, test.want)
@@ -14,21 +14,23 @@
 //     All elements are initially nil.
 //   - two maps of predeclared and universal identifiers.
 //
-// A line number table maps each program counter value to a source position;
-// these source positions do not currently record column information.
+// A line number table maps each program counter value to a source
+// position, including its column.
 //
 // Operands, logically uint32s, are encoded using little-endian 7-bit
 // varints, the top bit indicating that more bytes follow.
-//
 package compile // import "go.starlark.net/internal/compile"
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"sync"
 
 	"go.starlark.net/resolve"
 	"go.starlark.net/syntax"
@@ -37,7 +39,7 @@ import (
 const debug = false // TODO(adonovan): use a bitmap of options; and regexp to match files
 
 // Increment this to force recompilation of saved bytecode files.
-const Version = 5
+const Version = 10
 
 type Opcode uint8
 
@@ -101,6 +103,7 @@ const (
 	SLICE       //   x lo hi step SLICE slice
 	INPLACE_ADD //            x y INPLACE_ADD z      where z is x+y or x.extend(y)
 	MAKEDICT    //              - MAKEDICT dict
+	POPEXCEPT   //              - POPEXCEPT -    [pops the handler stack]
 
 	// --- opcodes with an argument must go below this line ---
 
@@ -109,6 +112,8 @@ const (
 	CJMP    //         cond CJMP<addr>    -
 	ITERJMP //            - ITERJMP<addr> elem   (and fall through) [acts on topmost iterator]
 	//       or:          - ITERJMP<addr> -      (and jump)
+	SETUPEXCEPT //        - SETUPEXCEPT<addr> -  (and fall through) [pushes the handler stack]
+	//           or:      - SETUPEXCEPT<addr> error (at addr, after a later error)
 
 	CONSTANT    //                - CONSTANT<constant>  value
 	MAKETUPLE   //        x1 ... xn MAKETUPLE<n>        tuple
@@ -184,6 +189,7 @@ var opcodeNames = [...]string{
 	PIPE:        "pipe",
 	PLUS:        "plus",
 	POP:         "pop",
+	POPEXCEPT:   "popexcept",
 	PREDECLARED: "predeclared",
 	RETURN:      "return",
 	SETDICT:     "setdict",
@@ -192,6 +198,7 @@ var opcodeNames = [...]string{
 	SETGLOBAL:   "setglobal",
 	SETINDEX:    "setindex",
 	SETLOCAL:    "setlocal",
+	SETUPEXCEPT: "setupexcept",
 	SLASH:       "slash",
 	SLASHSLASH:  "slashslash",
 	SLICE:       "slice",
@@ -253,6 +260,7 @@ var stackEffect = [...]int8{
 	PIPE:        -1,
 	PLUS:        -1,
 	POP:         -1,
+	POPEXCEPT:   0,
 	PREDECLARED: +1,
 	RETURN:      -1,
 	SETDICT:     -3,
@@ -261,6 +269,7 @@ var stackEffect = [...]int8{
 	SETGLOBAL:   -1,
 	SETINDEX:    -3,
 	SETLOCAL:    -1,
+	SETUPEXCEPT: 0,
 	SLASH:       -1,
 	SLASHSLASH:  -1,
 	SLICE:       -3,
@@ -284,14 +293,19 @@ func (op Opcode) String() string {
 // Programs are serialized by the gobProgram function,
 // which must be updated whenever this declaration is changed.
 type Program struct {
-	Loads     []Ident       // name (really, string) and position of each load stmt
-	Names     []string      // names of attributes and predeclared variables
-	Constants []interface{} // = string | int64 | float64 | *big.Int
+	Options   resolve.Options // dialect under which the program was resolved
+	Loads     []Ident         // name (really, string) and position of each load stmt
+	Names     []string        // names of attributes and predeclared variables
+	Constants []interface{}   // = string | int64 | float64 | *big.Int | Bytes
 	Functions []*Funcode
 	Globals   []Ident  // for error messages and tracing
 	Toplevel  *Funcode // module initialization function
 }
 
+// Bytes is the type of a bytes literal constant (b"...") in
+// Program.Constants, distinguishing it from a string constant.
+type Bytes string
+
 // A Funcode is the code of a compiled Starlark function.
 //
 // Funcodes are serialized by the gobFunc function,
@@ -302,12 +316,15 @@ type Funcode struct {
 	Name                  string          // name of this function
 	Doc                   string          // docstring of this function
 	Code                  []byte          // the byte code
-	pclinetab             []uint16        // mapping from pc to linenum
+	pclinetab             []byte          // mapping from pc to line and column
 	Locals                []Ident         // for error messages and tracing
 	Freevars              []Ident         // for tracing
 	MaxStack              int
 	NumParams             int
 	HasVarargs, HasKwargs bool
+
+	linesOnce sync.Once
+	lines     []int32 // line of each pc, built from pclinetab by LineAt
 }
 
 // An Ident is the name and position of an identifier.
@@ -329,14 +346,16 @@ type pcomp struct {
 type fcomp struct {
 	fn *Funcode // what we're building
 
-	pcomp *pcomp
-	pos   syntax.Position // current position of generated code
-	loops []loop
-	block *block
+	pcomp    *pcomp
+	pos      syntax.Position // current position of generated code
+	loops    []loop
+	block    *block
+	handlers int // number of active exception handlers
 }
 
 type loop struct {
 	break_, continue_ *block
+	handlers          int // number of active exception handlers at loop entry
 }
 
 type block struct {
@@ -345,6 +364,8 @@ type block struct {
 	// If the last insn is a RETURN, jmp and cjmp are nil.
 	// If the last insn is a CJMP or ITERJMP,
 	//  cjmp and jmp are the "true" and "false" successors.
+	// If the last insn is a SETUPEXCEPT,
+	//  cjmp is the handler and jmp is the protected block.
 	// Otherwise, jmp is the sole successor.
 	jmp, cjmp *block
 
@@ -356,24 +377,22 @@ type block struct {
 }
 
 type insn struct {
-	op   Opcode
-	arg  uint32
-	line int32
+	op        Opcode
+	arg       uint32
+	line, col int32
 }
 
 func (fn *Funcode) Position(pc uint32) syntax.Position {
 	// Conceptually the table contains rows of the form (pc uint32,
-	// line int32).  Since the pc always increases, usually by a
-	// small amount, and the line number typically also does too
+	// line int32, col int32).  Since the pc always increases, usually
+	// by a small amount, and the line number typically also does too
 	// although it may decrease, again typically by a small amount,
-	// we use delta encoding, starting from {pc: 0, line: 0}.
-	//
-	// Each entry is encoded in 16 bits.
-	// The top 8 bits are the unsigned delta pc; the next 7 bits are
-	// the signed line number delta; and the bottom bit indicates
-	// that more rows follow because one of the deltas was maxed out.
+	// we use delta encoding, starting from {pc: 0, line: 0, col: 0}.
 	//
-	// TODO(adonovan): opt: improve the encoding; include the column.
+	// Each row is encoded as three varints: the unsigned pc delta,
+	// and the signed (zig-zag) line and column deltas. A small delta
+	// occupies a single byte, and deltas of any size may be
+	// represented. Rows are recorded only where the position changes.
 
 	pos := fn.Pos // copy the (annoyingly inaccessible) filename
 	pos.Line = 0
@@ -382,19 +401,94 @@ func (fn *Funcode) Position(pc uint32) syntax.Position {
 	// Position returns the record for the
 	// largest PC value not greater than 'pc'.
 	var prevpc uint32
-	complete := true
-	for _, x := range fn.pclinetab {
-		nextpc := prevpc + uint32(x>>8)
-		if complete && nextpc > pc {
-			return pos
+	for tab := fn.pclinetab; len(tab) > 0; {
+		var dpc uint32
+		var dline, dcol int32
+		dpc, dline, dcol, tab = decodeRow(tab)
+		if prevpc+dpc > pc {
+			break
 		}
-		prevpc = nextpc
-		pos.Line += int32(int8(x) >> 1) // sign extend Δline from 7 to 32 bits
-		complete = (x & 1) == 0
+		prevpc += dpc
+		pos.Line += dline
+		pos.Col += dcol
 	}
 	return pos
 }
 
+// LineAt returns the line number of the instruction at pc; it is
+// equivalent to Position(pc).Line. Whereas Position decodes the line
+// number table on each call, LineAt decodes it once, into a table of
+// the line of each pc, so it is suitable for use at every instruction.
+func (fn *Funcode) LineAt(pc uint32) int32 {
+	fn.linesOnce.Do(func() {
+		lines := make([]int32, len(fn.Code))
+		var nextpc, fill uint32
+		var line int32
+		for tab := fn.pclinetab; len(tab) > 0; {
+			var dpc uint32
+			var dline int32
+			dpc, dline, _, tab = decodeRow(tab)
+			nextpc += dpc
+			// pcs before nextpc have the line of the previous row.
+			for ; fill < nextpc && int(fill) < len(lines); fill++ {
+				lines[fill] = line
+			}
+			line += dline
+		}
+		for ; int(fill) < len(lines); fill++ {
+			lines[fill] = line
+		}
+		fn.lines = lines
+	})
+	if int(pc) >= len(fn.lines) {
+		return fn.Position(pc).Line
+	}
+	return fn.lines[pc]
+}
+
+// Lines returns the distinct line numbers in the line number table
+// of the function, in increasing order. These are the lines at which
+// execution of the function may stop, and are used for coverage.
+func (fn *Funcode) Lines() []int32 {
+	seen := make(map[int32]bool)
+	var lines []int32
+	var line int32
+	for tab := fn.pclinetab; len(tab) > 0; {
+		var dline int32
+		_, dline, _, tab = decodeRow(tab)
+		line += dline
+		if !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
+}
+
+// appendRow appends a row of deltas to a line number table.
+// See Funcode.Position for the encoding.
+func appendRow(tab []byte, dpc uint32, dline, dcol int32) []byte {
+	var buf [3 * binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(buf[:], uint64(dpc))
+	n += binary.PutVarint(buf[n:], int64(dline))
+	n += binary.PutVarint(buf[n:], int64(dcol))
+	return append(tab, buf[:n]...)
+}
+
+// decodeRow decodes the first row of a line number table,
+// and returns its deltas and the remainder of the table.
+// It panics if the table is malformed.
+func decodeRow(tab []byte) (dpc uint32, dline, dcol int32, rest []byte) {
+	x, n := binary.Uvarint(tab)
+	y, m := binary.Varint(tab[n:])
+	z, k := binary.Varint(tab[n+m:])
+	if n <= 0 || m <= 0 || k <= 0 {
+		panic("corrupt line number table")
+	}
+	return uint32(x), int32(y), int32(z), tab[n+m+k:]
+}
+
 // idents convert syntactic identifiers to compiled form.
 func idents(ids []*syntax.Ident) []Ident {
 	res := make([]Ident, len(ids))
@@ -406,16 +500,19 @@ func idents(ids []*syntax.Ident) []Ident {
 }
 
 // Expr compiles an expression to a program consisting of a single toplevel function.
-func Expr(expr syntax.Expr, name string, locals []*syntax.Ident) *Funcode {
+// The options are those under which the expression was resolved.
+func Expr(opts *resolve.Options, expr syntax.Expr, name string, locals []*syntax.Ident) *Funcode {
 	pos := syntax.Start(expr)
 	stmts := []syntax.Stmt{&syntax.ReturnStmt{Result: expr}}
-	return File(stmts, pos, name, locals, nil).Toplevel
+	return File(opts, stmts, pos, name, locals, nil).Toplevel
 }
 
 // File compiles the statements of a file into a program.
-func File(stmts []syntax.Stmt, pos syntax.Position, name string, locals, globals []*syntax.Ident) *Program {
+// The options are those under which the file was resolved.
+func File(opts *resolve.Options, stmts []syntax.Stmt, pos syntax.Position, name string, locals, globals []*syntax.Ident) *Program {
 	pcomp := &pcomp{
 		prog: &Program{
+			Options: *opts,
 			Globals: idents(globals),
 		},
 		names:     make(map[string]uint32),
@@ -486,7 +583,7 @@ func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.St
 			fmt.Fprintf(os.Stderr, "%s block %d: (stack = %d)\n", name, b.index, stack)
 		}
 		var cjmpAddr *uint32
-		var isiterjmp int
+		var isiterjmp, isexcept int
 		for i, insn := range b.insns {
 			pc++
 
@@ -495,6 +592,10 @@ func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.St
 				switch insn.op {
 				case ITERJMP:
 					isiterjmp = 1
+					cjmpAddr = &b.insns[i].arg
+					pc += 4
+				case SETUPEXCEPT:
+					isexcept = 1
 					fallthrough
 				case CJMP:
 					cjmpAddr = &b.insns[i].arg
@@ -514,8 +615,8 @@ func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.St
 				fmt.Fprintf(os.Stderr, "After pc=%d: stack underflow\n", pc)
 				oops = true
 			}
-			if stack+isiterjmp > maxstack {
-				maxstack = stack + isiterjmp
+			if stack+isiterjmp+isexcept > maxstack {
+				maxstack = stack + isiterjmp + isexcept
 			}
 		}
 
@@ -556,10 +657,10 @@ func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.St
 				b.cjmp = b.cjmp.jmp
 			}
 
-			setinitialstack(b.cjmp, stack)
+			setinitialstack(b.cjmp, stack+isexcept)
 			visit(b.cjmp)
 
-			// Patch the CJMP/ITERJMP, if present.
+			// Patch the CJMP/ITERJMP/SETUPEXCEPT, if present.
 			if cjmpAddr != nil {
 				*cjmpAddr = b.cjmp.addr
 			}
@@ -645,10 +746,10 @@ func (insn *insn) stackeffect() int {
 // and builds the PC-to-line number table.
 func (fcomp *fcomp) generate(blocks []*block, codelen uint32) {
 	code := make([]byte, 0, codelen)
-	var pclinetab []uint16
+	var pclinetab []byte
 	var prev struct {
-		pc   uint32
-		line int32
+		pc        uint32
+		line, col int32
 	}
 
 	for _, b := range blocks {
@@ -657,39 +758,14 @@ func (fcomp *fcomp) generate(blocks []*block, codelen uint32) {
 		}
 		pc := b.addr
 		for _, insn := range b.insns {
-			if insn.line != 0 {
-				// Instruction has a source position.  Delta-encode it.
-				// See Funcode.Position for the encoding.
-				for {
-					var incomplete uint16
-
-					deltapc := pc - prev.pc
-					if deltapc > 0xff {
-						deltapc = 0xff
-						incomplete = 1
-					}
-					prev.pc += deltapc
-
-					deltaline := insn.line - prev.line
-					if deltaline > 0x3f {
-						deltaline = 0x3f
-						incomplete = 1
-					} else if deltaline < -0x40 {
-						deltaline = -0x40
-						incomplete = 1
-					}
-					prev.line += deltaline
-
-					entry := uint16(deltapc<<8) | uint16(uint8(deltaline<<1)) | incomplete
-					pclinetab = append(pclinetab, entry)
-					if incomplete == 0 {
-						break
-					}
-				}
+			if insn.line != 0 && (insn.line != prev.line || insn.col != prev.col) {
+				// Instruction has a new source position.  Delta-encode it.
+				pclinetab = appendRow(pclinetab, pc-prev.pc, insn.line-prev.line, insn.col-prev.col)
+				prev.pc, prev.line, prev.col = pc, insn.line, insn.col
 
 				if debug {
-					fmt.Fprintf(os.Stderr, "\t\t\t\t\t; %s %d\n",
-						filepath.Base(fcomp.fn.Pos.Filename()), insn.line)
+					fmt.Fprintf(os.Stderr, "\t\t\t\t\t; %s:%d:%d\n",
+						filepath.Base(fcomp.fn.Pos.Filename()), insn.line, insn.col)
 				}
 			}
 			if debug {
@@ -698,7 +774,7 @@ func (fcomp *fcomp) generate(blocks []*block, codelen uint32) {
 			code = append(code, byte(insn.op))
 			pc++
 			if insn.op >= OpcodeArgMin {
-				if insn.op == CJMP || insn.op == ITERJMP {
+				if insn.op == CJMP || insn.op == ITERJMP || insn.op == SETUPEXCEPT {
 					code = addUint32(code, insn.arg, 4) // pad arg to 4 bytes
 				} else {
 					code = addUint32(code, insn.arg, 0)
@@ -759,38 +835,71 @@ func PrintOp(fn *Funcode, pc uint32, op Opcode, arg uint32) {
 		return
 	}
 
-	var comment string
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\t%d\t%-10s\t%d", pc, op, arg)
+	if comment := fn.Operand(op, arg); comment != "" {
+		fmt.Fprint(&buf, "\t; ", comment)
+	}
+	fmt.Fprintln(&buf)
+	os.Stderr.Write(buf.Bytes())
+}
+
+// Operand returns a description of the argument of an instruction of
+// fn, such as the name of a variable or the value of a constant, or ""
+// if the argument is just a number.
+func (fn *Funcode) Operand(op Opcode, arg uint32) string {
 	switch op {
 	case CONSTANT:
 		switch x := fn.Prog.Constants[arg].(type) {
 		case string:
-			comment = strconv.Quote(x)
+			return strconv.Quote(x)
+		case Bytes:
+			return "b" + strconv.Quote(string(x))
 		default:
-			comment = fmt.Sprint(x)
+			return fmt.Sprint(x)
 		}
 	case MAKEFUNC:
-		comment = fn.Prog.Functions[arg].Name
+		return fn.Prog.Functions[arg].Name
 	case SETLOCAL, LOCAL:
-		comment = fn.Locals[arg].Name
+		return fn.Locals[arg].Name
 	case SETGLOBAL, GLOBAL:
-		comment = fn.Prog.Globals[arg].Name
+		return fn.Prog.Globals[arg].Name
 	case ATTR, SETFIELD, PREDECLARED, UNIVERSAL:
-		comment = fn.Prog.Names[arg]
+		return fn.Prog.Names[arg]
 	case FREE:
-		comment = fn.Freevars[arg].Name
+		return fn.Freevars[arg].Name
 	case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW:
-		comment = fmt.Sprintf("%d pos, %d named", arg>>8, arg&0xff)
+		return fmt.Sprintf("%d pos, %d named", arg>>8, arg&0xff)
 	default:
-		// JMP, CJMP, ITERJMP, MAKETUPLE, MAKELIST, LOAD, UNPACK:
+		// JMP, CJMP, ITERJMP, SETUPEXCEPT, MAKETUPLE, MAKELIST, LOAD, UNPACK:
 		// arg is just a number
+		return ""
 	}
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "\t%d\t%-10s\t%d", pc, op, arg)
-	if comment != "" {
-		fmt.Fprint(&buf, "\t; ", comment)
+}
+
+// DecodeOp decodes the instruction at pc in code, returning its opcode,
+// its argument (zero if it has none), and the pc of the next
+// instruction. It reports false if the instruction is truncated.
+func DecodeOp(code []byte, pc uint32) (op Opcode, arg uint32, next uint32, ok bool) {
+	if pc >= uint32(len(code)) {
+		return 0, 0, pc, false
 	}
-	fmt.Fprintln(&buf)
-	os.Stderr.Write(buf.Bytes())
+	op = Opcode(code[pc])
+	pc++
+	if op >= OpcodeArgMin {
+		for s := uint(0); ; s += 7 {
+			if pc >= uint32(len(code)) || s >= 32 {
+				return op, 0, pc, false
+			}
+			b := code[pc]
+			pc++
+			arg |= uint32(b&0x7f) << s
+			if b < 0x80 {
+				break
+			}
+		}
+	}
+	return op, arg, pc, true
 }
 
 // newBlock returns a new block.
@@ -803,9 +912,10 @@ func (fcomp *fcomp) emit(op Opcode) {
 	if op >= OpcodeArgMin {
 		panic("missing arg: " + op.String())
 	}
-	insn := insn{op: op, line: fcomp.pos.Line}
+	insn := insn{op: op, line: fcomp.pos.Line, col: fcomp.pos.Col}
 	fcomp.block.insns = append(fcomp.block.insns, insn)
 	fcomp.pos.Line = 0
+	fcomp.pos.Col = 0
 }
 
 // emit1 emits an instruction with an immediate operand.
@@ -813,9 +923,10 @@ func (fcomp *fcomp) emit1(op Opcode, arg uint32) {
 	if op < OpcodeArgMin {
 		panic("unwanted arg: " + op.String())
 	}
-	insn := insn{op: op, arg: arg, line: fcomp.pos.Line}
+	insn := insn{op: op, arg: arg, line: fcomp.pos.Line, col: fcomp.pos.Col}
 	fcomp.block.insns = append(fcomp.block.insns, insn)
 	fcomp.pos.Line = 0
+	fcomp.pos.Col = 0
 }
 
 // jump emits a jump to the specified block.
@@ -828,12 +939,13 @@ func (fcomp *fcomp) jump(b *block) {
 	fcomp.block = nil
 }
 
-// condjump emits a conditional jump (CJMP or ITERJMP)
+// condjump emits a conditional jump (CJMP, ITERJMP, or SETUPEXCEPT)
 // to the specified true/false blocks.
-// (For ITERJMP, the cases are jmp/f/ok and cjmp/t/exhausted.)
+// (For ITERJMP, the cases are jmp/f/ok and cjmp/t/exhausted;
+// for SETUPEXCEPT, they are jmp/f/protected and cjmp/t/handler.)
 // On return, the current block is unset.
 func (fcomp *fcomp) condjump(op Opcode, t, f *block) {
-	if !(op == CJMP || op == ITERJMP) {
+	if !(op == CJMP || op == ITERJMP || op == SETUPEXCEPT) {
 		panic("not a conditional jump: " + op.String())
 	}
 	fcomp.emit1(op, 0) // fill in address later
@@ -841,6 +953,14 @@ func (fcomp *fcomp) condjump(op Opcode, t, f *block) {
 	fcomp.jump(f)
 }
 
+// popHandlers emits instructions to deactivate the exception
+// handlers that became active after the first n.
+func (fcomp *fcomp) popHandlers(n int) {
+	for i := n; i < fcomp.handlers; i++ {
+		fcomp.emit(POPEXCEPT)
+	}
+}
+
 // nameIndex returns the index of the specified name
 // within the name pool, adding it if necessary.
 func (pcomp *pcomp) nameIndex(name string) uint32 {
@@ -930,6 +1050,10 @@ func (fcomp *fcomp) stmts(stmts []syntax.Stmt) {
 }
 
 func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
+	// Record the start of each statement in the line number
+	// table, even if it cannot fail, for the benefit of debuggers.
+	fcomp.setPos(syntax.Start(stmt))
+
 	switch stmt := stmt.(type) {
 	case *syntax.ExprStmt:
 		if _, ok := stmt.X.(*syntax.Literal); ok {
@@ -945,12 +1069,14 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 		case syntax.PASS:
 			// no-op
 		case syntax.BREAK:
-			b := fcomp.loops[len(fcomp.loops)-1].break_
-			fcomp.jump(b)
+			loop := fcomp.loops[len(fcomp.loops)-1]
+			fcomp.popHandlers(loop.handlers)
+			fcomp.jump(loop.break_)
 			fcomp.block = fcomp.newBlock() // dead code
 		case syntax.CONTINUE:
-			b := fcomp.loops[len(fcomp.loops)-1].continue_
-			fcomp.jump(b)
+			loop := fcomp.loops[len(fcomp.loops)-1]
+			fcomp.popHandlers(loop.handlers)
+			fcomp.jump(loop.continue_)
 			fcomp.block = fcomp.newBlock() // dead code
 		}
 
@@ -1063,7 +1189,7 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 
 		fcomp.block = body
 		fcomp.assign(stmt.For, stmt.Vars)
-		fcomp.loops = append(fcomp.loops, loop{break_: tail, continue_: head})
+		fcomp.loops = append(fcomp.loops, loop{break_: tail, continue_: head, handlers: fcomp.handlers})
 		fcomp.stmts(stmt.Body)
 		fcomp.loops = fcomp.loops[:len(fcomp.loops)-1]
 		fcomp.jump(head)
@@ -1081,13 +1207,40 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 		fcomp.ifelse(stmt.Cond, body, done)
 
 		fcomp.block = body
-		fcomp.loops = append(fcomp.loops, loop{break_: done, continue_: head})
+		fcomp.loops = append(fcomp.loops, loop{break_: done, continue_: head, handlers: fcomp.handlers})
 		fcomp.stmts(stmt.Body)
 		fcomp.loops = fcomp.loops[:len(fcomp.loops)-1]
 		fcomp.jump(head)
 
 		fcomp.block = done
 
+	case *syntax.TryStmt:
+		body := fcomp.newBlock()
+		handler := fcomp.newBlock()
+		done := fcomp.newBlock()
+
+		fcomp.setPos(stmt.Try)
+		fcomp.condjump(SETUPEXCEPT, handler, body)
+
+		fcomp.block = body
+		fcomp.handlers++
+		fcomp.stmts(stmt.Body)
+		fcomp.handlers--
+		fcomp.emit(POPEXCEPT)
+		fcomp.jump(done)
+
+		// The handler starts with the error on the stack.
+		fcomp.block = handler
+		if stmt.Name != nil {
+			fcomp.set(stmt.Name)
+		} else {
+			fcomp.emit(POP)
+		}
+		fcomp.stmts(stmt.Handler)
+		fcomp.jump(done)
+
+		fcomp.block = done
+
 	case *syntax.ReturnStmt:
 		if stmt.Result != nil {
 			fcomp.expr(stmt.Result)
@@ -1177,8 +1330,13 @@ func (fcomp *fcomp) expr(e syntax.Expr) {
 		fcomp.lookup(e)
 
 	case *syntax.Literal:
-		// e.Value is int64, float64, *bigInt, or string.
-		fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(e.Value))
+		// e.Value is int64, float64, *bigInt, or string;
+		// bytes literals are represented by Bytes.
+		v := e.Value
+		if e.Token == syntax.BYTES {
+			v = Bytes(v.(string))
+		}
+		fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(v))
 
 	case *syntax.ListExpr:
 		for _, x := range e.List {
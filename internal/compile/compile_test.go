@@ -2,9 +2,12 @@ package compile_test
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 	"testing"
 
+	"go.starlark.net/internal/compile"
+	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
 )
 
@@ -54,14 +57,125 @@ y = mul(x, n)
 		t.Fatalf("newProg.Init call returned err %v, want *EvalError", err)
 	}
 	const want = `Traceback (most recent call last):
-  mul.star:5: in <toplevel>
-  mul.star:3: in mul
+  mul.star:5:8: in <toplevel>
+  mul.star:3:14: in mul
 Error: unknown binary op: string * NoneType`
 	if got := evalErr.Backtrace(); got != want {
 		t.Fatalf("got <<%s>>, want <<%s>>", got, want)
 	}
 }
 
+// TestPositions verifies that the line number table records the line
+// and column of each call, even in long files and lines, and that they
+// survive serialization.
+func TestPositions(t *testing.T) {
+	src := strings.Repeat("\n", 70000) +
+		"def g(x): return 1 // x\n" +
+		"y = [" + strings.Repeat(" ", 100) + "len(g(0))]\n"
+	_, oldProg, err := starlark.SourceProgram("long.star", src, starlark.StringDict(nil).Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := oldProg.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	newProg, err := starlark.CompiledProgram(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `Traceback (most recent call last):
+  long.star:70002:111: in <toplevel>
+  long.star:70001:20: in g
+Error: floored division by zero`
+	for _, prog := range []*starlark.Program{oldProg, newProg} {
+		_, err := prog.Init(new(starlark.Thread), nil)
+		evalErr, ok := err.(*starlark.EvalError)
+		if !ok {
+			t.Fatalf("Init returned err %v, want *EvalError", err)
+		}
+		if got := evalErr.Backtrace(); got != want {
+			t.Errorf("got <<%s>>, want <<%s>>", got, want)
+		}
+	}
+}
+
+// TestSerializedOptions verifies that the dialect options of a program
+// survive serialization and govern its execution.
+func TestSerializedOptions(t *testing.T) {
+	const src = `
+def fib(n):
+    return n if n < 2 else fib(n-1) + fib(n-2)
+
+x = fib(10)
+`
+	opts := &resolve.Options{Recursion: true, Lambda: true, Bitwise: true}
+	_, oldProg, err := starlark.SourceProgramOptions(opts, "fib.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := oldProg.Write(buf); err != nil {
+		t.Fatalf("oldProg.WriteTo: %v", err)
+	}
+	newProg, err := starlark.CompiledProgram(buf)
+	if err != nil {
+		t.Fatalf("CompiledProgram: %v", err)
+	}
+	if got := newProg.Options(); got != *opts {
+		t.Errorf("decoded options = %+v, want %+v", got, *opts)
+	}
+	globals, err := newProg.Init(new(starlark.Thread), nil)
+	if err != nil {
+		t.Fatalf("newProg.Init: %v", err)
+	}
+	if got, want := globals["x"], starlark.MakeInt(55); got.String() != want.String() {
+		t.Errorf("fib(10) = %s, want %s", got, want)
+	}
+
+	// The same program in the standard dialect is rejected at run time.
+	_, strictProg, err := starlark.SourceProgramOptions(new(resolve.Options), "fib.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = strictProg.Init(new(starlark.Thread), nil)
+	if err == nil || !strings.Contains(err.Error(), "called recursively") {
+		t.Errorf("strict program returned error %v, want recursion error", err)
+	}
+}
+
+// TestSerializedBytes verifies that bytes constants survive
+// serialization and remain distinct from equal string constants.
+func TestSerializedBytes(t *testing.T) {
+	const src = `
+b = b"a\xffb"
+s = "a\xffb"
+`
+	_, oldProg, err := starlark.SourceProgram("bytes.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := oldProg.Write(buf); err != nil {
+		t.Fatalf("oldProg.WriteTo: %v", err)
+	}
+	newProg, err := starlark.CompiledProgram(buf)
+	if err != nil {
+		t.Fatalf("CompiledProgram: %v", err)
+	}
+	globals, err := newProg.Init(new(starlark.Thread), nil)
+	if err != nil {
+		t.Fatalf("newProg.Init: %v", err)
+	}
+	if got, want := globals["b"], starlark.Bytes("a\xffb"); got != want {
+		t.Errorf("b = %s, want %s", got, want)
+	}
+	if got, want := globals["s"], starlark.String("a\xffb"); got != want {
+		t.Errorf("s = %s, want %s", got, want)
+	}
+}
+
 func TestGarbage(t *testing.T) {
 	const garbage = "This is not a compiled Starlark program."
 	_, err := starlark.CompiledProgram(strings.NewReader(garbage))
@@ -72,3 +186,194 @@ func TestGarbage(t *testing.T) {
 		t.Fatalf("CompiledProgram reported the wrong error when decoding garbage: %v", err)
 	}
 }
+
+func TestDecodeOp(t *testing.T) {
+	_, prog, err := starlark.SourceProgram("decode.star", "x = 1\nprint(x.y if x else 0)\n", starlark.StringDict(nil).Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := prog.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compile.DecodeProgram(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	fn := compiled.Toplevel
+	for pc := uint32(0); pc < uint32(len(fn.Code)); {
+		op, arg, next, ok := compile.DecodeOp(fn.Code, pc)
+		if !ok {
+			t.Fatalf("truncated instruction at pc %d", pc)
+		}
+		insn := fmt.Sprintf("%d:%s", pc, op)
+		if op >= compile.OpcodeArgMin {
+			insn += fmt.Sprintf(" %d", arg)
+			if operand := fn.Operand(op, arg); operand != "" {
+				insn += " " + operand
+			}
+		}
+		got = append(got, insn)
+		pc = next
+	}
+	const want = "0:constant 0 1 2:setglobal 0 x 4:universal 0 print 6:global 0 x " +
+		"8:cjmp 21 10:nop 11:nop 12:nop 13:constant 1 0 15:call 256 1 pos, 0 named " +
+		"18:pop 19:none 20:return 21:global 0 x 23:attr 1 y 25:jmp 15 27:nop 28:nop 29:nop"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, " "), want)
+	}
+
+	if _, _, _, ok := compile.DecodeOp([]byte{byte(compile.CONSTANT), 0x80}, 0); ok {
+		t.Errorf("DecodeOp of truncated argument succeeded")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	_, prog, err := starlark.SourceProgram("verify.star", "x = 1\nprint(x.y if x else 0)\n", starlark.StringDict(nil).Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := prog.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if _, err := starlark.CompiledProgram(bytes.NewReader(data)); err != nil {
+		t.Fatalf("valid program rejected: %v", err)
+	}
+
+	// See TestDecodeOp for the disassembly of the toplevel function.
+	for _, test := range []struct {
+		corrupt func(fn *compile.Funcode)
+		want    string
+	}{
+		{func(fn *compile.Funcode) { fn.Code[0] = 0xff }, "pc 0: invalid opcode 255"},
+		{func(fn *compile.Funcode) { fn.Code[1] = 5 }, "pc 0: constant: constant index 5 out of range (2 constants)"},
+		{func(fn *compile.Funcode) { fn.Code[5] = 9 }, "pc 4: universal: name index 9 out of range (2 names)"},
+		{func(fn *compile.Funcode) { fn.Code[9] = 22 }, "pc 8: jump to 22 is not an instruction boundary"},
+		{func(fn *compile.Funcode) { fn.Code[9] = 99 }, "pc 8: jump to 99 out of range (code has 30 bytes)"},
+		{func(fn *compile.Funcode) { fn.Code[26] = 18 }, "pc 18: inconsistent stack depths"},
+		{func(fn *compile.Funcode) { fn.Code[4] = byte(compile.POP) }, "pc 4: pop: operand stack underflow (depth 0, needs 1)"},
+		{func(fn *compile.Funcode) { fn.MaxStack = 1 }, "operand stack depth 2 exceeds maxstack 1"},
+	} {
+		compiled, err := compile.DecodeProgram(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.corrupt(compiled.Toplevel)
+		_, err = starlark.CompiledProgram(bytes.NewReader(compiled.Encode()))
+		if err == nil {
+			t.Errorf("corrupt program accepted, want error %q", test.want)
+		} else if !strings.HasPrefix(err.Error(), "invalid function <toplevel>: ") || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("got error %q, want %q", err, test.want)
+		}
+	}
+}
+
+func TestLineAt(t *testing.T) {
+	// Exercise multi-entry rows of the line number table:
+	// large line deltas, and instructions far apart.
+	src := "x = 1\n" + strings.Repeat("\n", 100) + "y = [" + strings.Repeat("x, ", 200) + "]\n" +
+		"def f(a):\n  if a:\n    return [a] * 3\n  return None\n" +
+		"z = f(y)\n" + strings.Repeat("\n", 3) + "w = x\n"
+	_, prog, err := starlark.SourceProgram("lines.star", src, starlark.StringDict(nil).Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := prog.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compile.DecodeProgram(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range append([]*compile.Funcode{compiled.Toplevel}, compiled.Functions...) {
+		for pc := uint32(0); pc <= uint32(len(fn.Code)); pc++ {
+			if got, want := fn.LineAt(pc), fn.Position(pc).Line; got != want {
+				t.Errorf("%s: LineAt(%d) = %d, want %d", fn.Name, pc, got, want)
+			}
+		}
+	}
+}
+
+// TestVerifyMutations checks that every program accepted by
+// CompiledProgram, when derived from a valid one by changing a single
+// byte, executes without crashing the interpreter; it may fail only
+// with an error.
+func TestVerifyMutations(t *testing.T) {
+	const src = `
+load("lib.star", "m")
+
+big = 123456789012345678901234567890
+
+def f(a, b=[1, 2], *args, **kwargs):
+    c = [x * 2 for x in a if x]
+    d = {k: v for k, v in kwargs.items()}
+    g = lambda y: y + a[0] + len(c) + m
+    try:
+        e = c[10]
+    except as err:
+        e = str(err)
+    x, y = 1, "s"
+    return g(1), d, e, x, y, a[1:2], {"k": b, 1.5: big}, not a, -len(args), b"ab"
+
+z = f([1, 2, 3], k=1)
+`
+	opts := &resolve.Options{NestedDef: true, Lambda: true, Float: true, Try: true}
+	_, prog, err := starlark.SourceProgramOptions(opts, "mutant.star", []byte(src), starlark.StringDict(nil).Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := prog.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// run executes a program, and reports whether it crashed.
+	run := func(prog *starlark.Program) (crash interface{}) {
+		defer func() { crash = recover() }()
+		thread := &starlark.Thread{
+			Print: func(*starlark.Thread, string) {},
+			Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+				return starlark.StringDict{"m": starlark.MakeInt(1)}, nil
+			},
+		}
+		thread.SetMaxExecutionSteps(10000)
+		thread.SetMaxAllocs(1 << 20)
+		prog.Init(thread, nil)
+		return nil
+	}
+	if crash := run(prog); crash != nil {
+		t.Fatalf("valid program crashed: %v", crash)
+	}
+
+	mutant := make([]byte, len(data))
+	var accepted, crashed int
+	for i := range data {
+		for _, b := range []byte{0, 1, 2, 0x7f, 0xff, data[i] + 1, data[i] - 1, data[i] ^ 0x80} {
+			if b == data[i] {
+				continue
+			}
+			copy(mutant, data)
+			mutant[i] = b
+			prog, err := starlark.CompiledProgram(bytes.NewReader(mutant))
+			if err != nil {
+				continue // rejected
+			}
+			accepted++
+			if crash := run(prog); crash != nil {
+				crashed++
+				if crashed <= 10 {
+					t.Errorf("byte %d = %#x: accepted program crashed: %v", i, b, crash)
+				}
+			}
+		}
+	}
+	if crashed > 0 {
+		t.Errorf("%d of %d accepted mutants crashed", crashed, accepted)
+	}
+}
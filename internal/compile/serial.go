@@ -14,6 +14,7 @@ package compile
 //	str		uint32le	# offset of <strings> section
 //	version		varint		# must match Version
 //	filename	string
+//	options		varint		# bit set of resolve.Options
 //	numloads	varint
 //	loads		[]Ident
 //	numnames	varint
@@ -31,8 +32,7 @@ package compile
 // Funcode:
 //	id		Ident
 //	code		[]byte
-//	pclinetablen	varint
-//	pclinetab	[]varint
+//	pclinetab	[]byte		# varint rows; see Funcode.Position
 //	numlocals	varint
 //	locals		[]Ident
 //	numfreevars	varint
@@ -42,6 +42,16 @@ package compile
 //	hasvarargs	varint (0 or 1)
 //	haskwargs	varint (0 or 1)
 //
+// Options:				# bit	option
+//	bits		varint		# 0	NestedDef
+//					# 1	Lambda
+//					# 2	Float
+//					# 3	Set
+//					# 4	GlobalReassign
+//					# 5	Bitwise
+//					# 6	Recursion
+//					# 7	Try
+//
 // Ident:
 //	filename	string
 //	line, col	varint
@@ -51,6 +61,7 @@ package compile
 //      data            ...             # 1=int     varint
 //                                      # 2=float   varint (bits as uint64)
 //                                      # 3=bigint  string (decimal ASCII text)
+//                                      # 4=bytes   string
 //
 // The encoding starts with a four-byte magic number.
 // The next four bytes are a little-endian uint32
@@ -73,7 +84,8 @@ package compile
 // are represented as strings. They all (unsafely) share the
 // same backing byte slice.
 //
-// Aside from the str field, all integers are encoded as varints.
+// Aside from the str field and the entries of the line number table,
+// all integers are encoded as varints.
 
 import (
 	"encoding/binary"
@@ -83,6 +95,7 @@ import (
 	debugpkg "runtime/debug"
 	"unsafe"
 
+	"go.starlark.net/resolve"
 	"go.starlark.net/syntax"
 )
 
@@ -95,6 +108,7 @@ func (prog *Program) Encode() []byte {
 	e.p = append(e.p, "????"...) // string data offset; filled in later
 	e.int(Version)
 	e.string(prog.Toplevel.Pos.Filename())
+	e.int(encodeOptions(&prog.Options))
 	e.idents(prog.Loads)
 	e.int(len(prog.Names))
 	for _, name := range prog.Names {
@@ -115,6 +129,9 @@ func (prog *Program) Encode() []byte {
 		case *big.Int:
 			e.int(3)
 			e.string(c.Text(10))
+		case Bytes:
+			e.int(4)
+			e.string(string(c))
 		}
 	}
 	e.idents(prog.Globals)
@@ -177,10 +194,7 @@ func (e *encoder) function(fn *Funcode) {
 	e.ident(Ident{fn.Name, fn.Pos})
 	e.string(fn.Doc)
 	e.bytes(fn.Code)
-	e.int(len(fn.pclinetab))
-	for _, x := range fn.pclinetab {
-		e.int64(int64(x))
-	}
+	e.bytes(fn.pclinetab)
 	e.idents(fn.Locals)
 	e.idents(fn.Freevars)
 	e.int(fn.MaxStack)
@@ -189,6 +203,36 @@ func (e *encoder) function(fn *Funcode) {
 	e.int(b2i(fn.HasKwargs))
 }
 
+// optionBits returns pointers to the fields of opts
+// in the order of their bits in the encoding.
+func optionBits(opts *resolve.Options) []*bool {
+	return []*bool{
+		&opts.NestedDef,
+		&opts.Lambda,
+		&opts.Float,
+		&opts.Set,
+		&opts.GlobalReassign,
+		&opts.Bitwise,
+		&opts.Recursion,
+		&opts.Try,
+	}
+}
+
+func encodeOptions(opts *resolve.Options) int {
+	var bits int
+	for i, b := range optionBits(opts) {
+		bits |= b2i(*b) << uint(i)
+	}
+	return bits
+}
+
+func decodeOptions(bits int) (opts resolve.Options) {
+	for i, b := range optionBits(&opts) {
+		*b = bits&(1<<uint(i)) != 0
+	}
+	return opts
+}
+
 func b2i(b bool) int {
 	if b {
 		return 1
@@ -208,7 +252,9 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 	}
 	defer func() {
 		if x := recover(); x != nil {
-			debugpkg.PrintStack()
+			if debug {
+				debugpkg.PrintStack()
+			}
 			err = fmt.Errorf("internal error while decoding program: %v", x)
 		}
 	}()
@@ -226,6 +272,8 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 	filename := d.string()
 	d.filename = &filename
 
+	options := decodeOptions(d.int())
+
 	loads := d.idents()
 
 	names := make([]string, d.int())
@@ -246,6 +294,8 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 			c = math.Float64frombits(d.uint64())
 		case 3:
 			c, _ = new(big.Int).SetString(d.string(), 10)
+		case 4:
+			c = Bytes(d.string())
 		}
 		constants[i] = c
 	}
@@ -258,6 +308,7 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 	}
 
 	prog := &Program{
+		Options:   options,
 		Loads:     loads,
 		Names:     names,
 		Constants: constants,
@@ -342,10 +393,7 @@ func (d *decoder) function() *Funcode {
 	id := d.ident()
 	doc := d.string()
 	code := d.bytes()
-	pclinetab := make([]uint16, d.int())
-	for i := range pclinetab {
-		pclinetab[i] = uint16(d.int())
-	}
+	pclinetab := d.bytes()
 	locals := d.idents()
 	freevars := d.idents()
 	maxStack := d.int()
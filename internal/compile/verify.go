@@ -0,0 +1,410 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compile
+
+// This file defines the bytecode verifier, which checks that a program,
+// such as one produced by DecodeProgram from untrusted bytes, is safe
+// for the interpreter to execute.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// Verify checks that the program is well formed: that each constant
+// has a valid type; that the line number table of each function
+// consists of whole rows; that each reachable instruction of each function
+// has a valid opcode, and an operand within the bounds of the table it
+// indexes; that each jump leads to an instruction boundary; that at
+// each instruction the depths of the operand, iterator, and exception
+// handler stacks are the same along all paths that reach it, and within
+// the bounds declared by the function; and that the operands of the
+// instructions that construct dicts, lists, and functions, and of calls
+// and loads, have the types those instructions require. It returns an
+// error describing the first problem found.
+//
+// Verify does not check the types of other operands, nor the names of
+// universal and predeclared variables, which are not known until the
+// program is executed, so a program that passes verification may still
+// fail at run time with a dynamic error.
+func (prog *Program) Verify() error {
+	if prog.Toplevel == nil {
+		return fmt.Errorf("invalid program: no toplevel function")
+	}
+	if n := len(prog.Toplevel.Freevars); n > 0 {
+		return fmt.Errorf("invalid program: toplevel function has %d free variables", n)
+	}
+	for i, c := range prog.Constants {
+		switch c := c.(type) {
+		case string, Bytes, int64, float64:
+			// ok
+		case *big.Int:
+			if c == nil {
+				return fmt.Errorf("invalid program: constant %d is an invalid big integer", i)
+			}
+		default:
+			return fmt.Errorf("invalid program: constant %d has invalid type %T", i, c)
+		}
+	}
+	if err := prog.Toplevel.verify(); err != nil {
+		return err
+	}
+	for _, fn := range prog.Functions {
+		if err := fn.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A kind is what the verifier knows of the type of an operand.
+// A non-negative kind denotes a tuple of that length.
+type kind int
+
+const (
+	unknown    kind = -1 - iota // any value
+	listKind                    // a list made by MAKELIST
+	dictKind                    // a dict made by MAKEDICT
+	stringKind                  // a string constant
+)
+
+func (k kind) String() string {
+	switch k {
+	case unknown:
+		return "value"
+	case listKind:
+		return "list"
+	case dictKind:
+		return "dict"
+	case stringKind:
+		return "string"
+	}
+	return fmt.Sprintf("tuple of %d", int(k))
+}
+
+// A stackState records the state of the stacks at an instruction.
+type stackState struct {
+	operands []kind // operand stack
+	iters    int    // depth of iterator stack
+	handlers int    // depth of exception handler stack
+}
+
+func (s *stackState) String() string {
+	return fmt.Sprintf("{sp:%d iters:%d handlers:%d}", len(s.operands), s.iters, s.handlers)
+}
+
+func (fn *Funcode) verify() error {
+	errorf := func(pc uint32, format string, args ...interface{}) error {
+		return fmt.Errorf("invalid function %s: pc %d: %s", fn.Name, pc, fmt.Sprintf(format, args...))
+	}
+
+	if fn.MaxStack < 0 {
+		return errorf(0, "negative maxstack %d", fn.MaxStack)
+	}
+	if fn.NumParams < numVariadic(fn) || fn.NumParams > len(fn.Locals) {
+		return errorf(0, "invalid parameter count %d (%d locals)", fn.NumParams, len(fn.Locals))
+	}
+	if len(fn.Code) == 0 {
+		return errorf(0, "no code")
+	}
+
+	// Check that the line number table consists of whole rows,
+	// each of three varints. See Funcode.Position.
+	for tab, i := fn.pclinetab, 0; len(tab) > 0; i++ {
+		_, n := binary.Uvarint(tab)
+		if n <= 0 || (len(tab) == n && i%3 != 2) {
+			return errorf(0, "malformed line number table")
+		}
+		tab = tab[n:]
+	}
+
+	// Find the instruction boundaries.
+	isInsn := make([]bool, len(fn.Code))
+	for pc := uint32(0); pc < uint32(len(fn.Code)); {
+		_, _, next, ok := DecodeOp(fn.Code, pc)
+		if !ok {
+			return errorf(pc, "truncated instruction")
+		}
+		isInsn[pc] = true
+		pc = next
+	}
+
+	// Propagate the state of the stacks from the entry to each
+	// reachable instruction, and check each one.
+	states := make(map[uint32]*stackState)
+	worklist := []uint32{0}
+	states[0] = &stackState{}
+	// succ merges the state s into that of the successor of the
+	// instruction at pc. The kind of an operand that differs along
+	// two paths becomes unknown, and the successor is checked again.
+	succ := func(pc, to uint32, s *stackState) error {
+		if to >= uint32(len(fn.Code)) {
+			if to == uint32(len(fn.Code)) {
+				return errorf(pc, "control falls off end of code")
+			}
+			return errorf(pc, "jump to %d out of range (code has %d bytes)", to, len(fn.Code))
+		}
+		if !isInsn[to] {
+			return errorf(pc, "jump to %d is not an instruction boundary", to)
+		}
+		prev, ok := states[to]
+		if !ok {
+			states[to] = &stackState{append([]kind(nil), s.operands...), s.iters, s.handlers}
+			worklist = append(worklist, to)
+			return nil
+		}
+		if len(prev.operands) != len(s.operands) || prev.iters != s.iters || prev.handlers != s.handlers {
+			return errorf(to, "inconsistent stack depths: %v along one path, %v along another", prev, s)
+		}
+		changed := false
+		for i, k := range s.operands {
+			if prev.operands[i] != k && prev.operands[i] != unknown {
+				prev.operands[i] = unknown
+				changed = true
+			}
+		}
+		if changed {
+			worklist = append(worklist, to)
+		}
+		return nil
+	}
+
+	for len(worklist) > 0 {
+		pc := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		s := *states[pc]
+
+		op, arg, next, _ := DecodeOp(fn.Code, pc)
+		if op > OpcodeMax || opcodeNames[op] == "" {
+			return errorf(pc, "invalid opcode %d", op)
+		}
+
+		// Check the operand.
+		var table string
+		var size int
+		switch op {
+		case CONSTANT:
+			table, size = "constant", len(fn.Prog.Constants)
+		case MAKEFUNC:
+			table, size = "function", len(fn.Prog.Functions)
+		case LOCAL, SETLOCAL:
+			table, size = "local", len(fn.Locals)
+		case GLOBAL, SETGLOBAL:
+			table, size = "global", len(fn.Prog.Globals)
+		case FREE:
+			table, size = "free variable", len(fn.Freevars)
+		case ATTR, SETFIELD, PREDECLARED, UNIVERSAL:
+			table, size = "name", len(fn.Prog.Names)
+		}
+		if table != "" && arg >= uint32(size) {
+			return errorf(pc, "%s: %s index %d out of range (%d %ss)", op, table, arg, size, table)
+		}
+
+		// Check the depth of the operand stack.
+		pops, effect := stackPopsAndEffect(op, arg)
+		sp := len(s.operands)
+		if sp < pops {
+			return errorf(pc, "%s: operand stack underflow (depth %d, needs %d)", op, sp, pops)
+		}
+		pushed := sp + effect
+		if op == ITERJMP {
+			pushed++ // on fall through
+		}
+		if pushed > fn.MaxStack {
+			return errorf(pc, "%s: operand stack depth %d exceeds maxstack %d", op, pushed, fn.MaxStack)
+		}
+
+		// Check the kinds of the operands,
+		// and compute the kinds of the results.
+		args := s.operands[sp-pops:]
+		want := func(i int, k kind) error {
+			if args[i] != k {
+				return errorf(pc, "%s: operand %d is %s, want %s", op, i, args[i], k)
+			}
+			return nil
+		}
+		var results []kind
+		switch op {
+		case SETDICT, SETDICTUNIQ:
+			if err := want(0, dictKind); err != nil {
+				return err
+			}
+		case APPEND:
+			if err := want(0, listKind); err != nil {
+				return err
+			}
+		case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW:
+			// The name of each named argument is a string.
+			npos, nnamed := int(arg>>8), int(arg&0xff)
+			for i := 0; i < nnamed; i++ {
+				if err := want(1+npos+2*i, stringKind); err != nil {
+					return err
+				}
+			}
+		case LOAD:
+			for i := range args {
+				if err := want(i, stringKind); err != nil {
+					return err
+				}
+			}
+		case MAKEFUNC:
+			callee := fn.Prog.Functions[arg]
+			if args[0] < 0 {
+				return errorf(pc, "%s: defaults are %s, want tuple", op, args[0])
+			}
+			if n := callee.NumParams - numVariadic(callee); int(args[0]) > n {
+				return errorf(pc, "%s: %d defaults for %d parameters of %s", op, args[0], n, callee.Name)
+			}
+			if err := want(1, kind(len(callee.Freevars))); err != nil {
+				return err
+			}
+		case DUP, DUP2:
+			results = append(append(results, args...), args...)
+		case EXCH:
+			results = []kind{args[1], args[0]}
+		case MAKETUPLE:
+			results = []kind{kind(arg)}
+		case MAKELIST:
+			results = []kind{listKind}
+		case MAKEDICT:
+			results = []kind{dictKind}
+		case CONSTANT:
+			if _, ok := fn.Prog.Constants[arg].(string); ok {
+				results = []kind{stringKind}
+			}
+		}
+		for len(results) < pops+effect {
+			results = append(results, unknown)
+		}
+		s.operands = append(append([]kind(nil), s.operands[:sp-pops]...), results...)
+
+		// Check the iterator and handler stacks,
+		// and find the successors.
+		switch op {
+		case ITERPUSH:
+			s.iters++
+		case ITERPOP:
+			if s.iters == 0 {
+				return errorf(pc, "%s: iterator stack underflow", op)
+			}
+			s.iters--
+		case ITERJMP:
+			if s.iters == 0 {
+				return errorf(pc, "%s: iterator stack underflow", op)
+			}
+		case POPEXCEPT:
+			if s.handlers == 0 {
+				return errorf(pc, "%s: handler stack underflow", op)
+			}
+			s.handlers--
+		}
+
+		switch op {
+		case RETURN:
+			// no successors
+		case JMP:
+			if err := succ(pc, arg, &s); err != nil {
+				return err
+			}
+		case CJMP:
+			if err := succ(pc, arg, &s); err != nil {
+				return err
+			}
+			if err := succ(pc, next, &s); err != nil {
+				return err
+			}
+		case ITERJMP:
+			if err := succ(pc, arg, &s); err != nil {
+				return err
+			}
+			fallthru := s
+			fallthru.operands = append(s.operands[:len(s.operands):len(s.operands)], unknown)
+			if err := succ(pc, next, &fallthru); err != nil {
+				return err
+			}
+		case SETUPEXCEPT:
+			// The handler starts with the error on the stack.
+			handler := s
+			handler.operands = append(s.operands[:len(s.operands):len(s.operands)], unknown)
+			if len(handler.operands) > fn.MaxStack {
+				return errorf(pc, "%s: operand stack depth %d exceeds maxstack %d", op, len(handler.operands), fn.MaxStack)
+			}
+			if err := succ(pc, arg, &handler); err != nil {
+				return err
+			}
+			body := s
+			body.handlers++
+			if err := succ(pc, next, &body); err != nil {
+				return err
+			}
+		default:
+			if err := succ(pc, next, &s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// numVariadic returns the number of variadic parameters (*args and
+// **kwargs) of a function.
+func numVariadic(fn *Funcode) int {
+	n := 0
+	if fn.HasVarargs {
+		n++
+	}
+	if fn.HasKwargs {
+		n++
+	}
+	return n
+}
+
+// stackPopsAndEffect returns the number of operands consumed by an
+// instruction, and its net effect on the depth of the operand stack.
+// For ITERJMP, the effect is that of the jump.
+func stackPopsAndEffect(op Opcode, arg uint32) (pops, effect int) {
+	switch op {
+	case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW:
+		pops = 1 + int(arg>>8) + 2*int(arg&0xff)
+		if op != CALL {
+			pops++
+		}
+		if op == CALL_VAR_KW {
+			pops++
+		}
+		return pops, 1 - pops
+	case MAKETUPLE, MAKELIST:
+		return int(arg), 1 - int(arg)
+	case UNPACK:
+		return 1, int(arg) - 1
+	case LOAD:
+		return int(arg) + 1, -1
+	case ITERJMP:
+		return 0, 0
+	case DUP:
+		return 1, +1
+	case DUP2:
+		return 2, +2
+	case EXCH:
+		return 2, 0
+	case ATTR, NOT, UPLUS, UMINUS, TILDE:
+		return 1, 0
+	case SLICE:
+		return 4, -3
+	}
+	// For all other instructions, the effect is that of
+	// removing the operands, then pushing at most one result.
+	effect = int(stackEffect[op])
+	switch op {
+	case INDEX, INPLACE_ADD, MAKEFUNC, IN, LT, GT, GE, LE, EQL, NEQ,
+		PLUS, MINUS, STAR, SLASH, SLASHSLASH, PERCENT, AMP, PIPE, CIRCUMFLEX, LTLT, GTGT:
+		return 1 - effect, effect // one result
+	}
+	if effect < 0 {
+		return -effect, effect // no result
+	}
+	return 0, effect
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lint
+
+// This file defines the built-in checks.
+
+import (
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// The built-in checks.
+var (
+	// Unused reports local variables that are assigned but never
+	// used, and loaded names that are never used. Names beginning
+	// with an underscore are exempt.
+	Unused Check = &check{"unused", unused}
+
+	// Shadow reports bindings of names that shadow a built-in
+	// in starlark.Universe, such as a parameter named list.
+	Shadow Check = &check{"shadow", shadow}
+
+	// Unreachable reports statements that cannot be reached because
+	// they follow a return, break, or continue statement, or an if
+	// statement all of whose branches end in one.
+	Unreachable Check = &check{"unreachable", unreachable}
+
+	// MutableDefault reports parameters whose default value is a
+	// mutable list, dict, or set, which is shared by all calls.
+	MutableDefault Check = &check{"mutable-default", mutableDefault}
+
+	// Docstring reports public top-level functions (those whose
+	// names do not begin with an underscore) without a docstring.
+	Docstring Check = &check{"docstring", docstring}
+)
+
+// Checks is the list of all built-in checks.
+var Checks = []Check{Unused, Shadow, Unreachable, MutableDefault, Docstring}
+
+type check struct {
+	name string
+	run  func(pass *Pass)
+}
+
+func (c *check) Name() string   { return c.name }
+func (c *check) Run(pass *Pass) { c.run(pass) }
+
+func unused(pass *Pass) {
+	isUnused := func(id *syntax.Ident) bool {
+		return pass.Binding(id) == id && len(pass.Uses(id)) == 0 && !strings.HasPrefix(id.Name, "_")
+	}
+	syntax.Walk(pass.File, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.AssignStmt:
+			if n.Op == syntax.EQ {
+				for _, id := range targets(n.LHS) {
+					if resolve.Scope(id.Scope) == resolve.Local && isUnused(id) {
+						pass.Reportf(id.NamePos, "local variable %s is assigned but never used", id.Name)
+					}
+				}
+			}
+		case *syntax.LoadStmt:
+			for _, id := range n.To {
+				if isUnused(id) {
+					pass.Reportf(id.NamePos, "%s is loaded but never used", id.Name)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// targets returns the identifiers bound by the assignment target lhs.
+func targets(lhs syntax.Expr) []*syntax.Ident {
+	var ids []*syntax.Ident
+	var visit func(lhs syntax.Expr)
+	visit = func(lhs syntax.Expr) {
+		switch lhs := lhs.(type) {
+		case *syntax.Ident:
+			ids = append(ids, lhs)
+		case *syntax.ParenExpr:
+			visit(lhs.X)
+		case *syntax.ListExpr:
+			for _, x := range lhs.List {
+				visit(x)
+			}
+		case *syntax.TupleExpr:
+			for _, x := range lhs.List {
+				visit(x)
+			}
+		}
+	}
+	visit(lhs)
+	return ids
+}
+
+func shadow(pass *Pass) {
+	syntax.Walk(pass.File, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok && pass.IsBinding(id) && pass.Binding(id) == id && starlark.Universe.Has(id.Name) {
+			pass.Reportf(id.NamePos, "%s shadows the built-in %s", id.Name, id.Name)
+		}
+		return true
+	})
+}
+
+func unreachable(pass *Pass) {
+	var stmts func(list []syntax.Stmt)
+	stmts = func(list []syntax.Stmt) {
+		for i, stmt := range list {
+			switch stmt := stmt.(type) {
+			case *syntax.DefStmt:
+				stmts(stmt.Body)
+			case *syntax.ForStmt:
+				stmts(stmt.Body)
+			case *syntax.WhileStmt:
+				stmts(stmt.Body)
+			case *syntax.TryStmt:
+				stmts(stmt.Body)
+				stmts(stmt.Handler)
+			case *syntax.IfStmt:
+				stmts(stmt.True)
+				stmts(stmt.False)
+			}
+			if terminates(stmt) && i+1 < len(list) {
+				pass.Reportf(syntax.Start(list[i+1]), "unreachable code")
+				return
+			}
+		}
+	}
+	stmts(pass.File.Stmts)
+}
+
+// terminates reports whether control never proceeds
+// to the statement following stmt.
+func terminates(stmt syntax.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *syntax.ReturnStmt:
+		return true
+	case *syntax.BranchStmt:
+		return stmt.Token != syntax.PASS
+	case *syntax.IfStmt:
+		return len(stmt.True) > 0 && terminates(stmt.True[len(stmt.True)-1]) &&
+			len(stmt.False) > 0 && terminates(stmt.False[len(stmt.False)-1])
+	case *syntax.TryStmt:
+		return terminates(stmt.Body[len(stmt.Body)-1]) &&
+			terminates(stmt.Handler[len(stmt.Handler)-1])
+	}
+	return false
+}
+
+func mutableDefault(pass *Pass) {
+	syntax.Walk(pass.File, func(n syntax.Node) bool {
+		var fn *syntax.Function
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			fn = &n.Function
+		case *syntax.LambdaExpr:
+			fn = &n.Function
+		default:
+			return true
+		}
+		for _, param := range fn.Params {
+			if binary, ok := param.(*syntax.BinaryExpr); ok && isMutable(binary.Y) {
+				pass.Reportf(syntax.Start(binary.Y), "default value of parameter %s is mutable and shared by all calls",
+					binary.X.(*syntax.Ident).Name)
+			}
+		}
+		return true
+	})
+}
+
+// isMutable reports whether e evidently yields a new mutable value.
+func isMutable(e syntax.Expr) bool {
+	switch e := e.(type) {
+	case *syntax.ListExpr, *syntax.DictExpr, *syntax.Comprehension:
+		return true
+	case *syntax.ParenExpr:
+		return isMutable(e.X)
+	case *syntax.CallExpr:
+		if id, ok := e.Fn.(*syntax.Ident); ok && resolve.Scope(id.Scope) == resolve.Universal {
+			switch id.Name {
+			case "list", "dict", "set":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func docstring(pass *Pass) {
+	for _, stmt := range pass.File.Stmts {
+		def, ok := stmt.(*syntax.DefStmt)
+		if !ok || strings.HasPrefix(def.Name.Name, "_") {
+			continue
+		}
+		if !hasDocstring(def.Body) {
+			pass.Reportf(def.Name.NamePos, "public function %s has no docstring", def.Name.Name)
+		}
+	}
+}
+
+func hasDocstring(body []syntax.Stmt) bool {
+	if expr, ok := body[0].(*syntax.ExprStmt); ok {
+		lit, ok := expr.X.(*syntax.Literal)
+		return ok && lit.Token == syntax.STRING
+	}
+	return false
+}
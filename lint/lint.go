@@ -0,0 +1,269 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lint defines a framework for static checks of Starlark files,
+// and a set of checks for common mistakes.
+//
+// A Check inspects a single file, which has been parsed and resolved,
+// and reports its findings through a Pass. The Pass provides access to
+// the syntax tree and to the bindings computed by the resolver, so that
+// a check can relate each use of a name to the identifier that binds it.
+// Checks typically traverse the tree using syntax.Walk.
+package lint // import "go.starlark.net/lint"
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// A Check is a static check of a Starlark file.
+type Check interface {
+	// Name returns the check's name, a short identifier
+	// such as "unused" used to select it and to label its findings.
+	Name() string
+
+	// Run applies the check to the file in pass.File,
+	// reporting any problems using pass.Reportf.
+	Run(pass *Pass)
+}
+
+// A Finding is a problem reported by a check.
+type Finding struct {
+	Pos     syntax.Position
+	Check   string // name of the reporting check
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s (%s)", f.Pos, f.Message, f.Check)
+}
+
+// MarshalJSON encodes a finding as a JSON object with fields
+// file, line, col, check, and message.
+func (f Finding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File    string `json:"file"`
+		Line    int32  `json:"line"`
+		Col     int32  `json:"col"`
+		Check   string `json:"check"`
+		Message string `json:"message"`
+	}{f.Pos.Filename(), f.Pos.Line, f.Pos.Col, f.Check, f.Message})
+}
+
+// A Pass provides a check with information about the file being checked.
+type Pass struct {
+	File *syntax.File // the resolved syntax tree
+
+	check    string
+	findings *[]Finding
+	info     *info
+}
+
+// Reportf reports a finding at the specified position.
+func (pass *Pass) Reportf(pos syntax.Position, format string, args ...interface{}) {
+	*pass.findings = append(*pass.findings, Finding{
+		Pos:     pos,
+		Check:   pass.check,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Binding returns the identifier that binds the name referred to by
+// id, that is, the first binding occurrence of a local or global
+// variable. It returns nil if id refers to a predeclared or universal
+// name, or is not a reference at all, such as a field name.
+func (pass *Pass) Binding(id *syntax.Ident) *syntax.Ident { return pass.info.bindings[id] }
+
+// IsBinding reports whether id is a binding occurrence of a name, such
+// as the target of an assignment, a parameter, or a loaded name, as
+// opposed to a use of it. The target of an augmented assignment such
+// as x += 1 counts as a use.
+func (pass *Pass) IsBinding(id *syntax.Ident) bool { return pass.info.binders[id] }
+
+// Uses returns the identifiers that refer to the name bound by the
+// binding identifier bind, excluding binding occurrences.
+func (pass *Pass) Uses(bind *syntax.Ident) []*syntax.Ident { return pass.info.uses[bind] }
+
+// File resolves the file f in the dialect given by opts, applies each
+// of the specified checks, and returns their findings, ordered by
+// position. Any resolution errors are reported as findings of a check
+// named "resolve". The isPredeclared predicate reports whether a name
+// is predeclared in the file's environment; names in starlark.Universe
+// are always defined.
+//
+// The file must be the result of syntax.Parse, and must not already
+// have been resolved.
+func File(opts *resolve.Options, f *syntax.File, isPredeclared func(name string) bool, checks []Check) []Finding {
+	var findings []Finding
+	if err := resolve.FileOptions(opts, f, isPredeclared, starlark.Universe.Has); err != nil {
+		for _, err := range err.(resolve.ErrorList) {
+			findings = append(findings, Finding{Pos: err.Pos, Check: "resolve", Message: err.Msg})
+		}
+	}
+
+	info := newInfo(f)
+	for _, check := range checks {
+		check.Run(&Pass{File: f, check: check.Name(), findings: &findings, info: info})
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		x, y := findings[i].Pos, findings[j].Pos
+		if x.Line != y.Line {
+			return x.Line < y.Line
+		}
+		return x.Col < y.Col
+	})
+	return findings
+}
+
+// info records the bindings of a resolved file.
+type info struct {
+	file     *syntax.File
+	bindings map[*syntax.Ident]*syntax.Ident   // maps each reference to its binding
+	binders  map[*syntax.Ident]bool            // binding occurrences
+	uses     map[*syntax.Ident][]*syntax.Ident // maps each binding to its uses
+}
+
+func newInfo(f *syntax.File) *info {
+	info := &info{
+		file:     f,
+		bindings: make(map[*syntax.Ident]*syntax.Ident),
+		binders:  make(map[*syntax.Ident]bool),
+		uses:     make(map[*syntax.Ident][]*syntax.Ident),
+	}
+	info.findBinders(f)
+	info.resolve(f, nil)
+	return info
+}
+
+// findBinders records the binding occurrences of names within n.
+func (info *info) findBinders(n syntax.Node) {
+	syntax.Walk(n, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.AssignStmt:
+			if n.Op == syntax.EQ {
+				info.targets(n.LHS)
+			}
+		case *syntax.DefStmt:
+			info.binders[n.Name] = true
+			info.params(&n.Function)
+		case *syntax.LambdaExpr:
+			info.params(&n.Function)
+		case *syntax.ForStmt:
+			info.targets(n.Vars)
+		case *syntax.ForClause:
+			info.targets(n.Vars)
+		case *syntax.TryStmt:
+			if n.Name != nil {
+				info.binders[n.Name] = true
+			}
+		case *syntax.LoadStmt:
+			for _, id := range n.To {
+				info.binders[id] = true
+			}
+		}
+		return true
+	})
+}
+
+func (info *info) targets(lhs syntax.Expr) {
+	for _, id := range targets(lhs) {
+		info.binders[id] = true
+	}
+}
+
+func (info *info) params(fn *syntax.Function) {
+	for _, param := range fn.Params {
+		switch param := param.(type) {
+		case *syntax.Ident:
+			info.binders[param] = true
+		case *syntax.BinaryExpr:
+			info.binders[param.X.(*syntax.Ident)] = true
+		case *syntax.UnaryExpr:
+			if id, ok := param.X.(*syntax.Ident); ok {
+				info.binders[id] = true
+			}
+		}
+	}
+}
+
+// resolve records the binding of each identifier within n,
+// which appears within the specified stack of enclosing functions.
+func (info *info) resolve(n syntax.Node, stack []*syntax.Function) {
+	var nodes []syntax.Node
+	syntax.Walk(n, func(n syntax.Node) bool {
+		if n == nil {
+			switch nodes[len(nodes)-1].(type) {
+			case *syntax.DefStmt, *syntax.LambdaExpr:
+				stack = stack[:len(stack)-1]
+			}
+			nodes = nodes[:len(nodes)-1]
+			return true
+		}
+		nodes = append(nodes, n)
+
+		var fn *syntax.Function
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			// The function name is bound in the enclosing block.
+			info.ident(n.Name, stack)
+			fn = &n.Function
+		case *syntax.LambdaExpr:
+			fn = &n.Function
+		case *syntax.Ident:
+			if _, ok := info.bindings[n]; !ok {
+				info.ident(n, stack)
+			}
+		}
+		if fn != nil {
+			// Default values are evaluated in the enclosing block.
+			for _, param := range fn.Params {
+				if binary, ok := param.(*syntax.BinaryExpr); ok {
+					info.resolve(binary.Y, stack)
+				}
+			}
+			stack = append(stack, fn)
+		}
+		return true
+	})
+}
+
+// ident records the binding of a single identifier.
+func (info *info) ident(id *syntax.Ident, stack []*syntax.Function) {
+	var bind *syntax.Ident
+	scope, index := resolve.Scope(id.Scope), id.Index
+	i := len(stack) - 1
+	// Follow a free variable outwards to the function that binds it.
+	for scope == resolve.Free && i >= 0 && index < len(stack[i].FreeVars) {
+		fv := stack[i].FreeVars[index]
+		scope, index = resolve.Scope(fv.Scope), fv.Index
+		i--
+	}
+	switch scope {
+	case resolve.Local:
+		locals := info.file.Locals
+		if i >= 0 {
+			locals = stack[i].Locals
+		}
+		if index < len(locals) {
+			bind = locals[index]
+		}
+	case resolve.Global:
+		if index < len(info.file.Globals) {
+			bind = info.file.Globals[index]
+		}
+	}
+	if bind == nil {
+		return
+	}
+	info.bindings[id] = bind
+	if !info.binders[id] {
+		info.uses[bind] = append(info.uses[bind], id)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lint_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.starlark.net/internal/chunkedfile"
+	"go.starlark.net/lint"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlarktest"
+	"go.starlark.net/syntax"
+)
+
+var allOptions = &resolve.Options{
+	NestedDef: true,
+	Lambda:    true,
+	Float:     true,
+	Set:       true,
+	Bitwise:   true,
+	Recursion: true,
+}
+
+func isPredeclared(name string) bool { return false }
+
+func TestChecks(t *testing.T) {
+	filename := starlarktest.DataFile("lint", "testdata/lint.star")
+	for _, chunk := range chunkedfile.Read(filename, t) {
+		f, err := syntax.Parse(filename, chunk.Source, 0)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		for _, finding := range lint.File(allOptions, f, isPredeclared, lint.Checks) {
+			chunk.GotError(int(finding.Pos.Line), finding.Message)
+		}
+		chunk.Done()
+	}
+}
+
+func TestFindingJSON(t *testing.T) {
+	f, err := syntax.Parse("x.star", "def f(list):\n  '''doc'''\n  return list\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := lint.File(allOptions, f, isPredeclared, []lint.Check{lint.Shadow})
+	data, err := json.Marshal(findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `[{"file":"x.star","line":1,"col":7,"check":"shadow","message":"list shadows the built-in list"}]`
+	if got := string(data); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := findings[0].String(), "x.star:1:7: list shadows the built-in list (shadow)"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+// bindingCheck reports each use of a name with the position of its binding.
+type bindingCheck struct{}
+
+func (bindingCheck) Name() string { return "binding" }
+
+func (bindingCheck) Run(pass *lint.Pass) {
+	syntax.Walk(pass.File, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok && !pass.IsBinding(id) {
+			if bind := pass.Binding(id); bind != nil {
+				pass.Reportf(id.NamePos, "%s bound at %d:%d", id.Name, bind.NamePos.Line, bind.NamePos.Col)
+			}
+		}
+		return true
+	})
+}
+
+func TestPassBindings(t *testing.T) {
+	const src = `
+x = 1
+def f(y, z=x):
+    w = [y for y in y]
+    return lambda: y + w[0] + z
+`
+	f, err := syntax.Parse("x.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, finding := range lint.File(allOptions, f, isPredeclared, []lint.Check{bindingCheck{}}) {
+		got = append(got, finding.Pos.String()+": "+finding.Message)
+	}
+	want := []string{
+		"x.star:3:12: x bound at 2:1",
+		"x.star:4:10: y bound at 4:16",
+		"x.star:4:21: y bound at 3:7",
+		"x.star:5:20: y bound at 3:7",
+		"x.star:5:24: w bound at 4:5",
+		"x.star:5:31: z bound at 3:10",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("finding %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
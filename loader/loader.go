@@ -0,0 +1,355 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package loader provides an implementation of the Starlark load
+// statement that reads modules from a file system.
+//
+// A Loader resolves the name of each loaded module to a path within
+// its file system, executes each module at most once, and shares the
+// result with all threads that load it. It may be used by many threads
+// at once, and modules requested concurrently are loaded in parallel.
+// Cycles in the load graph are reported as errors, not deadlocks.
+//
+// Example:
+//
+//	l := &loader.Loader{FS: os.DirFS(root), Path: []string{"lib"}}
+//	thread := &starlark.Thread{Load: l.Load}
+//	globals, err := l.Load(thread, "//pkg:main.star")
+package loader // import "go.starlark.net/loader"
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+// A Loader loads Starlark modules from a file system.
+//
+// Module names are resolved as follows:
+//
+//	"//pkg/dir:file.star"  the file pkg/dir/file.star, relative to the root of FS
+//	"//pkg/file.star"      the file pkg/file.star, relative to the root of FS
+//	":file.star"           file.star in the directory of the loading module
+//	"./file.star"          likewise; names beginning with "../" are also relative
+//	"file.star"            the first file named file.star in a directory of Path
+//
+// The directory of the loading module is that of the file name of the
+// top frame of the thread that calls Load. For threads that are not
+// executing a module in FS, it is the root.
+//
+// The fields of a Loader must not be modified after the first call to Load.
+type Loader struct {
+	// FS is the file system from which modules are read.
+	FS fs.FS
+
+	// Path is the list of directories within FS that are searched,
+	// in order, for modules whose names are neither labels nor
+	// relative. If empty, only the root is searched.
+	Path []string
+
+	// Options specifies the dialect of loaded modules.
+	// If nil, resolve.LegacyOptions() is used.
+	Options *resolve.Options
+
+	// Predeclared is the environment of predeclared names
+	// available to every loaded module.
+	Predeclared starlark.StringDict
+
+	// Cache, if non-nil, holds the compiled programs of modules.
+	Cache *starlark.ProgramCache
+
+	mu      sync.Mutex // guards modules and the cycle-detection state
+	modules map[string]*entry
+}
+
+// An entry records the state of a module that has been requested.
+type entry struct {
+	name    string      // path of the module within FS
+	owner   *loadThread // logical thread loading the module; nil once ready
+	globals starlark.StringDict
+	err     error
+	ready   chan struct{} // closed when globals and err are set
+}
+
+// A loadThread is a logical thread of loading: a call to Load by a
+// client, and all the loads performed, directly or indirectly, by
+// the module it loads. It corresponds to a thread in the deadlock
+// detection literature.
+//
+// A thread whose ParallelLoad flag is set loads each module in a new
+// logical thread, a child of the one executing the loading module,
+// which waits for all its children.
+type loadThread struct {
+	parent   *loadThread
+	children map[*loadThread]bool // active children
+	stack    []string             // modules being loaded, outermost first
+	waitsFor *entry               // module the thread is waiting for, or nil
+}
+
+// localKey is the key of the thread-local value holding the
+// *loadThread of a Starlark thread that executes a module.
+const localKey = "go.starlark.net/loader.loadThread"
+
+// Load loads the named module and returns its globals. Its signature
+// is that of the Load field of starlark.Thread.
+//
+// A module is executed by a new thread, with the Print function and
+// ParallelLoad flag of the thread that first requests it. The new
+// thread inherits the cancellation and remaining step and allocation
+// budgets of that thread, to which its costs are then charged (see
+// starlark.Thread.InheritLimits). Subsequent calls for the same module,
+// by any thread, return the same globals and error, even if the module
+// failed because it exceeded the limits of the thread that requested it.
+func (l *Loader) Load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	var from string
+	if fr := thread.TopFrame(); fr != nil {
+		from = fr.Position().Filename()
+	}
+	name, err := l.Locate(from, module)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	lt, _ := thread.Local(localKey).(*loadThread)
+	if lt == nil || thread.ParallelLoad {
+		// Calls to Load may be concurrent; each starts a new logical thread.
+		child := &loadThread{parent: lt}
+		if lt != nil {
+			if lt.children == nil {
+				lt.children = make(map[*loadThread]bool)
+			}
+			lt.children[child] = true
+			defer func() {
+				l.mu.Lock()
+				delete(lt.children, child)
+				l.mu.Unlock()
+			}()
+		}
+		lt = child
+	}
+
+	if l.modules == nil {
+		l.modules = make(map[string]*entry)
+	}
+	e := l.modules[name]
+	if e != nil {
+		// The module has been requested before.
+		// Wait for it to become ready, unless that would deadlock.
+		if cycle := lt.waitCycle(e); cycle != nil {
+			l.mu.Unlock()
+			return nil, fmt.Errorf("cycle in load graph: %s", strings.Join(cycle, " -> "))
+		}
+		lt.waitsFor = e
+		l.mu.Unlock()
+
+		<-e.ready
+
+		l.mu.Lock()
+		lt.waitsFor = nil
+		l.mu.Unlock()
+	} else {
+		// First request for this module.
+		e = &entry{name: name, owner: lt, ready: make(chan struct{})}
+		l.modules[name] = e
+		lt.stack = append(lt.stack, name)
+		l.mu.Unlock()
+
+		child := l.newThread(thread, lt, name)
+		e.globals, e.err = l.exec(child, name)
+
+		l.mu.Lock()
+		e.owner = nil
+		lt.stack = lt.stack[:len(lt.stack)-1]
+		// The lock serializes the charges of parallel loads.
+		err := thread.ChargeChild(child)
+		l.mu.Unlock()
+
+		// Broadcast that the entry is now ready.
+		close(e.ready)
+
+		if err != nil {
+			return nil, err // the limits of thread, not the module, are exceeded
+		}
+	}
+	return e.globals, e.err
+}
+
+// waitCycle reports whether waiting for entry e would cause lt to wait
+// for itself. If so, it returns the cycle as a chain of modules, each
+// of which loads the next, starting and ending with a module being
+// loaded by lt or one of its ancestors. Its caller must hold the lock.
+//
+// The waits-for graph is a bipartite graph whose nodes are alternately
+// entries and loadThreads. An entry has an edge to its owner while it
+// is being loaded, and a loadThread has an edge to the entry it is
+// waiting for, if any, and to each of its active children. A cycle
+// exists if the graph contains a path from e to lt or to one of its
+// ancestors, all of which are waiting for lt.
+func (lt *loadThread) waitCycle(e *entry) []string {
+	path, last := lt.search(e)
+	if last == "" {
+		return nil
+	}
+	stack := lt.fullStack()
+	for i := range stack {
+		if stack[i] == last {
+			return concat(stack[i:], path, []string{last})
+		}
+	}
+	panic("unreachable")
+}
+
+// search follows the edges of the waits-for graph from entry e.
+// If it reaches a module being loaded by lt or one of its ancestors,
+// it returns that module, and the modules being loaded along the path.
+func (lt *loadThread) search(e *entry) (path []string, last string) {
+	owner := e.owner
+	if owner == nil {
+		return nil, "" // ready
+	}
+	for anc := lt; anc != nil; anc = anc.parent {
+		if anc == owner {
+			return nil, e.name
+		}
+	}
+	for i := range owner.stack {
+		if owner.stack[i] == e.name {
+			return lt.searchFrom(owner, owner.stack[i:])
+		}
+	}
+	return nil, ""
+}
+
+// searchFrom is like search, but starts at loadThread t,
+// reached by a path that loads the modules in prefix.
+func (lt *loadThread) searchFrom(t *loadThread, prefix []string) (path []string, last string) {
+	if t.waitsFor != nil {
+		if path, last := lt.search(t.waitsFor); last != "" {
+			return concat(prefix, path), last
+		}
+	}
+	for child := range t.children {
+		if path, last := lt.searchFrom(child, child.stack); last != "" {
+			return concat(prefix, path), last
+		}
+	}
+	return nil, ""
+}
+
+// fullStack returns the modules being loaded by lt and its ancestors,
+// outermost first.
+func (lt *loadThread) fullStack() []string {
+	if lt.parent == nil {
+		return lt.stack
+	}
+	return concat(lt.parent.fullStack(), lt.stack)
+}
+
+// concat returns a new slice containing the elements of each list.
+func concat(lists ...[]string) []string {
+	var res []string
+	for _, list := range lists {
+		res = append(res, list...)
+	}
+	return res
+}
+
+// newThread returns a new thread, a child of parent in the logical
+// thread lt, to execute the named module.
+func (l *Loader) newThread(parent *starlark.Thread, lt *loadThread, name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name:         "exec " + name,
+		Print:        parent.Print,
+		Load:         l.Load,
+		ParallelLoad: parent.ParallelLoad,
+	}
+	thread.SetLocal(localKey, lt)
+	thread.SetCoverage(parent.Coverage())
+	thread.InheritLimits(parent)
+	return thread
+}
+
+// exec executes the named module in the specified thread.
+func (l *Loader) exec(thread *starlark.Thread, name string) (starlark.StringDict, error) {
+	data, err := fs.ReadFile(l.FS, name)
+	if err != nil {
+		return nil, err
+	}
+	opts := l.Options
+	if opts == nil {
+		opts = resolve.LegacyOptions()
+	}
+	prog, err := l.Cache.SourceProgram(opts, name, data, l.Predeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := prog.Init(thread, l.Predeclared)
+	globals.Freeze()
+	return globals, err
+}
+
+// Locate returns the path within l.FS of the named module, as loaded
+// by the module whose file is from. If from is not a path within l.FS,
+// the module is loaded as if by a thread not executing a module in FS.
+// Locate neither reads nor executes the module, though it consults FS
+// to search Path.
+func (l *Loader) Locate(from, module string) (string, error) {
+	dir := "."
+	if fs.ValidPath(from) {
+		dir = path.Dir(from)
+	}
+	return l.locate(dir, module)
+}
+
+// locate returns the path within l.FS of the named module,
+// loaded by a module in directory dir.
+func (l *Loader) locate(dir, module string) (string, error) {
+	var name string
+	switch {
+	case strings.HasPrefix(module, "@"):
+		return "", fmt.Errorf("invalid module name %q: repository labels are not supported", module)
+
+	case strings.HasPrefix(module, "//"):
+		// Absolute label: "//pkg:file" or "//pkg/file".
+		label := module[len("//"):]
+		if i := strings.IndexByte(label, ':'); i >= 0 {
+			label = path.Join(label[:i], label[i+1:])
+		}
+		name = path.Clean(label)
+
+	case strings.HasPrefix(module, ":"):
+		// Label relative to the current package.
+		name = path.Join(dir, module[len(":"):])
+
+	case strings.HasPrefix(module, "./"), strings.HasPrefix(module, "../"):
+		name = path.Join(dir, module)
+
+	default:
+		if !fs.ValidPath(module) {
+			return "", fmt.Errorf("invalid module name %q", module)
+		}
+		search := l.Path
+		if len(search) == 0 {
+			search = []string{"."}
+		}
+		for _, d := range search {
+			name := path.Join(d, module)
+			if info, err := fs.Stat(l.FS, name); err == nil && !info.IsDir() {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("module %q not found in search path", module)
+	}
+
+	if name == ".." || strings.HasPrefix(name, "../") || !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid module name %q: outside the root of the file system", module)
+	}
+	return name, nil
+}
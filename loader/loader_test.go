@@ -0,0 +1,242 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loader_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"go.starlark.net/loader"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+func files(m map[string]string) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	for name, data := range m {
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	return fsys
+}
+
+func TestResolution(t *testing.T) {
+	l := &loader.Loader{
+		FS: files(map[string]string{
+			"main.star":            `load("//pkg/sub:a.star", "a"); load("lib.star", "lib")`,
+			"pkg/sub/a.star":       `load(":b.star", "b"); load("../c.star", "c"); a = "a" + b + c`,
+			"pkg/sub/b.star":       `load("./d.star", "d"); b = "b" + d`,
+			"pkg/sub/d.star":       `d = "d"`,
+			"pkg/c.star":           `load("//pkg/sub/d.star", "d"); c = "c" + d`,
+			"lib.star":             `lib = "root"`,
+			"third_party/lib.star": `lib = "third_party"`,
+		}),
+		Path: []string{"third_party", "."},
+	}
+	thread := &starlark.Thread{Load: l.Load}
+	globals, err := l.Load(thread, "//:main.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%s %s", globals["a"], globals["lib"]), `"abdcd" "third_party"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	for _, test := range []struct{ from, module, want string }{
+		{"pkg/sub/a.star", ":b.star", "pkg/sub/b.star"},
+		{"pkg/sub/a.star", "../c.star", "pkg/c.star"},
+		{"pkg/sub/a.star", "lib.star", "third_party/lib.star"},
+		{"", "./lib.star", "lib.star"},
+		{"/abs/main.star", ":lib.star", "lib.star"}, // not in FS
+	} {
+		if got, err := l.Locate(test.from, test.module); err != nil || got != test.want {
+			t.Errorf("Locate(%q, %q) = %q, %v, want %q", test.from, test.module, got, err, test.want)
+		}
+	}
+
+	for _, test := range []struct{ module, want string }{
+		{"@repo//pkg:a.star", `invalid module name "@repo//pkg:a.star": repository labels are not supported`},
+		{"//../x.star", `invalid module name "//../x.star": outside the root of the file system`},
+		{"../x.star", `invalid module name "../x.star": outside the root of the file system`},
+		{"/abs.star", `invalid module name "/abs.star"`},
+		{"missing.star", `module "missing.star" not found in search path`},
+		{"//missing.star", `open missing.star: file does not exist`},
+	} {
+		_, err := l.Load(thread, test.module)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("Load(%q) = %v, want %s", test.module, err, test.want)
+		}
+	}
+}
+
+func TestSharing(t *testing.T) {
+	var mu sync.Mutex
+	var printed []string
+	l := &loader.Loader{FS: files(map[string]string{
+		"a.star": `load("c.star", "c"); a = c`,
+		"b.star": `load("c.star", "c"); b = c`,
+		"c.star": `print("executing c"); c = []`,
+	})}
+
+	// Load a and b in parallel; each loads c.
+	var wg sync.WaitGroup
+	results := make([]starlark.Value, 2)
+	for i, name := range []string{"a", "b"} {
+		i, name := i, name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			thread := &starlark.Thread{
+				Print: func(_ *starlark.Thread, msg string) {
+					mu.Lock()
+					printed = append(printed, msg)
+					mu.Unlock()
+				},
+			}
+			globals, err := l.Load(thread, name+".star")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = globals[name]
+		}()
+	}
+	wg.Wait()
+
+	// c is executed once, and its globals are shared.
+	if len(printed) != 1 {
+		t.Errorf("c.star printed %q, want one line", printed)
+	}
+	if results[0] != results[1] {
+		t.Errorf("a and b got different values of c")
+	}
+}
+
+func TestCycle(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		l := &loader.Loader{FS: files(map[string]string{
+			"a.star": `load("b.star", "b"); load("c.star", "c")`,
+			"b.star": `load("c.star", "c")`,
+			"c.star": `load("a.star", "a")`,
+		})}
+		thread := &starlark.Thread{ParallelLoad: parallel}
+		_, err := l.Load(thread, "a.star")
+		if err == nil {
+			t.Errorf("parallel=%t: no error", parallel)
+			continue
+		}
+		// When b and c are loaded in parallel,
+		// c may be loaded by either of them.
+		got := err.Error()
+		const want = "cannot load b.star: cannot load c.star: cannot load a.star: cycle in load graph: a.star -> b.star -> c.star -> a.star"
+		const alt = "cannot load b.star: cannot load c.star: cannot load a.star: cycle in load graph: a.star -> c.star -> a.star"
+		if got != want && !(parallel && got == alt) {
+			t.Errorf("parallel=%t: got error %s, want %s", parallel, got, want)
+		}
+	}
+}
+
+// TestParallelCycle demonstrates detection of cycles whose modules
+// are loaded by different threads.
+func TestParallelCycle(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		l := &loader.Loader{FS: files(map[string]string{
+			"a.star": `load("c.star", "c")`,
+			"b.star": `load("a.star", "a")`,
+			"c.star": `load("b.star", "b")`,
+		})}
+
+		// Load b and c in parallel.
+		errors := make([]string, 2)
+		var wg sync.WaitGroup
+		for i, name := range []string{"b", "c"} {
+			i, name := i, name
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				thread := &starlark.Thread{ParallelLoad: i%2 == 0}
+				_, err := l.Load(thread, name+".star")
+				if err != nil {
+					errors[i] = err.Error()
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Exactly one thread detects the cycle, and both fail.
+		// Whichever it is, the cycle is a -> c -> b -> a, in some rotation.
+		var cycles []string
+		for _, msg := range errors {
+			if i := strings.Index(msg, "cycle in load graph: "); i >= 0 {
+				cycles = append(cycles, msg[i+len("cycle in load graph: "):])
+			} else {
+				t.Errorf("unexpected error %q", msg)
+			}
+		}
+		sort.Strings(cycles)
+		if len(cycles) != 2 || cycles[0] != cycles[1] {
+			t.Fatalf("got errors %q, want the same cycle twice", errors)
+		}
+		chain := strings.Split(cycles[0], " -> ")
+		if len(chain) != 4 || chain[0] != chain[3] ||
+			!strings.Contains("a.star c.star b.star a.star c.star b.star", strings.Join(chain[:3], " ")) {
+			t.Fatalf("got cycle %s, want a rotation of a -> c -> b -> a", cycles[0])
+		}
+	}
+}
+
+// TestLimits checks that a loaded module is subject to the
+// cancellation and limits of the thread that loads it.
+func TestLimits(t *testing.T) {
+	l := &loader.Loader{FS: files(map[string]string{
+		"steps.star":  `x = [i for i in range(100000)]`,
+		"allocs.star": `x = [0] * 1000000`,
+		"cheap.star":  `x = [i for i in range(100)]`,
+	})}
+	for _, test := range []struct {
+		module, want string
+		limit        func(thread *starlark.Thread)
+	}{
+		{"steps.star", "too many steps", func(thread *starlark.Thread) { thread.SetMaxExecutionSteps(1000) }},
+		{"allocs.star", "exceeded memory allocation limit", func(thread *starlark.Thread) { thread.SetMaxAllocs(1 << 16) }},
+	} {
+		thread := new(starlark.Thread)
+		test.limit(thread)
+		if _, err := l.Load(thread, test.module); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("load %s: got error %v, want %q", test.module, err, test.want)
+		}
+	}
+
+	// The steps of a loaded module are charged to the loading thread.
+	thread := new(starlark.Thread)
+	if _, err := l.Load(thread, "cheap.star"); err != nil {
+		t.Fatal(err)
+	}
+	if thread.ExecutionSteps() < 100 {
+		t.Errorf("loading thread executed %d steps, want at least 100", thread.ExecutionSteps())
+	}
+}
+
+// TestCancelLoad checks that cancelling a thread
+// cancels the module it is loading.
+func TestCancelLoad(t *testing.T) {
+	l := &loader.Loader{
+		FS:      files(map[string]string{"loop.star": "def f():\n    while True: pass\nf()\n"}),
+		Options: &resolve.Options{Recursion: true},
+	}
+	thread := new(starlark.Thread)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		thread.Cancel("stop")
+	}()
+	_, err := l.Load(thread, "loop.star")
+	if err == nil || !strings.Contains(err.Error(), "Starlark computation cancelled: stop") {
+		t.Errorf("got error %v, want cancellation", err)
+	}
+}
@@ -26,25 +26,35 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"strings"
 
 	"github.com/chzyer/readline"
+	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
 )
 
 var interrupted = make(chan os.Signal, 1)
 
-// REPL executes a read, eval, print loop.
+// REPL executes a read, eval, print loop, using the dialect
+// specified by the deprecated package-level variables of the
+// resolve package. See REPLOptions.
+func REPL(thread *starlark.Thread, globals starlark.StringDict) {
+	REPLOptions(resolve.LegacyOptions(), thread, globals)
+}
+
+// REPLOptions executes a read, eval, print loop
+// in the dialect specified by opts.
 //
 // Before evaluating each expression, it sets the Starlark thread local
 // variable named "context" to a context.Context that is cancelled by a
 // SIGINT (Control-C). Client-supplied global functions may use this
 // context to make long-running operations interruptable.
 //
-func REPL(thread *starlark.Thread, globals starlark.StringDict) {
+func REPLOptions(opts *resolve.Options, thread *starlark.Thread, globals starlark.StringDict) {
 	signal.Notify(interrupted, os.Interrupt)
 	defer signal.Stop(interrupted)
 
@@ -55,7 +65,7 @@ func REPL(thread *starlark.Thread, globals starlark.StringDict) {
 	}
 	defer rl.Close()
 	for {
-		if err := rep(rl, thread, globals); err != nil {
+		if err := rep(opts, rl, thread, globals); err != nil {
 			if err == readline.ErrInterrupt {
 				fmt.Println(err)
 				continue
@@ -70,7 +80,7 @@ func REPL(thread *starlark.Thread, globals starlark.StringDict) {
 //
 // It returns an error (possibly readline.ErrInterrupt)
 // only if readline failed. Starlark errors are printed.
-func rep(rl *readline.Instance, thread *starlark.Thread, globals starlark.StringDict) error {
+func rep(opts *resolve.Options, rl *readline.Instance, thread *starlark.Thread, globals starlark.StringDict) error {
 	// Each item gets its own context,
 	// which is cancelled by a SIGINT.
 	//
@@ -100,7 +110,7 @@ func rep(rl *readline.Instance, thread *starlark.Thread, globals starlark.String
 
 	// If the line contains a well-formed expression, evaluate it.
 	if _, err := syntax.ParseExpr("<stdin>", line, 0); err == nil {
-		if v, err := starlark.Eval(thread, "<stdin>", line, globals); err != nil {
+		if v, err := starlark.EvalOptions(opts, thread, "<stdin>", line, globals); err != nil {
 			PrintError(err)
 		} else if v != starlark.None {
 			fmt.Println(v)
@@ -114,7 +124,7 @@ func rep(rl *readline.Instance, thread *starlark.Thread, globals starlark.String
 		switch f.Stmts[0].(type) {
 		case *syntax.AssignStmt, *syntax.LoadStmt:
 			// Execute it as a file.
-			if err := execFileNoFreeze(thread, line, globals); err != nil {
+			if err := execFileNoFreeze(opts, thread, line, globals); err != nil {
 				PrintError(err)
 			}
 			return nil
@@ -145,7 +155,7 @@ func rep(rl *readline.Instance, thread *starlark.Thread, globals starlark.String
 	//     2
 	//   )
 	if _, err := syntax.ParseExpr("<stdin>", text, 0); err == nil {
-		if v, err := starlark.Eval(thread, "<stdin>", text, globals); err != nil {
+		if v, err := starlark.EvalOptions(opts, thread, "<stdin>", text, globals); err != nil {
 			PrintError(err)
 		} else if v != starlark.None {
 			fmt.Println(v)
@@ -154,7 +164,7 @@ func rep(rl *readline.Instance, thread *starlark.Thread, globals starlark.String
 	}
 
 	// Execute it as a file.
-	if err := execFileNoFreeze(thread, text, globals); err != nil {
+	if err := execFileNoFreeze(opts, thread, text, globals); err != nil {
 		PrintError(err)
 	}
 
@@ -162,8 +172,8 @@ func rep(rl *readline.Instance, thread *starlark.Thread, globals starlark.String
 }
 
 // execFileNoFreeze is starlark.ExecFile without globals.Freeze().
-func execFileNoFreeze(thread *starlark.Thread, src interface{}, globals starlark.StringDict) error {
-	_, prog, err := starlark.SourceProgram("<stdin>", src, globals.Has)
+func execFileNoFreeze(opts *resolve.Options, thread *starlark.Thread, src interface{}, globals starlark.StringDict) error {
+	_, prog, err := starlark.SourceProgramOptions(opts, "<stdin>", src, globals.Has)
 	if err != nil {
 		return err
 	}
@@ -184,18 +194,45 @@ func execFileNoFreeze(thread *starlark.Thread, src interface{}, globals starlark
 
 // PrintError prints the error to stderr,
 // or its backtrace if it is a Starlark evaluation error.
+// Each error in a list of syntax or resolver errors
+// is printed on a separate line.
 func PrintError(err error) {
-	if evalErr, ok := err.(*starlark.EvalError); ok {
-		fmt.Fprintln(os.Stderr, evalErr.Backtrace())
-	} else {
+	switch err := err.(type) {
+	case *starlark.EvalError:
+		fmt.Fprintln(os.Stderr, err.Backtrace())
+	case syntax.ErrorList:
+		for _, e := range err {
+			fmt.Fprintln(os.Stderr, e)
+		}
+	case resolve.ErrorList:
+		for _, e := range err {
+			fmt.Fprintln(os.Stderr, e)
+		}
+	default:
 		fmt.Fprintln(os.Stderr, err)
 	}
 }
 
 // MakeLoad returns a simple sequential implementation of module loading
-// suitable for use in the REPL.
-// Each function returned by MakeLoad accesses a distinct private cache.
+// suitable for use in the REPL, using the dialect specified by the
+// deprecated package-level variables of the resolve package.
+// See MakeLoadOptions.
 func MakeLoad() func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	return MakeLoadOptions(resolve.LegacyOptions())
+}
+
+// MakeLoadOptions returns a simple sequential implementation of module
+// loading suitable for use in the REPL. Each module is loaded in the
+// dialect specified by opts.
+// Each function returned by MakeLoadOptions accesses a distinct private cache.
+func MakeLoadOptions(opts *resolve.Options) func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	return MakeLoadCache(opts, nil, nil)
+}
+
+// MakeLoadCache is like MakeLoadOptions, but obtains the compiled
+// program of each module from the specified persistent cache, which may be nil,
+// and executes each module with the specified predeclared names.
+func MakeLoadCache(opts *resolve.Options, progs *starlark.ProgramCache, predeclared starlark.StringDict) func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
 	type entry struct {
 		globals starlark.StringDict
 		err     error
@@ -215,13 +252,37 @@ func MakeLoad() func(thread *starlark.Thread, module string) (starlark.StringDic
 			cache[module] = nil
 
 			// Load it.
-			thread := &starlark.Thread{Name: "exec " + module, Load: thread.Load}
-			globals, err := starlark.ExecFile(thread, module, nil, nil)
+			// The new thread records coverage with the loading thread,
+			// and is subject to its cancellation and limits.
+			child := &starlark.Thread{Name: "exec " + module, Load: thread.Load}
+			child.SetCoverage(thread.Coverage())
+			child.InheritLimits(thread)
+			globals, err := execFile(opts, progs, child, module, predeclared)
 			e = &entry{globals, err}
 
 			// Update the cache.
 			cache[module] = e
+
+			if err := thread.ChargeChild(child); err != nil {
+				return nil, err
+			}
 		}
 		return e.globals, e.err
 	}
 }
+
+// execFile executes the named file with the specified predeclared
+// names, using the compiled program from progs if it has one.
+func execFile(opts *resolve.Options, progs *starlark.ProgramCache, thread *starlark.Thread, filename string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := progs.SourceProgram(opts, filename, src, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := prog.Init(thread, predeclared)
+	globals.Freeze()
+	return globals, err
+}
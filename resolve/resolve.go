@@ -77,6 +77,7 @@ package resolve // import "go.starlark.net/resolve"
 import (
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 
 	"go.starlark.net/syntax"
@@ -88,6 +89,10 @@ const doesnt = "this Starlark dialect does not "
 // global options
 // These features are either not standard Starlark (yet), or deprecated
 // features of the BUILD language, so we put them behind flags.
+//
+// Deprecated: these variables affect every file resolved by the
+// process. Use an Options value instead; they now serve only as the
+// defaults returned by LegacyOptions.
 var (
 	AllowNestedDef      = false // allow def statements within function bodies
 	AllowLambda         = false // allow lambda expressions
@@ -98,7 +103,46 @@ var (
 	AllowRecursion      = false // allow while statements and recursive functions
 )
 
-// File resolves the specified file.
+// Options specifies the dialect of Starlark accepted by the resolver.
+// The zero value denotes the standard language, with no extensions.
+//
+// A compiled program records the options under which it was resolved,
+// so the interpreter can apply the same dialect when it runs.
+type Options struct {
+	NestedDef      bool // allow def statements within function bodies
+	Lambda         bool // allow lambda expressions
+	Float          bool // allow floating point literals, the 'float' built-in, and x / y
+	Set            bool // allow the 'set' built-in
+	GlobalReassign bool // allow reassignment to globals declared in same file (deprecated)
+	Bitwise        bool // allow bitwise operations (&, |, ^, ~, <<, and >>)
+	Recursion      bool // allow while statements and recursive functions
+	Try            bool // allow try/except statements
+}
+
+// LegacyOptions returns a new Options value whose settings are
+// those of the deprecated package-level Allow* variables.
+// It is provided for clients that have not yet migrated away from them.
+// Options that have no such variable, such as Try, are false.
+func LegacyOptions() *Options {
+	return &Options{
+		NestedDef:      AllowNestedDef,
+		Lambda:         AllowLambda,
+		Float:          AllowFloat,
+		Set:            AllowSet,
+		GlobalReassign: AllowGlobalReassign,
+		Bitwise:        AllowBitwise,
+		Recursion:      AllowRecursion,
+	}
+}
+
+// File resolves the specified file using the dialect
+// specified by the deprecated package-level variables.
+// It is equivalent to FileOptions(LegacyOptions(), ...).
+func File(file *syntax.File, isPredeclared, isUniversal func(name string) bool) error {
+	return FileOptions(LegacyOptions(), file, isPredeclared, isUniversal)
+}
+
+// FileOptions resolves the specified file in the dialect given by opts.
 //
 // The isPredeclared and isUniversal predicates report whether a name is
 // a pre-declared identifier (visible in the current module) or a
@@ -110,8 +154,8 @@ var (
 // The isUniverse predicate is supplied a parameter to avoid a cyclic
 // dependency upon starlark.Universe, not because users should ever need
 // to redefine it.
-func File(file *syntax.File, isPredeclared, isUniversal func(name string) bool) error {
-	r := newResolver(isPredeclared, isUniversal)
+func FileOptions(opts *Options, file *syntax.File, isPredeclared, isUniversal func(name string) bool) error {
+	r := newResolver(opts, isPredeclared, isUniversal)
 	r.stmts(file.Stmts)
 
 	r.env.resolveLocalUses()
@@ -125,30 +169,43 @@ func File(file *syntax.File, isPredeclared, isUniversal func(name string) bool)
 	file.Globals = r.moduleGlobals
 
 	if len(r.errors) > 0 {
-		return r.errors
+		return r.sortedErrors()
 	}
 	return nil
 }
 
-// Expr resolves the specified expression.
+// Expr resolves the specified expression using the dialect
+// specified by the deprecated package-level variables.
+// It is equivalent to ExprOptions(LegacyOptions(), ...).
+func Expr(expr syntax.Expr, isPredeclared, isUniversal func(name string) bool) ([]*syntax.Ident, error) {
+	return ExprOptions(LegacyOptions(), expr, isPredeclared, isUniversal)
+}
+
+// ExprOptions resolves the specified expression in the dialect given by opts.
 // It returns the local variables bound within the expression.
 //
 // The isPredeclared and isUniversal predicates behave as for the File function.
-func Expr(expr syntax.Expr, isPredeclared, isUniversal func(name string) bool) ([]*syntax.Ident, error) {
-	r := newResolver(isPredeclared, isUniversal)
+func ExprOptions(opts *Options, expr syntax.Expr, isPredeclared, isUniversal func(name string) bool) ([]*syntax.Ident, error) {
+	r := newResolver(opts, isPredeclared, isUniversal)
 	r.expr(expr)
 	r.env.resolveLocalUses()
 	r.resolveNonLocalUses(r.env) // globals & universals
 	if len(r.errors) > 0 {
-		return nil, r.errors
+		return nil, r.sortedErrors()
 	}
 	return r.moduleLocals, nil
 }
 
-// An ErrorList is a non-empty list of resolver error messages.
+// An ErrorList is a non-empty list of resolver error messages,
+// in order of position.
 type ErrorList []Error // len > 0
 
-func (e ErrorList) Error() string { return e[0].Error() }
+func (e ErrorList) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
 
 // An Error describes the nature and position of a resolver error.
 type Error struct {
@@ -181,8 +238,9 @@ var scopeNames = [...]string{
 
 func (scope Scope) String() string { return scopeNames[scope] }
 
-func newResolver(isPredeclared, isUniversal func(name string) bool) *resolver {
+func newResolver(opts *Options, isPredeclared, isUniversal func(name string) bool) *resolver {
 	return &resolver{
+		opts:          opts,
 		env:           new(block), // module block
 		isPredeclared: isPredeclared,
 		isUniversal:   isUniversal,
@@ -191,6 +249,8 @@ func newResolver(isPredeclared, isUniversal func(name string) bool) *resolver {
 }
 
 type resolver struct {
+	opts *Options // dialect
+
 	// env is the current local environment:
 	// a linked list of blocks, innermost first.
 	// The tail of the list is the module block.
@@ -285,6 +345,20 @@ func (r *resolver) errorf(posn syntax.Position, format string, args ...interface
 	r.errors = append(r.errors, Error{posn, fmt.Sprintf(format, args...)})
 }
 
+// sortedErrors returns the errors ordered by position.
+// References to undefined names are reported only at the end of
+// the module, after errors in later statements.
+func (r *resolver) sortedErrors() ErrorList {
+	sort.SliceStable(r.errors, func(i, j int) bool {
+		x, y := r.errors[i].Pos, r.errors[j].Pos
+		if x.Line != y.Line {
+			return x.Line < y.Line
+		}
+		return x.Col < y.Col
+	})
+	return r.errors
+}
+
 // A use records an identifier and the environment in which it appears.
 type use struct {
 	id  *syntax.Ident
@@ -305,7 +379,7 @@ func (r *resolver) bind(id *syntax.Ident, allowRebind bool) bool {
 			// they are of the form x += y.  We can't tell
 			// statically whether it's a reassignment
 			// (e.g. int += int) or a mutation (list += list).
-			if !allowRebind && !AllowGlobalReassign {
+			if !allowRebind && !r.opts.GlobalReassign {
 				r.errorf(id.NamePos, "cannot reassign global %s declared at %s", id.Name, prev.NamePos)
 			}
 			id.Index = prev.Index
@@ -365,9 +439,9 @@ func (r *resolver) use(id *syntax.Ident) {
 	//	proto_library(...) 			# user-defined rule
 	//
 	// We will piggyback support for the legacy semantics on the
-	// AllowGlobalReassign flag, which is loosely related and also
+	// GlobalReassign option, which is loosely related and also
 	// required for Bazel.
-	if AllowGlobalReassign && r.env.isModule() {
+	if r.opts.GlobalReassign && r.env.isModule() {
 		r.useGlobal(id)
 		return
 	}
@@ -385,10 +459,10 @@ func (r *resolver) useGlobal(id *syntax.Ident) binding {
 		scope = Predeclared // use of pre-declared
 	} else if r.isUniversal(id.Name) {
 		scope = Universal // use of universal name
-		if !AllowFloat && id.Name == "float" {
+		if !r.opts.Float && id.Name == "float" {
 			r.errorf(id.NamePos, doesnt+"support floating point")
 		}
-		if !AllowSet && id.Name == "set" {
+		if !r.opts.Set && id.Name == "set" {
 			r.errorf(id.NamePos, doesnt+"support sets")
 		}
 	} else {
@@ -439,7 +513,7 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		r.stmts(stmt.False)
 
 	case *syntax.AssignStmt:
-		if !AllowBitwise {
+		if !r.opts.Bitwise {
 			switch stmt.Op {
 			case syntax.AMP_EQ, syntax.PIPE_EQ, syntax.CIRCUMFLEX_EQ, syntax.LTLT_EQ, syntax.GTGT_EQ:
 				r.errorf(stmt.OpPos, doesnt+"support bitwise operations")
@@ -455,7 +529,7 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		r.assign(stmt.LHS, isAugmented)
 
 	case *syntax.DefStmt:
-		if !AllowNestedDef && r.container().function != nil {
+		if !r.opts.NestedDef && r.container().function != nil {
 			r.errorf(stmt.Def, doesnt+"support nested def")
 		}
 		const allowRebind = false
@@ -474,7 +548,7 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		r.loops--
 
 	case *syntax.WhileStmt:
-		if !AllowRecursion {
+		if !r.opts.Recursion {
 			r.errorf(stmt.While, "while loop not allowed")
 		}
 		if r.container().function == nil {
@@ -485,6 +559,20 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 		r.stmts(stmt.Body)
 		r.loops--
 
+	case *syntax.TryStmt:
+		if !r.opts.Try {
+			r.errorf(stmt.Try, doesnt+"support try statements")
+		}
+		if r.container().function == nil {
+			r.errorf(stmt.Try, "try statement not within a function")
+		}
+		r.stmts(stmt.Body)
+		if stmt.Name != nil {
+			const allowRebind = false
+			r.bind(stmt.Name, allowRebind)
+		}
+		r.stmts(stmt.Handler)
+
 	case *syntax.ReturnStmt:
 		if r.container().function == nil {
 			r.errorf(stmt.Return, "return statement not within a function")
@@ -570,7 +658,7 @@ func (r *resolver) expr(e syntax.Expr) {
 		r.use(e)
 
 	case *syntax.Literal:
-		if !AllowFloat && e.Token == syntax.FLOAT {
+		if !r.opts.Float && e.Token == syntax.FLOAT {
 			r.errorf(e.TokenPos, doesnt+"support floating point")
 		}
 
@@ -644,16 +732,16 @@ func (r *resolver) expr(e syntax.Expr) {
 		}
 
 	case *syntax.UnaryExpr:
-		if !AllowBitwise && e.Op == syntax.TILDE {
+		if !r.opts.Bitwise && e.Op == syntax.TILDE {
 			r.errorf(e.OpPos, doesnt+"support bitwise operations")
 		}
 		r.expr(e.X)
 
 	case *syntax.BinaryExpr:
-		if !AllowFloat && e.Op == syntax.SLASH {
+		if !r.opts.Float && e.Op == syntax.SLASH {
 			r.errorf(e.OpPos, doesnt+"support floating point (use //)")
 		}
-		if !AllowBitwise {
+		if !r.opts.Bitwise {
 			switch e.Op {
 			case syntax.AMP, syntax.PIPE, syntax.CIRCUMFLEX, syntax.LTLT, syntax.GTGT:
 				r.errorf(e.OpPos, doesnt+"support bitwise operations")
@@ -717,7 +805,7 @@ func (r *resolver) expr(e syntax.Expr) {
 		}
 
 	case *syntax.LambdaExpr:
-		if !AllowLambda {
+		if !r.opts.Lambda {
 			r.errorf(e.Lambda, doesnt+"support lambda")
 		}
 		r.function(e.Lambda, "lambda", &e.Function)
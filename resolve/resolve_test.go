@@ -24,13 +24,16 @@ func TestResolve(t *testing.T) {
 		}
 
 		// A chunk may set options by containing e.g. "option:float".
-		resolve.AllowNestedDef = option(chunk.Source, "nesteddef")
-		resolve.AllowLambda = option(chunk.Source, "lambda")
-		resolve.AllowFloat = option(chunk.Source, "float")
-		resolve.AllowSet = option(chunk.Source, "set")
-		resolve.AllowGlobalReassign = option(chunk.Source, "global_reassign")
+		opts := &resolve.Options{
+			NestedDef:      option(chunk.Source, "nesteddef"),
+			Lambda:         option(chunk.Source, "lambda"),
+			Float:          option(chunk.Source, "float"),
+			Set:            option(chunk.Source, "set"),
+			GlobalReassign: option(chunk.Source, "global_reassign"),
+			Try:            option(chunk.Source, "try"),
+		}
 
-		if err := resolve.File(f, isPredeclared, isUniversal); err != nil {
+		if err := resolve.FileOptions(opts, f, isPredeclared, isUniversal); err != nil {
 			for _, err := range err.(resolve.ErrorList) {
 				chunk.GotError(int(err.Pos.Line), err.Msg)
 			}
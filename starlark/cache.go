@@ -0,0 +1,137 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlark
+
+// This file defines a persistent cache of compiled programs.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"go.starlark.net/internal/compile"
+	"go.starlark.net/resolve"
+)
+
+// A ProgramCache is a persistent cache of compiled programs, stored as
+// files in a directory. It allows a program to be loaded without
+// parsing, resolving, and compiling its source again.
+//
+// Each entry is keyed by a hash of everything that determines the
+// compiled program: the source and name of the file, the dialect
+// options, the predeclared and universal names, and the version of the
+// compiler. Each entry records a checksum of its contents, and invalid
+// entries are ignored and replaced, so the cache may be shared by
+// concurrent processes, and damaged or deleted at any time.
+//
+// A nil *ProgramCache is valid, and caches nothing.
+type ProgramCache struct {
+	dir string
+}
+
+// NewProgramCache returns a cache of compiled programs stored in the
+// specified directory, which is created when first needed.
+func NewProgramCache(dir string) *ProgramCache {
+	return &ProgramCache{dir: dir}
+}
+
+// SourceProgram returns the compiled program for the specified source
+// file, in the dialect specified by opts, with the specified
+// predeclared names. The program is read from the cache if possible;
+// otherwise it is produced by SourceProgramOptions and then saved in
+// the cache.
+//
+// Errors reading or writing the cache are not reported; they cause
+// the program to be compiled anew. An error is returned only if the
+// source file contains syntax or resolver errors, as for
+// SourceProgramOptions.
+func (c *ProgramCache) SourceProgram(opts *resolve.Options, filename string, src []byte, predeclared StringDict) (*Program, error) {
+	if c == nil {
+		_, prog, err := SourceProgramOptions(opts, filename, src, predeclared.Has)
+		return prog, err
+	}
+
+	file := filepath.Join(c.dir, c.key(opts, filename, src, predeclared)+".sky")
+	if prog, err := c.read(file, filename); err == nil {
+		return prog, nil
+	} else if !os.IsNotExist(err) {
+		os.Remove(file) // invalid entry
+	}
+
+	_, prog, err := SourceProgramOptions(opts, filename, src, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+	c.write(file, prog.compiled.Encode())
+	return prog, nil
+}
+
+// key returns the name of the cache entry for a compilation.
+func (c *ProgramCache) key(opts *resolve.Options, filename string, src []byte, predeclared StringDict) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "version %d\n", compile.Version)
+	fmt.Fprintf(h, "options %+v\n", *opts)
+	fmt.Fprintf(h, "predeclared %q\n", predeclared.Keys())
+	fmt.Fprintf(h, "universe %q\n", Universe.Keys())
+	fmt.Fprintf(h, "filename %q\n", filename)
+	fmt.Fprintf(h, "source %d\n", len(src))
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// read reads and validates the cache entry in the specified file.
+// An entry is the encoding of a program followed by its SHA-256 hash.
+func (c *ProgramCache) read(file, filename string) (*Program, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < sha256.Size {
+		return nil, fmt.Errorf("%s: truncated cache entry", file)
+	}
+	data, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if want := sha256.Sum256(data); !bytes.Equal(sum, want[:]) {
+		return nil, fmt.Errorf("%s: checksum mismatch", file)
+	}
+	compiled, err := compile.DecodeProgram(data)
+	if err == nil {
+		err = compiled.Verify()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if got := compiled.Toplevel.Pos.Filename(); got != filename {
+		return nil, fmt.Errorf("%s: entry is for file %s, want %s", file, got, filename)
+	}
+	return &Program{compiled}, nil
+}
+
+// write saves an encoded program in the specified file. It first
+// writes a temporary file in the same directory, then renames it,
+// so that readers never observe an incomplete entry.
+func (c *ProgramCache) write(file string, data []byte) {
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	_, err = tmp.Write(append(data, sum[:]...))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlark_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+func TestProgramCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starlark-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := starlark.NewProgramCache(filepath.Join(dir, "cache"))
+	opts := &resolve.Options{}
+	predeclared := starlark.StringDict{"k": starlark.MakeInt(2)}
+
+	// run compiles src through the cache and returns the value of x.
+	run := func(src string) string {
+		prog, err := cache.SourceProgram(opts, "a.star", []byte(src), predeclared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		globals, err := prog.Init(new(starlark.Thread), predeclared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return globals["x"].String()
+	}
+	entries := func() []string {
+		names, _ := filepath.Glob(filepath.Join(dir, "cache", "*.sky"))
+		return names
+	}
+
+	// A miss compiles the program and adds an entry.
+	if got := run("x = k * 21"); got != "42" {
+		t.Errorf("x = %s, want 42", got)
+	}
+	if len(entries()) != 1 {
+		t.Fatalf("cache has %d entries, want 1", len(entries()))
+	}
+	entry := entries()[0]
+
+	// A hit uses the entry. To observe this, replace it by
+	// the entry of a different program with the same key.
+	if got := run("x = k * 3"); got != "6" {
+		t.Errorf("x = %s, want 6", got)
+	}
+	var otherEntry string
+	for _, name := range entries() {
+		if name != entry {
+			otherEntry = name
+		}
+	}
+	other, err := ioutil.ReadFile(otherEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(entry, other, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if got := run("x = k * 21"); got != "6" {
+		t.Errorf("after replacing the entry, x = %s, want 6 (from cache)", got)
+	}
+
+	// A damaged entry is ignored and replaced.
+	for _, data := range []string{"", "garbage", string(other[:len(other)-1]) + "?"} {
+		if err := ioutil.WriteFile(entry, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if got := run("x = k * 21"); got != "42" {
+			t.Errorf("with damaged entry %q, x = %s, want 42", data, got)
+		}
+		if data, err := ioutil.ReadFile(entry); err != nil || len(data) < 64 {
+			t.Errorf("damaged entry was not replaced: %q, %v", data, err)
+		}
+	}
+
+	// The key depends on the dialect and predeclared names.
+	opts.Float = true
+	run("x = k * 21")
+	predeclared["z"] = starlark.None
+	run("x = k * 21")
+	if n := len(entries()); n != 4 {
+		t.Errorf("cache has %d entries, want 4", n)
+	}
+
+	// Errors are reported.
+	if _, err := cache.SourceProgram(opts, "a.star", []byte("x = y"), predeclared); err == nil {
+		t.Errorf("resolver error not reported")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlark
+
+// This file defines the recording and reporting of code coverage.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"go.starlark.net/internal/compile"
+)
+
+// A Coverage records the number of times each line of Starlark code
+// was executed by the threads that share it. A line is counted each
+// time execution of a function reaches it from a different line.
+//
+// The executable lines of each file are known once any of its code has
+// been executed, so a report includes the lines of a file that were
+// never executed, such as those of uncalled functions, but omits files
+// that were loaded but never executed.
+//
+// A Coverage is safe for concurrent use by multiple threads.
+type Coverage struct {
+	mu    sync.Mutex
+	files map[string]map[int32]uint64 // execution count, by file name and line
+	progs map[*compile.Program]bool   // programs whose lines have been recorded
+}
+
+// NewCoverage returns a new, empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files: make(map[string]map[int32]uint64),
+		progs: make(map[*compile.Program]bool),
+	}
+}
+
+// SetCoverage causes the thread to record in cov the lines it executes.
+// A nil Coverage disables recording. SetCoverage must not be called
+// while the thread is executing.
+func (thread *Thread) SetCoverage(cov *Coverage) { thread.coverage = cov }
+
+// Coverage returns the Coverage in which the thread records the lines
+// it executes, or nil if it does not record them.
+func (thread *Thread) Coverage() *Coverage { return thread.coverage }
+
+// hit records an execution of the specified line of fn.
+func (cov *Coverage) hit(fn *compile.Funcode, line int32) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	if !cov.progs[fn.Prog] {
+		cov.progs[fn.Prog] = true
+		cov.addLines(fn.Prog.Toplevel)
+		for _, fn := range fn.Prog.Functions {
+			cov.addLines(fn)
+		}
+	}
+	cov.lines(fn.Pos.Filename())[line]++
+}
+
+// addLines records the executable lines of fn.
+func (cov *Coverage) addLines(fn *compile.Funcode) {
+	counts := cov.lines(fn.Pos.Filename())
+	for _, line := range fn.Lines() {
+		if _, ok := counts[line]; !ok {
+			counts[line] = 0
+		}
+	}
+}
+
+// lines returns the execution counts of the lines of the named file.
+func (cov *Coverage) lines(filename string) map[int32]uint64 {
+	counts := cov.files[filename]
+	if counts == nil {
+		counts = make(map[int32]uint64)
+		cov.files[filename] = counts
+	}
+	return counts
+}
+
+// Count returns the number of times the specified line was executed,
+// and whether it is a known executable line.
+func (cov *Coverage) Count(filename string, line int) (count uint64, ok bool) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	count, ok = cov.files[filename][int32(line)]
+	return
+}
+
+// A coverageLine is the execution count of a line in a report.
+type coverageLine struct {
+	line  int32
+	count uint64
+}
+
+// report calls f for each file, in order of name,
+// with its executable lines in increasing order.
+func (cov *Coverage) report(f func(filename string, lines []coverageLine)) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	var filenames []string
+	for filename := range cov.files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		var lines []coverageLine
+		for line, count := range cov.files[filename] {
+			lines = append(lines, coverageLine{line, count})
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i].line < lines[j].line })
+		f(filename, lines)
+	}
+}
+
+// WriteLCOV writes a coverage report in the LCOV tracefile format
+// understood by genhtml and many editors and continuous integration
+// services.
+func (cov *Coverage) WriteLCOV(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	cov.report(func(filename string, lines []coverageLine) {
+		fmt.Fprintf(out, "TN:\nSF:%s\n", filename)
+		hit := 0
+		for _, l := range lines {
+			fmt.Fprintf(out, "DA:%d,%d\n", l.line, l.count)
+			if l.count > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(out, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit)
+	})
+	return out.Flush()
+}
+
+// WriteGoCover writes a coverage report in the format of the profiles
+// written by 'go test -coverprofile', in "count" mode. Each executable
+// line is reported as a block of one statement that extends from the
+// start of the line to the start of the next.
+func (cov *Coverage) WriteGoCover(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "mode: count")
+	cov.report(func(filename string, lines []coverageLine) {
+		for _, l := range lines {
+			fmt.Fprintf(out, "%s:%d.1,%d.1 1 %d\n", filename, l.line, l.line+1, l.count)
+		}
+	})
+	return out.Flush()
+}
@@ -5,6 +5,8 @@ package starlark
 // (The debugger makes liberal use of exported fields of unexported types.)
 // Breaking changes may occur without notice.
 
+import "fmt"
+
 // Local returns the value of the i'th local variable.
 // It may be nil if not yet assigned.
 //
@@ -16,3 +18,81 @@ package starlark
 //
 // THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
 func (fr *Frame) Local(i int) Value { return fr.locals[i] }
+
+// NumLocals returns the number of local variables of the frame,
+// including its parameters. The same restrictions apply as for Local.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (fr *Frame) NumLocals() int { return len(fr.locals) }
+
+// LocalName returns the name of the i'th local variable.
+// The same restrictions apply as for Local.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (fr *Frame) LocalName(i int) string {
+	return fr.callable.(*Function).funcode.Locals[i].Name
+}
+
+// Locals returns a new dictionary of the local variables of the frame
+// that have been assigned, by name. If several variables have the
+// same name, such as those of two comprehensions, the value of the
+// last one is used. The same restrictions apply as for Local.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (fr *Frame) Locals() StringDict {
+	locals := make(StringDict, len(fr.locals))
+	for i, v := range fr.locals {
+		if v != nil {
+			locals[fr.LocalName(i)] = v
+		}
+	}
+	return locals
+}
+
+// Eval evaluates the expression expr in the environment of the frame,
+// in which its assigned local variables, its free variables, and the
+// globals and predeclared names of its module are visible. The
+// expression is resolved in the dialect of the frame's program, and
+// executed by thread, which must not be the thread executing the frame.
+//
+// Eval may be called only while the frame is active, typically from a
+// debug hook (see SetDebugHook) that has paused the frame's thread.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (fr *Frame) Eval(thread *Thread, expr string) (Value, error) {
+	fn, ok := fr.callable.(*Function)
+	if !ok {
+		return nil, fmt.Errorf("cannot evaluate in frame of %s", fr.callable.Name())
+	}
+	env := make(StringDict)
+	for name, v := range fn.predeclared {
+		env[name] = v
+	}
+	for name, v := range fn.Globals() {
+		env[name] = v
+	}
+	for i, id := range fn.funcode.Freevars {
+		env[id.Name] = fn.freevars[i]
+	}
+	for name, v := range fr.Locals() {
+		env[name] = v
+	}
+	opts := fn.funcode.Prog.Options
+	return EvalOptions(&opts, thread, "<eval>", expr, env)
+}
+
+// SetDebugHook sets a function to be called by the interpreter
+// whenever execution of a Starlark function reaches a new line, before
+// the line's first instruction is executed. The hook is called with
+// the thread and the frame of the function, whose Position reports
+// the line. Execution resumes when the hook returns, so a debugger may
+// pause the thread by blocking within the hook. If the thread has been
+// cancelled when the hook returns, execution fails promptly.
+//
+// A nil hook disables the calls. SetDebugHook must not be called while
+// the thread is executing, except from within the hook.
+//
+// THIS API IS EXPERIMENTAL AND MAY CHANGE WITHOUT NOTICE.
+func (thread *Thread) SetDebugHook(hook func(thread *Thread, fr *Frame)) {
+	thread.debugHook = hook
+}
@@ -6,6 +6,7 @@ package starlark
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,8 +15,10 @@ import (
 	"math/big"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
+	"unsafe"
 
 	"go.starlark.net/internal/compile"
 	"go.starlark.net/resolve"
@@ -47,9 +50,203 @@ type Thread struct {
 	// See example_test.go for some example implementations of Load.
 	Load func(thread *Thread, module string) (StringDict, error)
 
+	// ParallelLoad, if set, causes the modules named by the load
+	// statements of a program to be loaded concurrently, before
+	// execution of its top-level statements begins. Each load
+	// statement then reports the outcome of the call to Load for
+	// its module. Load must be safe for concurrent calls, all with
+	// this thread as argument.
+	ParallelLoad bool
+
 	// locals holds arbitrary "thread-local" Go values belonging to the client.
 	// They are accessible to the client but not to any Starlark program.
 	locals map[string]interface{}
+
+	// cancelReason records the reason from the first call to Cancel.
+	cancelReason unsafe.Pointer // a *string; accessed atomically
+
+	// steps counts abstract computation steps executed by this thread.
+	// maxSteps is the limit, or zero for none.
+	steps, maxSteps uint64
+
+	// allocs is the estimated number of bytes allocated by this thread.
+	// maxAllocs is the limit, or zero for none.
+	allocs, maxAllocs uint64
+
+	// parent, if non-nil, is a thread whose cancellation
+	// also cancels this one. See InheritLimits.
+	parent *Thread
+
+	// debugHook, if non-nil, is called at each line boundary.
+	// See SetDebugHook.
+	debugHook func(thread *Thread, fr *Frame)
+
+	// coverage, if non-nil, records the lines executed by this thread.
+	// See SetCoverage.
+	coverage *Coverage
+
+	// profTick is the value of the profiler's clock when
+	// this thread last observed it. See StartProfile.
+	profTick uint32
+}
+
+// ExecutionSteps returns a count of abstract computation steps executed
+// by this thread. It is incremented by the interpreter. It may be used
+// as a measure of the approximate cost of Starlark execution, by
+// computing the difference in its value before and after a computation.
+//
+// The precise meaning of "step" is not specified and may change.
+func (thread *Thread) ExecutionSteps() uint64 { return thread.steps }
+
+// SetMaxExecutionSteps sets a limit on the number of Starlark
+// computation steps that may be executed by this thread. If the
+// thread's step counter exceeds this limit, the interpreter calls
+// thread.Cancel("too many steps"), so evaluation fails with an
+// EvalError whose message is "Starlark computation cancelled: too many steps".
+// A limit of zero means no limit.
+//
+// Unlike a wall-clock timeout, the limit is deterministic: the same
+// program applied to the same inputs always executes the same number
+// of steps.
+func (thread *Thread) SetMaxExecutionSteps(max uint64) { thread.maxSteps = max }
+
+// Allocs returns an estimate of the number of bytes of memory allocated
+// by Starlark computation in this thread. As with ExecutionSteps, it
+// may be used to measure the cost of a computation by taking the
+// difference in its value before and after.
+//
+// The estimate covers the principal data structures of the core value
+// types (strings, lists, tuples, dicts, sets, and big integers) and
+// the interpreter's own stack frames, plus anything recorded by
+// built-in functions through AddAllocs. It is not a precise measure
+// of heap usage.
+func (thread *Thread) Allocs() uint64 { return thread.allocs }
+
+// SetMaxAllocs sets a limit on the estimated number of bytes that may
+// be allocated by this thread. If an allocation would cause the
+// limit to be exceeded, the interpreter calls
+// thread.Cancel("exceeded memory allocation limit") before the memory
+// is allocated, so evaluation fails with an EvalError.
+// A limit of zero means no limit.
+func (thread *Thread) SetMaxAllocs(max uint64) { thread.maxAllocs = max }
+
+// AddAllocs records an allocation of approximately n bytes against
+// the thread's budget. Built-in functions that allocate memory in
+// proportion to their inputs should call AddAllocs before allocating,
+// and return its error, if any.
+//
+// If the allocation exceeds the limit set by SetMaxAllocs, AddAllocs
+// cancels the thread and returns an error. A negative n, typically
+// the result of an overflowing size computation, always fails.
+func (thread *Thread) AddAllocs(n int64) error {
+	if n < 0 {
+		thread.Cancel("exceeded memory allocation limit")
+		return thread.cancelled()
+	}
+	if thread.allocs += uint64(n); thread.allocs < uint64(n) {
+		thread.allocs = math.MaxUint64 // saturate
+	}
+	if thread.allocs > thread.maxAllocs && thread.maxAllocs != 0 {
+		thread.Cancel("exceeded memory allocation limit")
+		return thread.cancelled()
+	}
+	return nil
+}
+
+// addAllocs is like AddAllocs but permits a nil thread,
+// for operations invoked outside of any thread.
+func (thread *Thread) addAllocs(n int64) error {
+	if thread == nil {
+		return nil
+	}
+	return thread.AddAllocs(n)
+}
+
+// Approximate sizes, in bytes, of value representations,
+// for use in memory accounting.
+const (
+	valueSize  = int64(unsafe.Sizeof(Value(nil)))
+	stringSize = int64(unsafe.Sizeof(""))
+	listSize   = int64(unsafe.Sizeof(List{}))
+	tupleSize  = int64(unsafe.Sizeof(Tuple(nil)))
+	dictSize   = int64(unsafe.Sizeof(Dict{}))
+)
+
+// Cancel causes execution of Starlark code in the specified thread to
+// promptly fail with an EvalError that includes the specified reason.
+// The interpreter observes cancellation on entry to each Starlark
+// function, after each call, and at each backward jump (loop iteration),
+// so there may be a delay if the thread is currently in a call to a
+// long-running built-in function.
+//
+// Cancellation cannot be undone; only the reason from the first call
+// is recorded.
+//
+// Unlike most methods of Thread, it is safe to call Cancel from any
+// goroutine, even if the thread is actively executing.
+func (thread *Thread) Cancel(reason string) {
+	atomic.CompareAndSwapPointer(&thread.cancelReason, nil, unsafe.Pointer(&reason))
+}
+
+// cancelled returns an error if the thread, or the parent
+// from which it inherits its limits, has been cancelled.
+func (thread *Thread) cancelled() error {
+	for t := thread; t != nil; t = t.parent {
+		if reason := atomic.LoadPointer(&t.cancelReason); reason != nil {
+			return fmt.Errorf("Starlark computation cancelled: %s", *(*string)(reason))
+		}
+	}
+	return nil
+}
+
+// InheritLimits makes thread, which must not yet have begun execution,
+// subject to the cancellation and resource limits of parent, as when
+// thread executes a module loaded by parent. Cancellation of parent,
+// even while thread is executing, causes thread to fail promptly, and
+// the step and allocation limits of thread are set to what remains of
+// the budgets of parent. When thread has finished, its costs should be
+// added to those of parent by parent.ChargeChild(thread).
+//
+// The budgets of children that execute concurrently are not shared, so
+// together they may exceed those of parent, though ChargeChild will
+// then cancel parent.
+func (thread *Thread) InheritLimits(parent *Thread) {
+	thread.parent = parent
+	if parent.maxSteps != 0 {
+		if parent.steps >= parent.maxSteps {
+			thread.Cancel("too many steps")
+		}
+		thread.maxSteps = parent.maxSteps - parent.steps
+	}
+	if parent.maxAllocs != 0 {
+		if parent.allocs >= parent.maxAllocs {
+			thread.Cancel("exceeded memory allocation limit")
+		}
+		thread.maxAllocs = parent.maxAllocs - parent.allocs
+	}
+}
+
+// ChargeChild adds the steps and allocations of child, a thread that
+// has finished executing, to those of thread. If they exceed the
+// limits of thread, ChargeChild cancels thread and returns an error.
+// It is typically called by the Load function of thread, and must not
+// be called concurrently with other methods of thread, except Cancel.
+func (thread *Thread) ChargeChild(child *Thread) error {
+	if thread.steps += child.steps; thread.steps < child.steps {
+		thread.steps = math.MaxUint64 // saturate
+	}
+	if thread.steps > thread.maxSteps && thread.maxSteps != 0 {
+		thread.Cancel("too many steps")
+		return thread.cancelled()
+	}
+	if thread.allocs += child.allocs; thread.allocs < child.allocs {
+		thread.allocs = math.MaxUint64 // saturate
+	}
+	if thread.allocs > thread.maxAllocs && thread.maxAllocs != 0 {
+		thread.Cancel("exceeded memory allocation limit")
+		return thread.cancelled()
+	}
+	return nil
 }
 
 // SetLocal sets the thread-local value associated with the specified key.
@@ -78,12 +275,18 @@ func (thread *Thread) TopFrame() *Frame { return thread.frame }
 // It is not a true starlark.Value.
 type StringDict map[string]Value
 
-func (d StringDict) String() string {
+// Keys returns a new sorted slice of d's keys.
+func (d StringDict) Keys() []string {
 	names := make([]string, 0, len(d))
 	for name := range d {
 		names = append(names, name)
 	}
 	sort.Strings(names)
+	return names
+}
+
+func (d StringDict) String() string {
+	names := d.Keys()
 
 	var buf bytes.Buffer
 	path := make([]Value, 0, 4)
@@ -123,10 +326,28 @@ type Frame struct {
 // slice, so that an EvalError can copy a stack efficiently and immutably.
 // In hindsight using a slice would have led to a more convenient API.
 
-func (fr *Frame) errorf(posn syntax.Position, format string, args ...interface{}) *EvalError {
+// evalError returns an EvalError for the error err,
+// which occurred at the specified position in this frame.
+func (fr *Frame) evalError(posn syntax.Position, err error) *EvalError {
 	fr.posn = posn
-	msg := fmt.Sprintf(format, args...)
-	return &EvalError{Msg: msg, Frame: fr}
+	return &EvalError{
+		Msg:       err.Error(),
+		Frame:     fr,
+		CallStack: fr.callStack(),
+		cause:     err,
+	}
+}
+
+// callStack returns a snapshot of the stack whose innermost frame is fr.
+func (fr *Frame) callStack() CallStack {
+	var stack CallStack
+	for ; fr != nil; fr = fr.parent {
+		stack = append(stack, CallFrame{Name: fr.Callable().Name(), Pos: fr.Position()})
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i] // outermost first
+	}
+	return stack
 }
 
 // Position returns the source position of the current point of execution in this frame.
@@ -156,18 +377,90 @@ func (fr *Frame) Parent() *Frame { return fr.parent }
 
 // An EvalError is a Starlark evaluation error and its associated call stack.
 type EvalError struct {
-	Msg   string
+	Msg string
+
+	// Frame is the innermost frame of the failing thread. Its
+	// ancestors report their current positions only until the
+	// calls they represent return; use CallStack thereafter.
 	Frame *Frame
+
+	// CallStack is a snapshot of the call stack
+	// at the time of the error, outermost first.
+	CallStack CallStack
+
+	cause error // the underlying error
 }
 
 func (e *EvalError) Error() string { return e.Msg }
 
+// Unwrap returns the underlying error, such as the error returned by
+// a failing built-in function, or nil if there is none. Errors
+// returned by a built-in are wrapped only by the innermost EvalError;
+// the calling Starlark functions return the same EvalError.
+func (e *EvalError) Unwrap() error { return e.cause }
+
 // Backtrace returns a user-friendly error message describing the stack
 // of calls that led to this error.
 func (e *EvalError) Backtrace() string {
+	return fmt.Sprintf("%sError: %s", e.CallStack, e.Msg)
+}
+
+// An errorValue is the value of the variable of an except clause.
+type errorValue struct{ err *EvalError }
+
+var (
+	_ Value    = errorValue{}
+	_ HasAttrs = errorValue{}
+)
+
+func (e errorValue) String() string        { return fmt.Sprintf("error(%s)", String(e.err.Msg)) }
+func (e errorValue) Type() string          { return "error" }
+func (e errorValue) Freeze()               {} // immutable
+func (e errorValue) Truth() Bool           { return True }
+func (e errorValue) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: error") }
+func (e errorValue) AttrNames() []string   { return []string{"backtrace", "message"} }
+
+func (e errorValue) Attr(name string) (Value, error) {
+	switch name {
+	case "message":
+		return String(e.err.Msg), nil
+	case "backtrace":
+		return String(e.err.Backtrace()), nil
+	}
+	return nil, nil
+}
+
+// A CallFrame records the name of a function and the position of
+// execution within it, in one of the frames of a CallStack.
+type CallFrame struct {
+	Name string
+	Pos  syntax.Position
+}
+
+// MarshalJSON encodes the frame as a JSON object with fields
+// "name", "file", "line", and "col".
+func (fr CallFrame) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name string `json:"name"`
+		File string `json:"file"`
+		Line int32  `json:"line"`
+		Col  int32  `json:"col"`
+	}{fr.Name, fr.Pos.Filename(), fr.Pos.Line, fr.Pos.Col})
+}
+
+// A CallStack is an immutable snapshot of a stack of calls, outermost
+// first. It may be rendered as text by its String method, or as JSON
+// by encoding/json.
+type CallStack []CallFrame
+
+// String returns a user-friendly description of the stack,
+// in the same form as Frame.WriteBacktrace.
+func (stack CallStack) String() string {
 	var buf bytes.Buffer
-	e.Frame.WriteBacktrace(&buf)
-	fmt.Fprintf(&buf, "Error: %s", e.Msg)
+	fmt.Fprintf(&buf, "Traceback (most recent call last):\n")
+	for _, fr := range stack {
+		fmt.Fprintf(&buf, "  %s: in %s\n", fr.Pos, fr.Name)
+	}
 	return buf.String()
 }
 
@@ -213,6 +506,9 @@ func (prog *Program) Filename() string { return prog.compiled.Toplevel.Pos.Filen
 
 func (prog *Program) String() string { return prog.Filename() }
 
+// Options returns the dialect options under which the program was resolved.
+func (prog *Program) Options() resolve.Options { return prog.compiled.Options }
+
 // NumLoads returns the number of load statements in the compiled program.
 func (prog *Program) NumLoads() int { return len(prog.compiled.Loads) }
 
@@ -233,6 +529,15 @@ func (prog *Program) Write(out io.Writer) error {
 
 // ExecFile parses, resolves, and executes a Starlark file in the
 // specified global environment, which may be modified during execution.
+// The dialect is specified by the deprecated package-level variables
+// of the resolve package; see ExecFileOptions.
+func ExecFile(thread *Thread, filename string, src interface{}, predeclared StringDict) (StringDict, error) {
+	return ExecFileOptions(resolve.LegacyOptions(), thread, filename, src, predeclared)
+}
+
+// ExecFileOptions parses, resolves, and executes a Starlark file in the
+// specified global environment, which may be modified during execution.
+// The opts parameter specifies the dialect of the file.
 //
 // Thread is the state associated with the Starlark thread.
 //
@@ -246,11 +551,12 @@ func (prog *Program) Write(out io.Writer) error {
 // Execution does not modify this dictionary, though it may mutate
 // its values.
 //
-// If ExecFile fails during evaluation, it returns an *EvalError
-// containing a backtrace.
-func ExecFile(thread *Thread, filename string, src interface{}, predeclared StringDict) (StringDict, error) {
+// If ExecFileOptions fails during evaluation, it returns an *EvalError
+// containing a backtrace. Syntax and resolver errors are returned as
+// the syntax.ErrorList or resolve.ErrorList reporting all of them.
+func ExecFileOptions(opts *resolve.Options, thread *Thread, filename string, src interface{}, predeclared StringDict) (StringDict, error) {
 	// Parse, resolve, and compile a Starlark source file.
-	_, mod, err := SourceProgram(filename, src, predeclared.Has)
+	_, mod, err := SourceProgramOptions(opts, filename, src, predeclared.Has)
 	if err != nil {
 		return nil, err
 	}
@@ -260,22 +566,35 @@ func ExecFile(thread *Thread, filename string, src interface{}, predeclared Stri
 	return g, err
 }
 
-// SourceProgram produces a new program by parsing, resolving,
-// and compiling a Starlark source file.
+// SourceProgram is equivalent to SourceProgramOptions using the
+// dialect specified by the deprecated package-level variables
+// of the resolve package.
+func SourceProgram(filename string, src interface{}, isPredeclared func(string) bool) (*syntax.File, *Program, error) {
+	return SourceProgramOptions(resolve.LegacyOptions(), filename, src, isPredeclared)
+}
+
+// SourceProgramOptions produces a new program by parsing, resolving,
+// and compiling a Starlark source file in the dialect specified by opts.
+// The options are recorded in the program, and are preserved by
+// Program.Write.
 // On success, it returns the parsed file and the compiled program.
 // The filename and src parameters are as for syntax.Parse.
+// If the file contains syntax errors, the error is the
+// syntax.ErrorList returned by syntax.Parse; if it contains
+// resolver errors, the error is a resolve.ErrorList, and
+// the parsed file is returned too.
 //
 // The isPredeclared predicate reports whether a name is
 // a pre-declared identifier of the current module.
 // Its typical value is predeclared.Has,
 // where predeclared is a StringDict of pre-declared values.
-func SourceProgram(filename string, src interface{}, isPredeclared func(string) bool) (*syntax.File, *Program, error) {
+func SourceProgramOptions(opts *resolve.Options, filename string, src interface{}, isPredeclared func(string) bool) (*syntax.File, *Program, error) {
 	f, err := syntax.Parse(filename, src, 0)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if err := resolve.File(f, isPredeclared, Universe.Has); err != nil {
+	if err := resolve.FileOptions(opts, f, isPredeclared, Universe.Has); err != nil {
 		return f, nil, err
 	}
 
@@ -286,7 +605,7 @@ func SourceProgram(filename string, src interface{}, isPredeclared func(string)
 		pos = syntax.MakePosition(&filename, 1, 1)
 	}
 
-	compiled := compile.File(f.Stmts, pos, "<toplevel>", f.Locals, f.Globals)
+	compiled := compile.File(opts, f.Stmts, pos, "<toplevel>", f.Locals, f.Globals)
 
 	return f, &Program{compiled}, nil
 }
@@ -302,6 +621,9 @@ func CompiledProgram(in io.Reader) (*Program, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := compiled.Verify(); err != nil {
+		return nil, err
+	}
 	return &Program{compiled}, nil
 }
 
@@ -330,6 +652,8 @@ func makeToplevelFunction(funcode *compile.Funcode, predeclared StringDict) *Fun
 			v = Int{c}
 		case string:
 			v = String(c)
+		case compile.Bytes:
+			v = Bytes(c)
 		case float64:
 			v = Float(c)
 		default:
@@ -348,16 +672,24 @@ func makeToplevelFunction(funcode *compile.Funcode, predeclared StringDict) *Fun
 
 // Eval parses, resolves, and evaluates an expression within the
 // specified (predeclared) environment.
+// The dialect is specified by the deprecated package-level variables
+// of the resolve package; see EvalOptions.
+func Eval(thread *Thread, filename string, src interface{}, env StringDict) (Value, error) {
+	return EvalOptions(resolve.LegacyOptions(), thread, filename, src, env)
+}
+
+// EvalOptions parses, resolves, and evaluates an expression within the
+// specified (predeclared) environment, in the dialect specified by opts.
 //
 // Evaluation cannot mutate the environment dictionary itself,
 // though it may modify variables reachable from the dictionary.
 //
 // The filename and src parameters are as for syntax.Parse.
 //
-// If Eval fails during evaluation, it returns an *EvalError
+// If EvalOptions fails during evaluation, it returns an *EvalError
 // containing a backtrace.
-func Eval(thread *Thread, filename string, src interface{}, env StringDict) (Value, error) {
-	f, err := ExprFunc(filename, src, env)
+func EvalOptions(opts *resolve.Options, thread *Thread, filename string, src interface{}, env StringDict) (Value, error) {
+	f, err := ExprFuncOptions(opts, filename, src, env)
 	if err != nil {
 		return nil, err
 	}
@@ -366,35 +698,51 @@ func Eval(thread *Thread, filename string, src interface{}, env StringDict) (Val
 
 // ExprFunc returns a no-argument function
 // that evaluates the expression whose source is src.
+// The dialect is specified by the deprecated package-level variables
+// of the resolve package; see ExprFuncOptions.
 func ExprFunc(filename string, src interface{}, env StringDict) (*Function, error) {
+	return ExprFuncOptions(resolve.LegacyOptions(), filename, src, env)
+}
+
+// ExprFuncOptions returns a no-argument function
+// that evaluates the expression whose source is src,
+// in the dialect specified by opts.
+func ExprFuncOptions(opts *resolve.Options, filename string, src interface{}, env StringDict) (*Function, error) {
 	expr, err := syntax.ParseExpr(filename, src, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	locals, err := resolve.Expr(expr, env.Has, Universe.Has)
+	locals, err := resolve.ExprOptions(opts, expr, env.Has, Universe.Has)
 	if err != nil {
 		return nil, err
 	}
 
-	return makeToplevelFunction(compile.Expr(expr, "<expr>", locals), env), nil
+	return makeToplevelFunction(compile.Expr(opts, expr, "<expr>", locals), env), nil
 }
 
 // The following functions are primitive operations of the byte code interpreter.
 
 // list += iterable
-func listExtend(x *List, y Iterable) {
+func listExtend(thread *Thread, x *List, y Iterable) error {
 	if ylist, ok := y.(*List); ok {
 		// fast path: list += list
+		if err := thread.addAllocs(int64(len(ylist.elems)) * valueSize); err != nil {
+			return err
+		}
 		x.elems = append(x.elems, ylist.elems...)
 	} else {
 		iter := y.Iterate()
 		defer iter.Done()
 		var z Value
 		for iter.Next(&z) {
+			if err := thread.addAllocs(valueSize); err != nil {
+				return err
+			}
 			x.elems = append(x.elems, z)
 		}
 	}
+	return nil
 }
 
 // getAttr implements x.dot.
@@ -503,12 +851,26 @@ func Unary(op syntax.Token, x Value) (Value, error) {
 
 // Binary applies a strict binary operator (not AND or OR) to its operands.
 // For equality tests or ordered comparisons, use Compare instead.
-func Binary(op syntax.Token, x, y Value) (Value, error) {
+func Binary(op syntax.Token, x, y Value) (Value, error) { return binary(nil, op, x, y) }
+
+// binary implements Binary, charging the memory
+// allocated for the result to thread, if non-nil.
+func binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 	switch op {
 	case syntax.PLUS:
 		switch x := x.(type) {
 		case String:
 			if y, ok := y.(String); ok {
+				if err := thread.addAllocs(stringSize + int64(len(x)+len(y))); err != nil {
+					return nil, err
+				}
+				return x + y, nil
+			}
+		case Bytes:
+			if y, ok := y.(Bytes); ok {
+				if err := thread.addAllocs(stringSize + int64(len(x)+len(y))); err != nil {
+					return nil, err
+				}
 				return x + y, nil
 			}
 		case Int:
@@ -527,6 +889,9 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			}
 		case *List:
 			if y, ok := y.(*List); ok {
+				if err := thread.addAllocs(listSize + int64(x.Len()+y.Len())*valueSize); err != nil {
+					return nil, err
+				}
 				z := make([]Value, 0, x.Len()+y.Len())
 				z = append(z, x.elems...)
 				z = append(z, y.elems...)
@@ -534,6 +899,9 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			}
 		case Tuple:
 			if y, ok := y.(Tuple); ok {
+				if err := thread.addAllocs(tupleSize + int64(len(x)+len(y))*valueSize); err != nil {
+					return nil, err
+				}
 				z := make(Tuple, 0, len(x)+len(y))
 				z = append(z, x...)
 				z = append(z, y...)
@@ -557,6 +925,10 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			case Int:
 				return x - y.Float(), nil
 			}
+		case *Set: // difference
+			if y, ok := y.(*Set); ok {
+				return x.difference(thread, y)
+			}
 		}
 
 	case syntax.STAR:
@@ -564,19 +936,25 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 		case Int:
 			switch y := y.(type) {
 			case Int:
+				if err := thread.addAllocs(intAllocs(x.bigint.BitLen() + y.bigint.BitLen())); err != nil {
+					return nil, err
+				}
 				return x.Mul(y), nil
 			case Float:
 				return x.Float() * y, nil
 			case String:
-				return stringRepeat(y, x)
+				return stringRepeat(thread, y, x)
+			case Bytes:
+				s, err := stringRepeat(thread, String(y), x)
+				return Bytes(s), err
 			case *List:
-				elems, err := tupleRepeat(Tuple(y.elems), x)
+				elems, err := tupleRepeat(thread, Tuple(y.elems), x)
 				if err != nil {
 					return nil, err
 				}
 				return NewList(elems), nil
 			case Tuple:
-				return tupleRepeat(y, x)
+				return tupleRepeat(thread, y, x)
 			}
 		case Float:
 			switch y := y.(type) {
@@ -587,11 +965,16 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			}
 		case String:
 			if y, ok := y.(Int); ok {
-				return stringRepeat(x, y)
+				return stringRepeat(thread, x, y)
+			}
+		case Bytes:
+			if y, ok := y.(Int); ok {
+				s, err := stringRepeat(thread, String(x), y)
+				return Bytes(s), err
 			}
 		case *List:
 			if y, ok := y.(Int); ok {
-				elems, err := tupleRepeat(Tuple(x.elems), y)
+				elems, err := tupleRepeat(thread, Tuple(x.elems), y)
 				if err != nil {
 					return nil, err
 				}
@@ -599,7 +982,7 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			}
 		case Tuple:
 			if y, ok := y.(Int); ok {
-				return tupleRepeat(x, y)
+				return tupleRepeat(thread, x, y)
 			}
 
 		}
@@ -696,11 +1079,11 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 				return x.Mod(y.Float()), nil
 			}
 		case String:
-			return interpolate(string(x), y)
+			return interpolate(thread, string(x), y)
 		}
 
 	case syntax.NOT_IN:
-		z, err := Binary(syntax.IN, x, y)
+		z, err := binary(thread, syntax.IN, x, y)
 		if err != nil {
 			return nil, err
 		}
@@ -740,6 +1123,19 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 				return nil, fmt.Errorf("'in <string>' requires string as left operand, not %s", x.Type())
 			}
 			return Bool(strings.Contains(string(y), string(needle))), nil
+		case Bytes:
+			switch needle := x.(type) {
+			case Bytes:
+				return Bool(strings.Contains(string(y), string(needle))), nil
+			case Int:
+				b, err := AsInt32(needle)
+				if err != nil || b < 0 || b > 255 {
+					return nil, fmt.Errorf("int in bytes: %s out of range", needle)
+				}
+				return Bool(strings.IndexByte(string(y), byte(b)) >= 0), nil
+			default:
+				return nil, fmt.Errorf("'in bytes' requires bytes or int as left operand, not %s", x.Type())
+			}
 		case rangeValue:
 			i, err := NumberToInt(x)
 			if err != nil {
@@ -758,7 +1154,7 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			if y, ok := y.(*Set); ok {
 				iter := Iterate(y)
 				defer iter.Done()
-				return x.Union(iter)
+				return x.union(thread, iter)
 			}
 		}
 
@@ -770,17 +1166,7 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			}
 		case *Set: // intersection
 			if y, ok := y.(*Set); ok {
-				set := new(Set)
-				if x.Len() > y.Len() {
-					x, y = y, x // opt: range over smaller set
-				}
-				for _, xelem := range x.elems() {
-					// Has, Insert cannot fail here.
-					if found, _ := y.Has(xelem); found {
-						set.Insert(xelem)
-					}
-				}
-				return set, nil
+				return x.intersection(thread, y)
 			}
 		}
 
@@ -792,18 +1178,7 @@ func Binary(op syntax.Token, x, y Value) (Value, error) {
 			}
 		case *Set: // symmetric difference
 			if y, ok := y.(*Set); ok {
-				set := new(Set)
-				for _, xelem := range x.elems() {
-					if found, _ := y.Has(xelem); !found {
-						set.Insert(xelem)
-					}
-				}
-				for _, yelem := range y.elems() {
-					if found, _ := x.Has(yelem); !found {
-						set.Insert(yelem)
-					}
-				}
-				return set, nil
+				return x.symmetricDifference(thread, y)
 			}
 		}
 
@@ -854,7 +1229,7 @@ unknown:
 // try to stop someone swallowing the world in one gulp.
 const maxAlloc = 1 << 30
 
-func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
+func tupleRepeat(thread *Thread, elems Tuple, n Int) (Tuple, error) {
 	if len(elems) == 0 {
 		return nil, nil
 	}
@@ -870,6 +1245,9 @@ func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
 	if sz < 0 || sz >= maxAlloc { // sz < 0 => overflow
 		return nil, fmt.Errorf("excessive repeat (%d elements)", sz)
 	}
+	if err := thread.addAllocs(tupleSize + int64(sz)*valueSize); err != nil {
+		return nil, err
+	}
 	res := make([]Value, sz)
 	// copy elems into res, doubling each time
 	x := copy(res, elems)
@@ -880,7 +1258,7 @@ func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
 	return res, nil
 }
 
-func stringRepeat(s String, n Int) (String, error) {
+func stringRepeat(thread *Thread, s String, n Int) (String, error) {
 	if s == "" {
 		return "", nil
 	}
@@ -896,6 +1274,9 @@ func stringRepeat(s String, n Int) (String, error) {
 	if sz < 0 || sz >= maxAlloc { // sz < 0 => overflow
 		return "", fmt.Errorf("excessive repeat (%d elements)", sz)
 	}
+	if err := thread.addAllocs(stringSize + int64(sz)); err != nil {
+		return "", err
+	}
 	return String(strings.Repeat(string(s), i)), nil
 }
 
@@ -906,8 +1287,16 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 		return nil, fmt.Errorf("invalid call of non-function (%s)", fn.Type())
 	}
 
+	if thread.frame == nil {
+		// Time spent outside Starlark is not profiled.
+		thread.profTick = atomic.LoadUint32(&profTicks)
+	}
+
 	thread.frame = &Frame{parent: thread.frame, callable: c}
 	result, err := c.CallInternal(thread, args, kwargs)
+	if _, ok := c.(*Function); !ok {
+		thread.profileSample() // attribute time to the built-in
+	}
 	thread.frame = thread.frame.parent
 
 	// Sanity check: nil is not a valid Starlark value.
@@ -918,7 +1307,7 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 	return result, err
 }
 
-func slice(x, lo, hi, step_ Value) (Value, error) {
+func slice(thread *Thread, x, lo, hi, step_ Value) (Value, error) {
 	sliceable, ok := x.(Sliceable)
 	if !ok {
 		return nil, fmt.Errorf("invalid slice operand %s", x.Type())
@@ -978,6 +1367,28 @@ func slice(x, lo, hi, step_ Value) (Value, error) {
 		}
 	}
 
+	// Charge the new elements. A contiguous slice
+	// of a string, bytes, or tuple shares its elements.
+	count := int64((end - start + step - signum(step)) / step)
+	var size int64
+	switch sliceable.(type) {
+	case String, Bytes:
+		size = stringSize
+		if step != 1 {
+			size += count
+		}
+	case Tuple:
+		size = tupleSize
+		if step != 1 {
+			size += count * valueSize
+		}
+	case *List:
+		size = listSize + count*valueSize
+	}
+	if err := thread.addAllocs(size); err != nil {
+		return nil, err
+	}
+
 	return sliceable.Slice(start, end, step), nil
 }
 
@@ -1199,7 +1610,11 @@ func (is *intset) len() int {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string-interpolation
-func interpolate(format string, x Value) (Value, error) {
+func interpolate(thread *Thread, format string, x Value) (Value, error) {
+	// The literal portions of the result are no longer than format.
+	if err := thread.addAllocs(stringSize + int64(len(format))); err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	path := make([]Value, 0, 4)
 	index := 0
@@ -1259,9 +1674,17 @@ func interpolate(format string, x Value) (Value, error) {
 		if format == "" {
 			return nil, fmt.Errorf("incomplete format")
 		}
+		// A string operand is charged before it is copied. The length
+		// of any other conversion is not known until it is written,
+		// so its charge follows.
+		start := buf.Len()
 		switch c := format[0]; c {
 		case 's', 'r':
 			if str, ok := AsString(arg); ok && c == 's' {
+				if err := thread.addAllocs(int64(len(str))); err != nil {
+					return nil, err
+				}
+				start += len(str)
 				buf.WriteString(str)
 			} else {
 				writeValue(&buf, arg, path)
@@ -1323,6 +1746,9 @@ func interpolate(format string, x Value) (Value, error) {
 		default:
 			return nil, fmt.Errorf("unknown conversion %%%c", c)
 		}
+		if err := thread.addAllocs(int64(buf.Len() - start)); err != nil {
+			return nil, err
+		}
 		format = format[1:]
 		index++
 	}
@@ -6,11 +6,14 @@ package starlark_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"math"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"go.starlark.net/internal/chunkedfile"
 	"go.starlark.net/resolve"
@@ -99,6 +102,7 @@ func TestExecFile(t *testing.T) {
 	for _, file := range []string{
 		"testdata/assign.star",
 		"testdata/bool.star",
+		"testdata/bytes.star",
 		"testdata/builtins.star",
 		"testdata/control.star",
 		"testdata/dict.star",
@@ -111,6 +115,7 @@ func TestExecFile(t *testing.T) {
 		"testdata/string.star",
 		"testdata/tuple.star",
 		"testdata/recursion.star",
+		"testdata/try.star",
 	} {
 		filename := filepath.Join(testdata, file)
 		for _, chunk := range chunkedfile.Read(filename, t) {
@@ -119,9 +124,11 @@ func TestExecFile(t *testing.T) {
 				"fibonacci": fib{},
 			}
 
-			resolve.AllowRecursion = option(chunk.Source, "recursion")
+			opts := resolve.LegacyOptions()
+			opts.Recursion = option(chunk.Source, "recursion")
+			opts.Try = option(chunk.Source, "try")
 
-			_, err := starlark.ExecFile(thread, filename, chunk.Source, predeclared)
+			_, err := starlark.ExecFileOptions(opts, thread, filename, chunk.Source, predeclared)
 			switch err := err.(type) {
 			case *starlark.EvalError:
 				found := false
@@ -141,7 +148,6 @@ func TestExecFile(t *testing.T) {
 			default:
 				t.Error(err)
 			}
-			resolve.AllowRecursion = false
 			chunk.Done()
 		}
 	}
@@ -340,8 +346,8 @@ f()
 	if _, err := starlark.ExecFile(thread, "foo.star", src, nil); err != nil {
 		t.Fatal(err)
 	}
-	want := "foo.star:2: <toplevel>: hello\n" +
-		"foo.star:3: f: hello, world\n"
+	want := "foo.star:2:6: <toplevel>: hello\n" +
+		"foo.star:3:15: f: hello, world\n"
 	if got := buf.String(); got != want {
 		t.Errorf("output was %s, want %s", got, want)
 	}
@@ -405,14 +411,13 @@ i()
 	switch err := err.(type) {
 	case *starlark.EvalError:
 		got := err.Backtrace()
-		// Compiled code currently has no column information.
 		const want = `Traceback (most recent call last):
-  crash.star:6: in <toplevel>
-  crash.star:5: in i
-  crash.star:4: in h
+  crash.star:6:2: in <toplevel>
+  crash.star:5:18: in i
+  crash.star:4:20: in h
   <builtin>:1: in min
-  crash.star:3: in g
-  crash.star:2: in f
+  crash.star:3:12: in g
+  crash.star:2:19: in f
 Error: floored division by zero`
 		if got != want {
 			t.Errorf("error was %s, want %s", got, want)
@@ -424,6 +429,101 @@ Error: floored division by zero`
 	}
 }
 
+// TestEvalErrorCause checks that an EvalError records the error of a
+// failing built-in and a snapshot of the stack that can be rendered.
+func TestEvalErrorCause(t *testing.T) {
+	cause := fmt.Errorf("disk full")
+	fail := starlark.NewBuiltin("fail", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return nil, cause
+	})
+	thread := new(starlark.Thread)
+	_, err := starlark.ExecFile(thread, "cause.star", `
+def f(): fail()
+def g(): f()
+g()
+`, starlark.StringDict{"fail": fail})
+	evalErr, ok := err.(*starlark.EvalError)
+	if !ok {
+		t.Fatalf("ExecFile returned %v, want *EvalError", err)
+	}
+	if evalErr.Unwrap() != cause {
+		t.Errorf("Unwrap() = %v, want %v", evalErr.Unwrap(), cause)
+	}
+
+	// The snapshot is unaffected by further execution of the thread.
+	if _, err := starlark.ExecFile(thread, "other.star", "def h(): pass\nh()\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := evalErr.CallStack.String(), `Traceback (most recent call last):
+  cause.star:4:2: in <toplevel>
+  cause.star:3:11: in g
+  cause.star:2:14: in f
+`; got != want {
+		t.Errorf("CallStack.String() = %q, want %q", got, want)
+	}
+	data, err := json.Marshal(evalErr.CallStack[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), `[{"name":"f","file":"cause.star","line":2,"col":14}]`; got != want {
+		t.Errorf("JSON encoding = %s, want %s", got, want)
+	}
+}
+
+// TestParallelLoad checks that the loads of a program are performed
+// concurrently, and that each reports its error at its own position.
+func TestParallelLoad(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	var started sync.WaitGroup
+	started.Add(3) // a.star, b.star, bad.star
+	thread := &starlark.Thread{
+		ParallelLoad: true,
+		Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+			mu.Lock()
+			calls[module]++
+			mu.Unlock()
+
+			// Each load waits for the others to start.
+			started.Done()
+			done := make(chan struct{})
+			go func() { started.Wait(); close(done) }()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				return nil, fmt.Errorf("loads are not concurrent")
+			}
+
+			if module == "bad.star" {
+				return nil, fmt.Errorf("no such file")
+			}
+			return starlark.StringDict{"x": starlark.String(module)}, nil
+		},
+	}
+	globals, err := starlark.ExecFile(thread, "parallel.star", `
+load("a.star", a="x")
+load("b.star", b="x")
+load("a.star", a2="x")
+load("bad.star", "x")
+`, nil)
+	evalErr, ok := err.(*starlark.EvalError)
+	if !ok {
+		t.Fatalf("ExecFile returned %v, want *EvalError", err)
+	}
+	if got, want := evalErr.Msg, "cannot load bad.star: no such file"; got != want {
+		t.Errorf("error message = %q, want %q", got, want)
+	}
+	if got, want := evalErr.CallStack[0].Pos.Line, int32(5); got != want {
+		t.Errorf("error reported at line %d, want %d", got, want)
+	}
+	if got, want := fmt.Sprint(globals["a"], globals["b"], globals["a2"]), `"a.star""b.star""a.star"`; got != want {
+		t.Errorf("globals = %s, want %s", got, want)
+	}
+	if calls["a.star"] != 1 || calls["b.star"] != 1 || calls["bad.star"] != 1 {
+		t.Errorf("calls to Load = %v, want one per module", calls)
+	}
+}
+
 // TestRepeatedExec parses and resolves a file syntax tree once then
 // executes it repeatedly with different values of its predeclared variables.
 func TestRepeatedExec(t *testing.T) {
@@ -467,6 +567,33 @@ func TestEmptyPosition(t *testing.T) {
 	}
 }
 
+// TestErrorList ensures that all syntax and resolver errors
+// in a file are reported.
+func TestErrorList(t *testing.T) {
+	thread := new(starlark.Thread)
+
+	_, err := starlark.ExecFile(thread, "a.star", "x = 1 +\ny = 2 3\n", nil)
+	if list, ok := err.(syntax.ErrorList); !ok || len(list) != 2 {
+		t.Errorf("ExecFile returned %#v, want syntax.ErrorList of 2 errors", err)
+	} else if got, want := err.Error(), "a.star:2:1: got newline, want primary expression (and 1 more errors)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	_, err = starlark.ExecFile(thread, "b.star", "def f():\n    return u\nx = v\nx = 2\n", nil)
+	list, ok := err.(resolve.ErrorList)
+	if !ok {
+		t.Fatalf("ExecFile returned %#v, want resolve.ErrorList", err)
+	}
+	var got []string
+	for _, err := range list {
+		got = append(got, err.Error())
+	}
+	want := "b.star:2:12: undefined: u; b.star:3:5: undefined: v; b.star:4:1: cannot reassign global x declared at b.star:3:1"
+	if strings.Join(got, "; ") != want {
+		t.Errorf("ExecFile returned errors %q, want %q", strings.Join(got, "; "), want)
+	}
+}
+
 // TestUnpackUserDefined tests that user-defined
 // implementations of starlark.Value may be unpacked.
 func TestUnpackUserDefined(t *testing.T) {
@@ -549,3 +676,290 @@ g(z=7)
 		t.Errorf("got <<%s>>, want <<%s>>", got, want)
 	}
 }
+
+func TestDebugHook(t *testing.T) {
+	// The hook records each line, and the locals and
+	// the value of an expression in the frame.
+	var got []string
+	thread := new(starlark.Thread)
+	thread.SetDebugHook(func(thread *starlark.Thread, fr *starlark.Frame) {
+		locals := fr.Locals()
+		var names []string
+		for _, name := range locals.Keys() {
+			names = append(names, fmt.Sprintf("%s=%s", name, locals[name]))
+		}
+		v, err := fr.Eval(new(starlark.Thread), "k * 10")
+		if err != nil {
+			v = starlark.String("?")
+		}
+		got = append(got, fmt.Sprintf("%s:%d [%s] %s",
+			fr.Callable().Name(), fr.Position().Line, strings.Join(names, " "), v))
+	})
+	_, err := starlark.ExecFile(thread, "hook.star", `
+k = 1
+def f(x):
+    y = x + 1
+    return y
+z = f(2)
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`<toplevel>:2 [] "?"`,
+		"<toplevel>:3 [] 10",
+		"<toplevel>:6 [] 10",
+		"f:4 [x=2] 10",
+		"f:5 [x=2 y=3] 10",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("debug hook calls:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A cancelled thread fails when the hook returns.
+	thread = new(starlark.Thread)
+	thread.SetDebugHook(func(thread *starlark.Thread, fr *starlark.Frame) {
+		if fr.Position().Line == 3 {
+			thread.Cancel("stopped by debugger")
+		}
+	})
+	_, err = starlark.ExecFile(thread, "hook.star", "x = 1\ny = 2\nz = 3\n", nil)
+	if err == nil || !strings.Contains(err.Error(), "stopped by debugger") {
+		t.Errorf("ExecFile returned error %v, want cancellation", err)
+	}
+	if pos := err.(*starlark.EvalError).Frame.Position(); pos.Line != 3 {
+		t.Errorf("cancellation reported at %s, want line 3", pos)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	// Two threads record coverage of the same program.
+	const src = `
+def f(x):
+    if x:
+        return 1
+    return 2
+
+def unused():
+    pass
+
+y = f(True)
+`
+	cov := starlark.NewCoverage()
+	_, prog, err := starlark.SourceProgram("cov.star", src, starlark.StringDict(nil).Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		thread := new(starlark.Thread)
+		thread.SetCoverage(cov)
+		if _, err := prog.Init(thread, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := cov.WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `TN:
+SF:cov.star
+DA:2,2
+DA:3,2
+DA:4,2
+DA:5,0
+DA:7,2
+DA:8,0
+DA:10,2
+LF:7
+LH:5
+end_of_record
+`; got != want {
+		t.Errorf("LCOV report:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	if err := cov.WriteGoCover(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "mode: count\ncov.star:2.1,3.1 1 2\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("Go cover report begins %q, want %q", got, want)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// A thread cancelled before it begins executes no code.
+	{
+		thread := new(starlark.Thread)
+		thread.Cancel("nope")
+		_, err := starlark.ExecFile(thread, "precancel.star", `x = 1//0`, nil)
+		if fmt.Sprint(err) != "Starlark computation cancelled: nope" {
+			t.Errorf("execution returned error %q, want cancellation", err)
+		}
+
+		// cancellation is sticky
+		_, err = starlark.ExecFile(thread, "precancel.star", `x = 1//0`, nil)
+		if fmt.Sprint(err) != "Starlark computation cancelled: nope" {
+			t.Errorf("execution returned error %q, want cancellation", err)
+		}
+	}
+	// A thread cancelled during a built-in executes no more code.
+	{
+		thread := new(starlark.Thread)
+		predeclared := starlark.StringDict{
+			"stopit": starlark.NewBuiltin("stopit", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				thread.Cancel(fmt.Sprint(args[0]))
+				thread.Cancel("ignored") // only the first reason is recorded
+				return starlark.None, nil
+			}),
+		}
+		_, err := starlark.ExecFile(thread, "stopit.star", `msg = 'nope'; stopit(msg); x = 1//0`, predeclared)
+		if fmt.Sprint(err) != `Starlark computation cancelled: "nope"` {
+			t.Errorf("execution returned error %q, want cancellation", err)
+		}
+	}
+	// A thread executing an infinite loop may be cancelled from another goroutine.
+	{
+		thread := new(starlark.Thread)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			thread.Cancel("timeout")
+		}()
+		opts := &resolve.Options{Recursion: true}
+		_, err := starlark.ExecFileOptions(opts, thread, "loop.star", `
+def f():
+    while True:
+        pass
+f()
+`, nil)
+		evalErr, ok := err.(*starlark.EvalError)
+		if !ok {
+			t.Fatalf("execution returned error %v, want *EvalError", err)
+		}
+		if got, want := evalErr.Msg, "Starlark computation cancelled: timeout"; got != want {
+			t.Errorf("got error %q, want %q", got, want)
+		}
+		if got := evalErr.Backtrace(); !strings.Contains(got, "loop.star:5:2: in <toplevel>\n  loop.star:") ||
+			!strings.HasSuffix(got, "in f\nError: Starlark computation cancelled: timeout") {
+			t.Errorf("got backtrace <<%s>>, want frames for <toplevel> and f", got)
+		}
+	}
+}
+
+func TestExecutionSteps(t *testing.T) {
+	// A Thread records the number of computation steps.
+	thread := new(starlark.Thread)
+	countSteps := func(n int) (uint64, error) {
+		predeclared := starlark.StringDict{"n": starlark.MakeInt(n)}
+		steps0 := thread.ExecutionSteps()
+		_, err := starlark.ExecFile(thread, "steps.star", `squares = [x*x for x in range(n)]`, predeclared)
+		return thread.ExecutionSteps() - steps0, err
+	}
+	steps100, err := countSteps(100)
+	if err != nil {
+		t.Errorf("execution failed: %v", err)
+	}
+	steps10000, err := countSteps(10000)
+	if err != nil {
+		t.Errorf("execution failed: %v", err)
+	}
+	if ratio := float64(steps10000) / float64(steps100); ratio < 99 || ratio > 101 {
+		t.Errorf("computation steps did not increase linearly: f(100)=%d, f(10000)=%d, ratio=%g, want ~100",
+			steps100, steps10000, ratio)
+	}
+
+	// The count is deterministic.
+	if again, _ := countSteps(100); again != steps100 {
+		t.Errorf("f(100) took %d steps, then %d steps", steps100, again)
+	}
+
+	// Exceeding the step limit causes cancellation.
+	thread = new(starlark.Thread)
+	thread.SetMaxExecutionSteps(1000)
+	_, err = countSteps(1000)
+	if fmt.Sprint(err) != "Starlark computation cancelled: too many steps" {
+		t.Errorf("execution returned error %q, want cancellation", err)
+	}
+	if got := thread.ExecutionSteps(); got != 1001 {
+		t.Errorf("ExecutionSteps() = %d after cancellation, want 1001", got)
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	// A Thread records an estimate of the memory it allocates.
+	thread := new(starlark.Thread)
+	alloc := func(src string) (uint64, error) {
+		allocs0 := thread.Allocs()
+		_, err := starlark.ExecFile(thread, "allocs.star", src, nil)
+		return thread.Allocs() - allocs0, err
+	}
+	for _, src := range []string{
+		`x = "x" * 100000`,
+		`x = [None] * 100000`,
+		`x = list(range(100000))`,
+		`x = {i: i for i in range(10000)}`,
+		`x = ",".join(["abc"] * 10000)`,
+		`x = 1 << 500
+y = [x * x for _ in range(100)]`,
+	} {
+		n, err := alloc(src)
+		if err != nil {
+			t.Errorf("%s: execution failed: %v", src, err)
+		} else if n < 10000 {
+			t.Errorf("%s: allocated only %d bytes, want more", src, n)
+		}
+	}
+
+	// Exceeding the allocation limit causes cancellation
+	// before the memory is allocated.
+	for _, src := range []string{
+		`x = "x" * (1 << 24)`,
+		`x = []
+[x.append(i) for i in range(1 << 24)]`,
+		`x = enumerate(range(1 << 30))`,
+		`x = zip(range(1 << 30))`,
+		`def f(s):
+  for _ in range(40):
+    s = repr(s) # doubles the backslashes
+f("\\")`,
+		`def f(s):
+  for _ in range(40):
+    s = str((s,))
+f("\\")`,
+		`def f(s):
+  for _ in range(40):
+    s = "{}{}".format(s, s)
+f("x")`,
+		`def f(s):
+  for _ in range(40):
+    s = "%s%s" % (s, s)
+f("x")`,
+		`d = {i: i for i in range(1000)}
+x = [d.items() for _ in range(1000)]`,
+		`d = {i: i for i in range(1000)}
+x = [d.keys() for _ in range(1000)]`,
+		`d = {i: i for i in range(1000)}
+x = [d.values() for _ in range(1000)]`,
+		`s = "a " * 10000
+x = [s.split() for _ in range(1000)]`,
+		`s = "a," * 10000
+x = [s.rsplit(",") for _ in range(1000)]`,
+		`s = "a\n" * 10000
+x = [s.splitlines() for _ in range(1000)]`,
+		`s = "a" * 10000
+x = [s.lower() for _ in range(1000)]`,
+		`s = "a" * 10000
+x = [s.upper() for _ in range(1000)]`,
+		`s = "a" * 10000
+x = [s[::-1] for _ in range(1000)]`,
+		`l = [None] * 10000
+x = [l[:] for _ in range(1000)]`,
+	} {
+		thread = new(starlark.Thread)
+		thread.SetMaxAllocs(1 << 20)
+		_, err := alloc(src)
+		if fmt.Sprint(err) != "Starlark computation cancelled: exceeded memory allocation limit" {
+			t.Errorf("%s: execution returned error %q, want cancellation", src, err)
+		}
+	}
+}
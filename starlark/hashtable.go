@@ -6,7 +6,7 @@ package starlark
 
 import (
 	"fmt"
-	_ "unsafe" // for go:linkname hack
+	"unsafe" // also for go:linkname hack
 )
 
 // hashtable is used to represent Starlark dict and set values.
@@ -29,6 +29,9 @@ type bucket struct {
 	next    *bucket // linked list of buckets
 }
 
+// bucketAllocs is the size of a bucket, for memory accounting.
+const bucketAllocs = int64(unsafe.Sizeof(bucket{}))
+
 type entry struct {
 	hash       uint32 // nonzero => in use
 	key, value Value
@@ -53,7 +56,9 @@ func (ht *hashtable) freeze() {
 	}
 }
 
-func (ht *hashtable) insert(k, v Value) error {
+// insert adds or updates the entry for key k.
+// Memory allocated as the table grows is charged to thread, if non-nil.
+func (ht *hashtable) insert(thread *Thread, k, v Value) error {
 	if ht.frozen {
 		return fmt.Errorf("cannot insert into frozen hash table")
 	}
@@ -106,12 +111,18 @@ retry:
 
 	// Does the number of elements exceed the buckets' load factor?
 	if overloaded(int(ht.len), len(ht.table)) {
+		if err := thread.addAllocs(int64(len(ht.table)<<1) * bucketAllocs); err != nil {
+			return err
+		}
 		ht.grow()
 		goto retry
 	}
 
 	if insert == nil {
 		// No space in existing buckets.  Add a new one to the bucket list.
+		if err := thread.addAllocs(bucketAllocs); err != nil {
+			return err
+		}
 		b := new(bucket)
 		p.next = b
 		insert = &b.entries[0]
@@ -152,7 +163,7 @@ func (ht *hashtable) grow() {
 	ht.tailLink = &ht.head
 	ht.len = 0
 	for e := oldhead; e != nil; e = e.next {
-		ht.insert(e.key, e.value)
+		ht.insert(nil, e.key, e.value) // already accounted for by caller
 	}
 	ht.bucket0[0] = bucket{} // clear out unused initial bucket
 }
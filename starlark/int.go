@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"unsafe"
 
 	"go.starlark.net/syntax"
 )
@@ -58,6 +59,10 @@ func MakeUint64(x uint64) Int {
 	return Int{new(big.Int).SetUint64(x)}
 }
 
+// MakeBigInt returns a Starlark int for the specified big.Int.
+// The caller must not subsequently modify x.
+func MakeBigInt(x *big.Int) Int { return Int{x} }
+
 var (
 	smallint   [256]big.Int
 	smallintok bool
@@ -94,6 +99,10 @@ func (i Int) Uint64() (_ uint64, ok bool) {
 	return x, true
 }
 
+// BigInt returns the value as a big.Int.
+// The returned variable must not be modified by the client.
+func (i Int) BigInt() *big.Int { return i.bigint }
+
 // The math/big API should provide this function.
 func bigintToInt64(i *big.Int) (int64, big.Accuracy) {
 	sign := i.Sign()
@@ -149,6 +158,10 @@ func (i Int) Float() Float {
 	return Float(f)
 }
 
+// intAllocs returns the approximate number of bytes
+// allocated for an Int of the specified bit length.
+func intAllocs(bitlen int) int64 { return int64(unsafe.Sizeof(big.Int{})) + int64(bitlen+7)/8 }
+
 func (x Int) Sign() int      { return x.bigint.Sign() }
 func (x Int) Add(y Int) Int  { return Int{new(big.Int).Add(x.bigint, y.bigint)} }
 func (x Int) Sub(y Int) Int  { return Int{new(big.Int).Sub(x.bigint, y.bigint)} }
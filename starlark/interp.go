@@ -5,9 +5,10 @@ package starlark
 import (
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 
 	"go.starlark.net/internal/compile"
-	"go.starlark.net/resolve"
 	"go.starlark.net/syntax"
 )
 
@@ -24,7 +25,7 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Va
 		fmt.Printf("call of %s %v %v\n", fn.Name(), args, kwargs)
 	}
 
-	if !resolve.AllowRecursion {
+	if !fn.funcode.Prog.Options.Recursion {
 		// detect recursion
 		for fr := thread.frame.parent; fr != nil; fr = fr.parent {
 			// We look for the same function code,
@@ -44,17 +45,31 @@ func call(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	fn := fr.callable.(*Function)
 	f := fn.funcode
 	nlocals := len(f.Locals)
+	if err := thread.AddAllocs(int64(nlocals+f.MaxStack) * valueSize); err != nil {
+		return nil, fr.evalError(fr.Position(), err)
+	}
 	stack := make([]Value, nlocals+f.MaxStack)
 	locals := stack[:nlocals:nlocals] // local variables, starting with parameters
 	stack = stack[nlocals:]
 
 	err := setArgs(locals, fn, args, kwargs)
 	if err != nil {
-		return nil, fr.errorf(fr.Position(), "%v", err)
+		return nil, fr.evalError(fr.Position(), err)
+	}
+
+	// Check for cancellation on entry to each function.
+	if err = thread.cancelled(); err != nil {
+		return nil, fr.evalError(fr.Position(), err)
 	}
 
 	fr.locals = locals // for debugger
 
+	// Prefetch the modules loaded by the toplevel of a program.
+	var loaded map[string]*loadResult
+	if thread.ParallelLoad && thread.Load != nil && f == f.Prog.Toplevel {
+		loaded = thread.loadAll(f.Prog.Loads)
+	}
+
 	if vmdebug {
 		fmt.Printf("Entering %s @ %s\n", f.Name, f.Position(0))
 		fmt.Printf("%d stack, %d locals\n", len(stack), len(locals))
@@ -66,15 +81,45 @@ func call(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
 	// - there is no redefinition of 'err'.
 
 	var iterstack []Iterator // stack of active iterators
+	var handlers []handler   // stack of active exception handlers
 
 	sp := 0
 	var pc, savedpc uint32
 	var result Value
+	var line int32 // current line, for debug hook and coverage
 	code := f.Code
 loop:
 	for {
+		thread.steps++
+		if thread.steps > thread.maxSteps && thread.maxSteps != 0 {
+			thread.Cancel("too many steps")
+			err = thread.cancelled()
+			break loop
+		}
+
 		savedpc = pc
 
+		if thread.profTick != atomic.LoadUint32(&profTicks) {
+			fr.callpc = savedpc // for fr.Position
+			thread.profileSample()
+		}
+
+		if thread.debugHook != nil || thread.coverage != nil {
+			if l := f.LineAt(savedpc); l != line {
+				line = l
+				if thread.coverage != nil {
+					thread.coverage.hit(f, line)
+				}
+				if thread.debugHook != nil {
+					fr.callpc = savedpc // for fr.Position
+					thread.debugHook(thread, fr)
+					if err = thread.cancelled(); err != nil {
+						break loop
+					}
+				}
+			}
+		}
+
 		op := compile.Opcode(code[pc])
 		pc++
 		var arg uint32
@@ -146,7 +191,7 @@ loop:
 			y := stack[sp-1]
 			x := stack[sp-2]
 			sp -= 2
-			z, err2 := Binary(binop, x, y)
+			z, err2 := binary(thread, binop, x, y)
 			if err2 != nil {
 				err = err2
 				break loop
@@ -183,12 +228,14 @@ loop:
 					if err = xlist.checkMutable("apply += to"); err != nil {
 						break loop
 					}
-					listExtend(xlist, yiter)
+					if err = listExtend(thread, xlist, yiter); err != nil {
+						break loop
+					}
 					z = xlist
 				}
 			}
 			if z == nil {
-				z, err = Binary(syntax.PLUS, x, y)
+				z, err = binary(thread, syntax.PLUS, x, y)
 				if err != nil {
 					break loop
 				}
@@ -210,6 +257,12 @@ loop:
 			sp++
 
 		case compile.JMP:
+			if arg <= savedpc {
+				// Backward jump (loop): check for cancellation.
+				if err = thread.cancelled(); err != nil {
+					break loop
+				}
+			}
 			pc = arg
 
 		case compile.CALL, compile.CALL_VAR, compile.CALL_KW, compile.CALL_VAR_KW:
@@ -299,6 +352,11 @@ loop:
 			}
 			stack[sp-1] = z
 
+			// The callee may have been a long-running built-in.
+			if err = thread.cancelled(); err != nil {
+				break loop
+			}
+
 		case compile.ITERPUSH:
 			x := stack[sp-1]
 			sp--
@@ -314,9 +372,20 @@ loop:
 			if iter.Next(&stack[sp]) {
 				sp++
 			} else {
+				if arg <= savedpc {
+					if err = thread.cancelled(); err != nil {
+						break loop
+					}
+				}
 				pc = arg
 			}
 
+		case compile.SETUPEXCEPT:
+			handlers = append(handlers, handler{addr: arg, sp: sp, iters: len(iterstack)})
+
+		case compile.POPEXCEPT:
+			handlers = handlers[:len(handlers)-1]
+
 		case compile.ITERPOP:
 			n := len(iterstack) - 1
 			iterstack[n].Done()
@@ -372,6 +441,9 @@ loop:
 			}
 
 		case compile.MAKEDICT:
+			if err = thread.AddAllocs(dictSize); err != nil {
+				break loop
+			}
 			stack[sp] = new(Dict)
 			sp++
 
@@ -381,7 +453,7 @@ loop:
 			v := stack[sp-1]
 			sp -= 3
 			oldlen := dict.Len()
-			if err2 := dict.SetKey(k, v); err2 != nil {
+			if err2 := dict.ht.insert(thread, k, v); err2 != nil {
 				err = err2
 				break loop
 			}
@@ -394,6 +466,9 @@ loop:
 			elem := stack[sp-1]
 			list := stack[sp-2].(*List)
 			sp -= 2
+			if err = thread.AddAllocs(valueSize); err != nil {
+				break loop
+			}
 			list.elems = append(list.elems, elem)
 
 		case compile.SLICE:
@@ -402,7 +477,7 @@ loop:
 			hi := stack[sp-2]
 			step := stack[sp-1]
 			sp -= 4
-			res, err2 := slice(x, lo, hi, step)
+			res, err2 := slice(thread, x, lo, hi, step)
 			if err2 != nil {
 				err = err2
 				break loop
@@ -438,6 +513,11 @@ loop:
 
 		case compile.CJMP:
 			if stack[sp-1].Truth() {
+				if arg <= savedpc {
+					if err = thread.cancelled(); err != nil {
+						break loop
+					}
+				}
 				pc = arg
 			}
 			sp--
@@ -448,6 +528,9 @@ loop:
 
 		case compile.MAKETUPLE:
 			n := int(arg)
+			if err = thread.AddAllocs(tupleSize + int64(n)*valueSize); err != nil {
+				break loop
+			}
 			tuple := make(Tuple, n)
 			sp -= n
 			copy(tuple, stack[sp:])
@@ -456,6 +539,9 @@ loop:
 
 		case compile.MAKELIST:
 			n := int(arg)
+			if err = thread.AddAllocs(listSize + int64(n)*valueSize); err != nil {
+				break loop
+			}
 			elems := make([]Value, n)
 			sp -= n
 			copy(elems, stack[sp:])
@@ -487,7 +573,13 @@ loop:
 				break loop
 			}
 
-			dict, err2 := thread.Load(thread, module)
+			var dict StringDict
+			var err2 error
+			if r, ok := loaded[module]; ok {
+				dict, err2 = r.globals, r.err
+			} else {
+				dict, err2 = thread.Load(thread, module)
+			}
 			if err2 != nil {
 				err = fmt.Errorf("cannot load %s: %v", module, err2)
 				break loop
@@ -544,7 +636,13 @@ loop:
 			sp++
 
 		case compile.UNIVERSAL:
-			stack[sp] = Universe[f.Prog.Names[arg]]
+			name := f.Prog.Names[arg]
+			x := Universe[name]
+			if x == nil {
+				err = fmt.Errorf("internal error: universal variable %s is undefined", name)
+				break loop
+			}
+			stack[sp] = x
 			sp++
 
 		default:
@@ -553,18 +651,71 @@ loop:
 		}
 	}
 
-	// ITERPOP the rest of the iterator stack.
-	for _, iter := range iterstack {
-		iter.Done()
-	}
-
 	if err != nil {
 		if _, ok := err.(*EvalError); !ok {
-			err = fr.errorf(f.Position(savedpc), "%s", err.Error())
+			err = fr.evalError(f.Position(savedpc), err)
 		}
+
+		// Transfer control to the innermost active exception
+		// handler, if any. Cancellation cannot be caught.
+		if n := len(handlers); n > 0 && thread.cancelled() == nil {
+			h := handlers[n-1]
+			handlers = handlers[:n-1]
+			for _, iter := range iterstack[h.iters:] {
+				iter.Done()
+			}
+			iterstack = iterstack[:h.iters]
+			fr.posn = syntax.Position{} // execution of the frame continues
+			sp = h.sp
+			stack[sp] = errorValue{err.(*EvalError)}
+			sp++
+			pc = h.addr
+			err = nil
+			goto loop
+		}
+	}
+
+	// ITERPOP the rest of the iterator stack.
+	for _, iter := range iterstack {
+		iter.Done()
 	}
 
 	fr.locals = nil
 
 	return result, err
 }
+
+// A handler is an active exception handler of a try statement.
+type handler struct {
+	addr  uint32 // address of the handler code
+	sp    int    // operand stack depth at the try statement
+	iters int    // iterator stack depth at the try statement
+}
+
+// A loadResult is the outcome of a call to Thread.Load.
+type loadResult struct {
+	globals StringDict
+	err     error
+}
+
+// loadAll calls thread.Load concurrently for each distinct module
+// named by a load statement, and returns the results by module name.
+// The state of the thread does not change until all calls have returned.
+func (thread *Thread) loadAll(loads []compile.Ident) map[string]*loadResult {
+	results := make(map[string]*loadResult, len(loads))
+	var wg sync.WaitGroup
+	for _, load := range loads {
+		if results[load.Name] != nil {
+			continue // duplicate
+		}
+		r := new(loadResult)
+		results[load.Name] = r
+		wg.Add(1)
+		go func(module string) {
+			defer wg.Done()
+			r.globals, r.err = thread.Load(thread, module)
+		}(load.Name)
+	}
+	wg.Wait()
+	return results
+}
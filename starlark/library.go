@@ -42,11 +42,12 @@ func init() {
 		"any":       NewBuiltin("any", any),
 		"all":       NewBuiltin("all", all),
 		"bool":      NewBuiltin("bool", bool_),
+		"bytes":     NewBuiltin("bytes", bytes_),
 		"chr":       NewBuiltin("chr", chr),
 		"dict":      NewBuiltin("dict", dict),
 		"dir":       NewBuiltin("dir", dir),
 		"enumerate": NewBuiltin("enumerate", enumerate),
-		"float":     NewBuiltin("float", float), // requires resolve.AllowFloat
+		"float":     NewBuiltin("float", float), // requires resolve.Options.Float
 		"getattr":   NewBuiltin("getattr", getattr),
 		"hasattr":   NewBuiltin("hasattr", hasattr),
 		"hash":      NewBuiltin("hash", hash),
@@ -60,7 +61,7 @@ func init() {
 		"range":     NewBuiltin("range", range_),
 		"repr":      NewBuiltin("repr", repr),
 		"reversed":  NewBuiltin("reversed", reversed),
-		"set":       NewBuiltin("set", set), // requires resolve.AllowSet
+		"set":       NewBuiltin("set", set), // requires resolve.Options.Set
 		"sorted":    NewBuiltin("sorted", sorted),
 		"str":       NewBuiltin("str", str),
 		"tuple":     NewBuiltin("tuple", tuple),
@@ -69,11 +70,16 @@ func init() {
 	}
 }
 
-type builtinMethod func(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error)
+type builtinMethod func(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error)
 
 // methods of built-in types
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#built-in-methods
 var (
+	bytesMethods = map[string]builtinMethod{
+		"decode": bytes_decode,
+		"elems":  bytes_elems,
+	}
+
 	dictMethods = map[string]builtinMethod{
 		"clear":      dict_clear,
 		"get":        dict_get,
@@ -102,7 +108,8 @@ var (
 		"codepoints":     string_iterable, // sic
 		"count":          string_count,
 		"elem_ords":      string_iterable,
-		"elems":          string_iterable,   // sic
+		"elems":          string_iterable, // sic
+		"encode":         string_encode,
 		"endswith":       string_startswith, // sic
 		"find":           string_find,
 		"format":         string_format,
@@ -133,7 +140,19 @@ var (
 	}
 
 	setMethods = map[string]builtinMethod{
-		"union": set_union,
+		"add":                  set_add,
+		"clear":                set_clear,
+		"difference":           set_difference,
+		"discard":              set_discard,
+		"intersection":         set_intersection,
+		"isdisjoint":           set_isdisjoint,
+		"issubset":             set_issubset,
+		"issuperset":           set_issuperset,
+		"pop":                  set_pop,
+		"remove":               set_remove,
+		"symmetric_difference": set_symmetric_difference,
+		"union":                set_union,
+		"update":               set_update,
 	}
 )
 
@@ -145,7 +164,7 @@ func builtinAttr(recv Value, name string, methods map[string]builtinMethod) (Val
 
 	// Allocate a closure over 'method'.
 	impl := func(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-		return method(b.Name(), b.Receiver(), args, kwargs)
+		return method(thread, b.Name(), b.Receiver(), args, kwargs)
 	}
 	return NewBuiltin(name, impl).BindReceiver(recv), nil
 }
@@ -371,6 +390,48 @@ func bool_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error
 	return x.Truth(), nil
 }
 
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#bytes
+func bytes_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var x Value
+	if err := UnpackPositionalArgs("bytes", args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case Bytes:
+		return x, nil
+	case String:
+		if err := thread.addAllocs(stringSize + int64(len(x))); err != nil {
+			return nil, err
+		}
+		return Bytes(x), nil
+	case Iterable:
+		// iterable of numeric byte values
+		iter := x.Iterate()
+		defer iter.Done()
+		var buf []byte
+		if n := Len(x); n >= 0 {
+			buf = make([]byte, 0, n) // preallocate if length known
+		}
+		var elem Value
+		for i := 0; iter.Next(&elem); i++ {
+			b, err := AsInt32(elem)
+			if err != nil {
+				return nil, fmt.Errorf("bytes: at index %d, %s is not an int", i, elem.Type())
+			}
+			if b < 0 || b > 255 {
+				return nil, fmt.Errorf("bytes: at index %d, %d out of range (want value in unsigned 8-bit range)", i, b)
+			}
+			buf = append(buf, byte(b))
+		}
+		if err := thread.addAllocs(stringSize + int64(len(buf))); err != nil {
+			return nil, err
+		}
+		return Bytes(buf), nil
+	default:
+		return nil, fmt.Errorf("bytes: got %s, want string, bytes, or iterable of ints", x.Type())
+	}
+}
+
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#chr
 func chr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
@@ -389,7 +450,7 @@ func chr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if i > unicode.MaxRune {
 		return nil, fmt.Errorf("chr: Unicode code point U+%X out of range (>0x10FFFF)", i)
 	}
-	return String(string(i)), nil
+	return String(string(rune(i))), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict
@@ -398,7 +459,7 @@ func dict(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		return nil, fmt.Errorf("dict: got %d arguments, want at most 1", len(args))
 	}
 	dict := new(Dict)
-	if err := updateDict(dict, args, kwargs); err != nil {
+	if err := updateDict(thread, dict, args, kwargs); err != nil {
 		return nil, fmt.Errorf("dict: %v", err)
 	}
 	return dict, nil
@@ -417,6 +478,9 @@ func dir(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if x, ok := args[0].(HasAttrs); ok {
 		names = x.AttrNames()
 	}
+	if err := thread.addAllocs(listSize + int64(len(names))*(valueSize+stringSize)); err != nil {
+		return nil, err
+	}
 	elems := make([]Value, len(names))
 	for i, name := range names {
 		elems[i] = String(name)
@@ -443,6 +507,9 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 
 	if n := Len(iterable); n >= 0 {
 		// common case: known length
+		if err := thread.addAllocs(listSize + int64(n)*(tupleSize+3*valueSize)); err != nil {
+			return nil, err
+		}
 		pairs = make([]Value, 0, n)
 		array := make(Tuple, 2*n) // allocate a single backing array
 		for i := 0; iter.Next(&x); i++ {
@@ -455,6 +522,9 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 	} else {
 		// non-sequence (unknown length)
 		for i := 0; iter.Next(&x); i++ {
+			if err := thread.addAllocs(tupleSize + 3*valueSize); err != nil {
+				return nil, err
+			}
 			pair := Tuple{MakeInt(start + i), x}
 			pairs = append(pairs, pair)
 		}
@@ -687,6 +757,9 @@ func list(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		}
 		var x Value
 		for iter.Next(&x) {
+			if err := thread.addAllocs(valueSize); err != nil {
+				return nil, err
+			}
 			elems = append(elems, x)
 		}
 	}
@@ -953,7 +1026,13 @@ func repr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if err := UnpackPositionalArgs("repr", args, kwargs, 1, &x); err != nil {
 		return nil, err
 	}
-	return String(x.String()), nil
+	s := x.String()
+	// The length of s is not known until it is built,
+	// so the charge follows the allocation.
+	if err := thread.addAllocs(stringSize + int64(len(s))); err != nil {
+		return nil, err
+	}
+	return String(s), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#reversed
@@ -970,6 +1049,9 @@ func reversed(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, er
 	}
 	var x Value
 	for iter.Next(&x) {
+		if err := thread.addAllocs(valueSize); err != nil {
+			return nil, err
+		}
 		elems = append(elems, x)
 	}
 	n := len(elems)
@@ -991,7 +1073,7 @@ func set(thread *Thread, fn *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		defer iter.Done()
 		var x Value
 		for iter.Next(&x) {
-			if err := set.Insert(x); err != nil {
+			if err := set.ht.insert(thread, x, None); err != nil {
 				return nil, err
 			}
 		}
@@ -1020,6 +1102,9 @@ func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 	}
 	var x Value
 	for iter.Next(&x) {
+		if err := thread.addAllocs(valueSize); err != nil {
+			return nil, err
+		}
 		values = append(values, x)
 	}
 
@@ -1080,7 +1165,12 @@ func str(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	}
 	x := args[0]
 	if _, ok := AsString(x); !ok {
-		x = String(x.String())
+		s := x.String()
+		// As for repr, the charge follows the allocation.
+		if err := thread.addAllocs(stringSize + int64(len(s))); err != nil {
+			return nil, err
+		}
+		x = String(s)
 	}
 	return x, nil
 }
@@ -1102,6 +1192,9 @@ func tuple(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error
 	}
 	var x Value
 	for iter.Next(&x) {
+		if err := thread.addAllocs(valueSize); err != nil {
+			return nil, err
+		}
 		elems = append(elems, x)
 	}
 	return elems, nil
@@ -1146,6 +1239,9 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	var result []Value
 	if rows >= 0 {
 		// length known
+		if err := thread.addAllocs(listSize + int64(rows)*(valueSize+tupleSize+int64(cols)*valueSize)); err != nil {
+			return nil, err
+		}
 		result = make([]Value, rows)
 		array := make(Tuple, cols*rows) // allocate a single backing array
 		for i := 0; i < rows; i++ {
@@ -1160,6 +1256,9 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		// length not known
 	outer:
 		for {
+			if err := thread.addAllocs(valueSize + tupleSize + int64(cols)*valueSize); err != nil {
+				return nil, err
+			}
 			tuple := make(Tuple, cols)
 			for i, iter := range iters {
 				if !iter.Next(&tuple[i]) {
@@ -1175,7 +1274,7 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 // ---- methods of built-in types ---
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·get
-func dict_get(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_get(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var key, dflt Value
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &key, &dflt); err != nil {
 		return nil, err
@@ -1191,7 +1290,7 @@ func dict_get(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, err
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·clear
-func dict_clear(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_clear(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1199,11 +1298,15 @@ func dict_clear(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·items
-func dict_items(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_items(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	items := recv.(*Dict).Items()
+	dict := recv.(*Dict)
+	if err := thread.addAllocs(listSize + int64(dict.Len())*(tupleSize+3*valueSize)); err != nil {
+		return nil, err
+	}
+	items := dict.Items()
 	res := make([]Value, len(items))
 	for i, item := range items {
 		res[i] = item // convert [2]Value to Value
@@ -1212,15 +1315,19 @@ func dict_items(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·keys
-func dict_keys(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_keys(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return NewList(recv.(*Dict).Keys()), nil
+	dict := recv.(*Dict)
+	if err := thread.addAllocs(listSize + int64(dict.Len())*valueSize); err != nil {
+		return nil, err
+	}
+	return NewList(dict.Keys()), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·pop
-func dict_pop(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_pop(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := recv_.(*Dict)
 	var k, d Value
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &k, &d); err != nil {
@@ -1237,7 +1344,7 @@ func dict_pop(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, er
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·popitem
-func dict_popitem(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_popitem(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1254,7 +1361,7 @@ func dict_popitem(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·setdefault
-func dict_setdefault(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_setdefault(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var key, dflt Value = nil, None
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &key, &dflt); err != nil {
 		return nil, err
@@ -1265,27 +1372,31 @@ func dict_setdefault(fnname string, recv Value, args Tuple, kwargs []Tuple) (Val
 	} else if ok {
 		return v, nil
 	} else {
-		return dflt, dict.SetKey(key, dflt)
+		return dflt, dict.ht.insert(thread, key, dflt)
 	}
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·update
-func dict_update(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_update(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) > 1 {
 		return nil, fmt.Errorf("update: got %d arguments, want at most 1", len(args))
 	}
-	if err := updateDict(recv.(*Dict), args, kwargs); err != nil {
+	if err := updateDict(thread, recv.(*Dict), args, kwargs); err != nil {
 		return nil, fmt.Errorf("update: %v", err)
 	}
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·update
-func dict_values(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_values(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	items := recv.(*Dict).Items()
+	dict := recv.(*Dict)
+	if err := thread.addAllocs(listSize + int64(dict.Len())*valueSize); err != nil {
+		return nil, err
+	}
+	items := dict.Items()
 	res := make([]Value, len(items))
 	for i, item := range items {
 		res[i] = item[1]
@@ -1294,7 +1405,7 @@ func dict_values(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·append
-func list_append(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func list_append(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := recv_.(*List)
 	var object Value
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &object); err != nil {
@@ -1303,12 +1414,15 @@ func list_append(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 	if err := recv.checkMutable("append to"); err != nil {
 		return nil, err
 	}
+	if err := thread.addAllocs(valueSize); err != nil {
+		return nil, err
+	}
 	recv.elems = append(recv.elems, object)
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·clear
-func list_clear(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func list_clear(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1316,7 +1430,7 @@ func list_clear(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·extend
-func list_extend(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func list_extend(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := recv_.(*List)
 	var iterable Iterable
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &iterable); err != nil {
@@ -1325,12 +1439,14 @@ func list_extend(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 	if err := recv.checkMutable("extend"); err != nil {
 		return nil, err
 	}
-	listExtend(recv, iterable)
+	if err := listExtend(thread, recv, iterable); err != nil {
+		return nil, err
+	}
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·index
-func list_index(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func list_index(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := recv_.(*List)
 	var value, start_, end_ Value
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &value, &start_, &end_); err != nil {
@@ -1353,7 +1469,7 @@ func list_index(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·insert
-func list_insert(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func list_insert(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := recv_.(*List)
 	var index int
 	var object Value
@@ -1363,6 +1479,9 @@ func list_insert(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 	if err := recv.checkMutable("insert into"); err != nil {
 		return nil, err
 	}
+	if err := thread.addAllocs(valueSize); err != nil {
+		return nil, err
+	}
 
 	if index < 0 {
 		index += recv.Len()
@@ -1383,7 +1502,7 @@ func list_insert(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·remove
-func list_remove(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func list_remove(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := recv_.(*List)
 	var value Value
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &value); err != nil {
@@ -1404,7 +1523,7 @@ func list_remove(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·pop
-func list_pop(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func list_pop(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	list := recv.(*List)
 	index := list.Len() - 1
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0, &index); err != nil {
@@ -1421,12 +1540,45 @@ func list_pop(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, err
 	return res, nil
 }
 
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#bytes·decode
+func bytes_decode(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	s := string(recv.(Bytes))
+	if utf8.ValidString(s) {
+		return String(s), nil
+	}
+	// Replace each byte of an invalid UTF-8 sequence by U+FFFD,
+	// which is three bytes long.
+	if err := thread.addAllocs(stringSize + 3*int64(len(s))); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(s))
+	for _, r := range s {
+		buf.WriteRune(r)
+	}
+	return String(buf.String()), nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#bytes·elems
+func bytes_elems(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return bytesIterable{recv.(Bytes)}, nil
+}
+
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·capitalize
-func string_capitalize(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_capitalize(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
 	s := string(recv.(String))
+	if err := thread.addAllocs(stringSize + int64(len(s))); err != nil {
+		return nil, err
+	}
 	var res bytes.Buffer
 	res.Grow(len(s))
 	for i, r := range s {
@@ -1445,7 +1597,7 @@ func string_capitalize(fnname string, recv Value, args Tuple, kwargs []Tuple) (V
 // - codepoints: successive substrings that encode a single Unicode code point.
 // - elem_ords: numeric values of successive bytes
 // - codepoint_ords: numeric values of successive Unicode code points
-func string_iterable(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_iterable(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1457,7 +1609,7 @@ func string_iterable(fnname string, recv Value, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·count
-func string_count(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_count(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(recv_.(String))
 
 	var sub string
@@ -1479,7 +1631,7 @@ func string_count(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isalnum
-func string_isalnum(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isalnum(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1493,7 +1645,7 @@ func string_isalnum(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isalpha
-func string_isalpha(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isalpha(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1507,7 +1659,7 @@ func string_isalpha(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isdigit
-func string_isdigit(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isdigit(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1521,7 +1673,7 @@ func string_isdigit(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·islower
-func string_islower(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_islower(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1546,7 +1698,7 @@ func isCasedRune(r rune) bool {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isspace
-func string_isspace(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isspace(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1560,7 +1712,7 @@ func string_isspace(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·istitle
-func string_istitle(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_istitle(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1593,7 +1745,7 @@ func string_istitle(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isupper
-func string_isupper(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isupper(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
@@ -1602,13 +1754,17 @@ func string_isupper(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·find
-func string_find(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_find(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(fnname, string(recv.(String)), args, kwargs, true, false)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·format
-func string_format(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_format(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	format := string(recv_.(String))
+	// The literal portions of the result are no longer than format.
+	if err := thread.addAllocs(stringSize + int64(len(format))); err != nil {
+		return nil, err
+	}
 	var auto, manual bool // kinds of positional indexing used
 	path := make([]Value, 0, 4)
 	var buf bytes.Buffer
@@ -1731,9 +1887,17 @@ func string_format(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Valu
 			return nil, fmt.Errorf("format spec features not supported in replacement fields: %s", spec)
 		}
 
+		// A string operand is charged before it is copied. The length
+		// of the representation of any other value is not known until
+		// it is written, so its charge follows.
+		start := buf.Len()
 		switch conv {
 		case "s":
 			if str, ok := AsString(arg); ok {
+				if err := thread.addAllocs(int64(len(str))); err != nil {
+					return nil, err
+				}
+				start += len(str)
 				buf.WriteString(str)
 			} else {
 				writeValue(&buf, arg, path)
@@ -1743,17 +1907,20 @@ func string_format(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Valu
 		default:
 			return nil, fmt.Errorf("unknown conversion %q", conv)
 		}
+		if err := thread.addAllocs(int64(buf.Len() - start)); err != nil {
+			return nil, err
+		}
 	}
 	return String(buf.String()), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·index
-func string_index(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_index(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(fnname, string(recv.(String)), args, kwargs, false, false)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·join
-func string_join(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_join(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(recv_.(String))
 	var iterable Iterable
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &iterable); err != nil {
@@ -1771,21 +1938,28 @@ func string_join(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value,
 		if !ok {
 			return nil, fmt.Errorf("in list, want string, got %s", x.Type())
 		}
+		if err := thread.addAllocs(int64(len(recv) + len(s))); err != nil {
+			return nil, err
+		}
 		buf.WriteString(s)
 	}
 	return String(buf.String()), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·lower
-func string_lower(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_lower(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return String(strings.ToLower(string(recv.(String)))), nil
+	s := string(recv.(String))
+	if err := thread.addAllocs(stringSize + int64(len(s))); err != nil {
+		return nil, err
+	}
+	return String(strings.ToLower(s)), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·partition
-func string_partition(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_partition(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(recv_.(String))
 	var sep string
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &sep); err != nil {
@@ -1814,29 +1988,36 @@ func string_partition(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (V
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·replace
-func string_replace(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_replace(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(recv_.(String))
 	var old, new string
 	count := -1
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 2, &old, &new, &count); err != nil {
 		return nil, err
 	}
+	n := strings.Count(recv, old)
+	if count >= 0 && count < n {
+		n = count
+	}
+	if err := thread.addAllocs(stringSize + int64(len(recv)) + int64(n)*int64(len(new)-len(old))); err != nil {
+		return nil, err
+	}
 	return String(strings.Replace(recv, old, new, count)), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rfind
-func string_rfind(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_rfind(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(fnname, string(recv.(String)), args, kwargs, true, true)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rindex
-func string_rindex(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_rindex(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	return string_find_impl(fnname, string(recv.(String)), args, kwargs, false, true)
 }
 
 // https://github.com/google/starlark-go/starlark/blob/master/doc/spec.md#string·startswith
 // https://github.com/google/starlark-go/starlark/blob/master/doc/spec.md#string·endswith
-func string_startswith(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_startswith(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var x Value
 	var start, end Value = None, None
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &x, &start, &end); err != nil {
@@ -1881,7 +2062,7 @@ func string_startswith(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·strip
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·lstrip
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rstrip
-func string_strip(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_strip(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var chars string
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0, &chars); err != nil {
 		return nil, err
@@ -1912,12 +2093,15 @@ func string_strip(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·title
-func string_title(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_title(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
 
 	s := string(recv.(String))
+	if err := thread.addAllocs(stringSize + int64(len(s))); err != nil {
+		return nil, err
+	}
 
 	// Python semantics differ from x==strings.{To,}Title(x) in Go:
 	// "uppercase characters may only follow uncased characters and
@@ -1938,16 +2122,28 @@ func string_title(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·upper
-func string_upper(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_upper(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return String(strings.ToUpper(string(recv.(String)))), nil
+	s := string(recv.(String))
+	if err := thread.addAllocs(stringSize + int64(len(s))); err != nil {
+		return nil, err
+	}
+	return String(strings.ToUpper(s)), nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#string·encode
+func string_encode(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return Bytes(recv.(String)), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·split
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rsplit
-func string_split(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_split(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := string(recv_.(String))
 	var sep_ Value
 	maxsplit := -1
@@ -1959,6 +2155,9 @@ func string_split(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value
 
 	if sep_ == nil || sep_ == None {
 		// special case: split on whitespace
+		if err := chargeSplit(thread, countFields(recv), maxsplit); err != nil {
+			return nil, err
+		}
 		if maxsplit < 0 {
 			res = strings.Fields(recv)
 		} else if fnname == "split" {
@@ -1971,6 +2170,9 @@ func string_split(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value
 		if sep == "" {
 			return nil, fmt.Errorf("split: empty separator")
 		}
+		if err := chargeSplit(thread, strings.Count(recv, sep)+1, maxsplit); err != nil {
+			return nil, err
+		}
 		// usual case: split on non-empty separator
 		if maxsplit < 0 {
 			res = strings.Split(recv, sep)
@@ -1995,6 +2197,31 @@ func string_split(fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value
 	return NewList(list), nil
 }
 
+// chargeSplit records the allocation of a list of n substrings,
+// or of maxsplit+1 substrings if that is fewer and maxsplit >= 0.
+func chargeSplit(thread *Thread, n, maxsplit int) error {
+	if maxsplit >= 0 && maxsplit < n-1 {
+		n = maxsplit + 1
+	}
+	return thread.addAllocs(listSize + int64(n)*(valueSize+stringSize))
+}
+
+// countFields returns the number of
+// whitespace-separated fields of s.
+func countFields(s string) int {
+	n := 0
+	inField := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			inField = false
+		} else if !inField {
+			inField = true
+			n++
+		}
+	}
+	return n
+}
+
 // Precondition: max >= 0.
 func rsplitspace(s string, max int) []string {
 	res := make([]string, 0, max+1)
@@ -2050,12 +2277,16 @@ func splitspace(s string, max int) []string {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·splitlines
-func string_splitlines(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func string_splitlines(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var keepends bool
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0, &keepends); err != nil {
 		return nil, err
 	}
 	s := string(recv.(String))
+	n := strings.Count(s, "\n") + 1
+	if err := thread.addAllocs(listSize + int64(n)*(valueSize+stringSize)); err != nil {
+		return nil, err
+	}
 	var lines []string
 	// TODO(adonovan): handle CRLF correctly.
 	if keepends {
@@ -2074,20 +2305,197 @@ func string_splitlines(fnname string, recv Value, args Tuple, kwargs []Tuple) (V
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·union.
-func set_union(fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+func set_union(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
 	if err := UnpackPositionalArgs(fnname, args, kwargs, 0, &iterable); err != nil {
 		return nil, err
 	}
 	iter := iterable.Iterate()
 	defer iter.Done()
-	union, err := recv.(*Set).Union(iter)
+	union, err := recv.(*Set).union(thread, iter)
 	if err != nil {
 		return nil, fmt.Errorf("union: %v", err)
 	}
 	return union, nil
 }
 
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·add
+func set_add(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	var elem Value
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &elem); err != nil {
+		return nil, err
+	}
+	if err := recv.(*Set).insert(thread, elem); err != nil {
+		return nil, fmt.Errorf("add: %v", err)
+	}
+	return None, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·clear
+func set_clear(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if err := recv.(*Set).Clear(); err != nil {
+		return nil, fmt.Errorf("clear: %v", err)
+	}
+	return None, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·difference
+func set_difference(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	other, err := setArg(thread, fnname, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := recv.(*Set).difference(thread, other)
+	if err != nil {
+		return nil, fmt.Errorf("difference: %v", err)
+	}
+	return diff, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·discard
+func set_discard(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	var elem Value
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &elem); err != nil {
+		return nil, err
+	}
+	if _, err := recv.(*Set).Delete(elem); err != nil {
+		return nil, fmt.Errorf("discard: %v", err) // set is frozen or element is unhashable
+	}
+	return None, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·intersection
+func set_intersection(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	other, err := setArg(thread, fnname, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	intersection, err := recv.(*Set).intersection(thread, other)
+	if err != nil {
+		return nil, fmt.Errorf("intersection: %v", err)
+	}
+	return intersection, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·isdisjoint
+func set_isdisjoint(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	other, err := setArg(thread, fnname, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return Bool(recv.(*Set).isDisjoint(other)), nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·issubset
+func set_issubset(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	other, err := setArg(thread, fnname, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return Bool(recv.(*Set).isSubset(other)), nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·issuperset
+func set_issuperset(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	other, err := setArg(thread, fnname, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return Bool(other.isSubset(recv.(*Set))), nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·pop
+func set_pop(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	recv := recv_.(*Set)
+	if err := recv.checkMutable("pop from"); err != nil {
+		return nil, fmt.Errorf("pop: %v", err)
+	}
+	elem, ok := recv.ht.first()
+	if !ok {
+		return nil, fmt.Errorf("pop: empty set")
+	}
+	if _, err := recv.Delete(elem); err != nil {
+		return nil, fmt.Errorf("pop: %v", err)
+	}
+	return elem, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·remove
+func set_remove(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	var elem Value
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &elem); err != nil {
+		return nil, err
+	}
+	if found, err := recv.(*Set).Delete(elem); err != nil {
+		return nil, fmt.Errorf("remove: %v", err) // set is frozen or element is unhashable
+	} else if !found {
+		return nil, fmt.Errorf("remove: missing element %s", elem)
+	}
+	return None, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·symmetric_difference
+func set_symmetric_difference(thread *Thread, fnname string, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	other, err := setArg(thread, fnname, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := recv.(*Set).symmetricDifference(thread, other)
+	if err != nil {
+		return nil, fmt.Errorf("symmetric_difference: %v", err)
+	}
+	return diff, nil
+}
+
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#set·update
+func set_update(thread *Thread, fnname string, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	var iterable Iterable
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &iterable); err != nil {
+		return nil, err
+	}
+	recv := recv_.(*Set)
+	if err := recv.checkMutable("insert into"); err != nil {
+		return nil, fmt.Errorf("update: %v", err)
+	}
+	if iterable == Iterable(recv) {
+		return None, nil // s.update(s) is a no-op
+	}
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var x Value
+	for iter.Next(&x) {
+		if err := recv.insert(thread, x); err != nil {
+			return nil, fmt.Errorf("update: %v", err)
+		}
+	}
+	return None, nil
+}
+
+// setArg unpacks the sole iterable argument of a set method,
+// returning it as a set.
+func setArg(thread *Thread, fnname string, args Tuple, kwargs []Tuple) (*Set, error) {
+	var iterable Iterable
+	if err := UnpackPositionalArgs(fnname, args, kwargs, 1, &iterable); err != nil {
+		return nil, err
+	}
+	if set, ok := iterable.(*Set); ok {
+		return set, nil
+	}
+	iter := iterable.Iterate()
+	defer iter.Done()
+	set, err := makeSet(thread, iter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fnname, err)
+	}
+	return set, nil
+}
+
 // Common implementation of string_{r}{find,index}.
 func string_find_impl(fnname string, s string, args Tuple, kwargs []Tuple, allowError, last bool) (Value, error) {
 	var sub string
@@ -2122,7 +2530,7 @@ func string_find_impl(fnname string, s string, args Tuple, kwargs []Tuple, allow
 
 // Common implementation of builtin dict function and dict.update method.
 // Precondition: len(updates) == 0 or 1.
-func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
+func updateDict(thread *Thread, dict *Dict, updates Tuple, kwargs []Tuple) error {
 	if len(updates) == 1 {
 		switch updates := updates[0].(type) {
 		case NoneType:
@@ -2130,7 +2538,7 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 		case *Dict:
 			// Iterate over dict's key/value pairs, not just keys.
 			for _, item := range updates.Items() {
-				if err := dict.SetKey(item[0], item[1]); err != nil {
+				if err := dict.ht.insert(thread, item[0], item[1]); err != nil {
 					return err // dict is frozen
 				}
 			}
@@ -2158,7 +2566,7 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 				var k, v Value
 				iter2.Next(&k)
 				iter2.Next(&v)
-				if err := dict.SetKey(k, v); err != nil {
+				if err := dict.ht.insert(thread, k, v); err != nil {
 					return err
 				}
 			}
@@ -2168,7 +2576,7 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 	// Then add the kwargs.
 	before := dict.Len()
 	for _, pair := range kwargs {
-		if err := dict.SetKey(pair[0], pair[1]); err != nil {
+		if err := dict.ht.insert(thread, pair[0], pair[1]); err != nil {
 			return err // dict is frozen
 		}
 	}
@@ -0,0 +1,324 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlark
+
+// This file defines a simple time profiler for Starlark.
+//
+// A ticker goroutine advances a global counter at a fixed interval
+// while profiling is enabled. Each thread compares the counter with
+// the value it last observed before executing each instruction, and
+// after each call to a built-in function; when they differ, the
+// thread records its stack, weighted by the number of elapsed ticks.
+// Threads thus sample themselves, so no synchronization is needed to
+// inspect their stacks, and the cost when profiling is disabled is one
+// atomic load per instruction.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// profilePeriod is the interval between samples.
+const profilePeriod = 10 * time.Millisecond
+
+// profTicks is the number of ticks of the profiler's clock.
+// It changes only while profiling is enabled.
+var profTicks uint32
+
+var profiler struct {
+	mu        sync.Mutex // guards all fields
+	out       io.Writer  // nil => profiling disabled
+	start     time.Time
+	startTick uint32 // value of profTicks when profiling started
+	stop      chan struct{}
+	builder   *profileBuilder
+}
+
+// StartProfile enables time profiling of all Starlark threads, and
+// arranges for a profile to be written to out when StopProfile is
+// called. The profile is in the gzip-compressed protocol buffer format
+// used by pprof, and its functions and line numbers refer to Starlark
+// source files. Calls to built-in functions appear as functions in the
+// file "<builtin>".
+//
+// Every 10ms that a thread spends executing Starlark code, including
+// time within built-in functions it calls, contributes one sample of
+// its call stack. Threads may be started and stopped while profiling
+// is enabled.
+//
+// StartProfile returns an error if profiling is already enabled.
+func StartProfile(out io.Writer) error {
+	profiler.mu.Lock()
+	defer profiler.mu.Unlock()
+	if profiler.out != nil {
+		return fmt.Errorf("profiler already running")
+	}
+	profiler.out = out
+	profiler.start = time.Now()
+	profiler.startTick = atomic.LoadUint32(&profTicks)
+	profiler.stop = make(chan struct{})
+	profiler.builder = newProfileBuilder()
+
+	go func(start time.Time, startTick uint32, stop chan struct{}) {
+		ticker := time.NewTicker(profilePeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Derive the clock from the elapsed time,
+				// as the ticker may drop ticks if delayed.
+				ticks := startTick + uint32(time.Since(start)/profilePeriod)
+				atomic.StoreUint32(&profTicks, ticks)
+			case <-stop:
+				return
+			}
+		}
+	}(profiler.start, profiler.startTick, profiler.stop)
+	return nil
+}
+
+// StopProfile stops profiling started by a prior call to StartProfile,
+// and writes the profile to its writer.
+func StopProfile() error {
+	profiler.mu.Lock()
+	defer profiler.mu.Unlock()
+	if profiler.out == nil {
+		return fmt.Errorf("profiler not running")
+	}
+	profiler.stop <- struct{}{} // wait for the clock to stop
+	data := profiler.builder.build(profiler.start, time.Since(profiler.start))
+	out := profiler.out
+	profiler.out = nil
+	profiler.builder = nil
+
+	zw := gzip.NewWriter(out)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// profileSample records the current stack of the thread if the
+// profiler's clock has advanced since the thread last observed it.
+// Its caller is responsible for updating the callpc of the top frame.
+func (thread *Thread) profileSample() {
+	ticks := atomic.LoadUint32(&profTicks)
+	if ticks == thread.profTick {
+		return
+	}
+	last := thread.profTick
+	thread.profTick = ticks
+
+	profiler.mu.Lock()
+	defer profiler.mu.Unlock()
+	if profiler.out == nil {
+		return // clock advanced during a previous profile
+	}
+	if last < profiler.startTick {
+		last = profiler.startTick
+	}
+	if ticks > last {
+		profiler.builder.addSample(thread.frame, int64(ticks-last))
+	}
+}
+
+// A profileBuilder accumulates the samples of a profile.
+type profileBuilder struct {
+	strings   map[string]int64
+	functions map[interface{}]uint64 // *compile.Funcode or built-in name => function ID
+	locations map[[2]uint64]uint64   // function ID, line => location ID
+	samples   map[string]*profileSampleRecord
+
+	stringTable []string
+	functionPB  bytes.Buffer // encoded Function messages
+	locationPB  bytes.Buffer // encoded Location messages
+	sampleOrder []string
+}
+
+type profileSampleRecord struct {
+	locations []uint64
+	count     int64
+}
+
+func newProfileBuilder() *profileBuilder {
+	b := &profileBuilder{
+		strings:   make(map[string]int64),
+		functions: make(map[interface{}]uint64),
+		locations: make(map[[2]uint64]uint64),
+		samples:   make(map[string]*profileSampleRecord),
+	}
+	b.str("") // string 0 is always empty
+	return b
+}
+
+// str returns the index of s in the string table.
+func (b *profileBuilder) str(s string) int64 {
+	i, ok := b.strings[s]
+	if !ok {
+		i = int64(len(b.stringTable))
+		b.strings[s] = i
+		b.stringTable = append(b.stringTable, s)
+	}
+	return i
+}
+
+// function returns the ID of the function of fr.
+func (b *profileBuilder) function(fr *Frame) uint64 {
+	var key interface{}
+	var name, filename string
+	var line int32
+	if fn, ok := fr.callable.(*Function); ok {
+		key = fn.funcode
+		name = fn.funcode.Name
+		filename = fn.funcode.Pos.Filename()
+		line = fn.funcode.Pos.Line
+	} else {
+		name = fr.callable.Name()
+		key = name
+		filename = builtinFilename
+	}
+	id, ok := b.functions[key]
+	if !ok {
+		id = uint64(len(b.functions) + 1)
+		b.functions[key] = id
+		var msg protoBuffer
+		msg.uint64(1, id)                  // id
+		msg.int64(2, b.str(name))          // name
+		msg.int64(3, b.str(name))          // system_name
+		msg.int64(4, b.str(filename))      // filename
+		msg.int64(5, int64(line))          // start_line
+		b.functionPB.Write(msg.message(5)) // Profile.function
+	}
+	return id
+}
+
+// location returns the ID of the current location of fr.
+func (b *profileBuilder) location(fr *Frame) uint64 {
+	fnID := b.function(fr)
+	var line int32
+	if _, ok := fr.callable.(*Function); ok {
+		line = fr.Position().Line
+	}
+	key := [2]uint64{fnID, uint64(line)}
+	id, ok := b.locations[key]
+	if !ok {
+		id = uint64(len(b.locations) + 1)
+		b.locations[key] = id
+		var lineMsg protoBuffer
+		lineMsg.uint64(1, fnID)       // function_id
+		lineMsg.int64(2, int64(line)) // line
+		var msg protoBuffer
+		msg.uint64(1, id)                  // id
+		msg.bytes(lineMsg.message(4))      // Location.line
+		b.locationPB.Write(msg.message(4)) // Profile.location
+	}
+	return id
+}
+
+// addSample records n samples of the stack whose top frame is fr.
+func (b *profileBuilder) addSample(fr *Frame, n int64) {
+	var locs []uint64
+	var key bytes.Buffer
+	for ; fr != nil; fr = fr.parent {
+		id := b.location(fr)
+		locs = append(locs, id)
+		fmt.Fprintf(&key, "%d,", id)
+	}
+	s := b.samples[key.String()]
+	if s == nil {
+		s = &profileSampleRecord{locations: locs}
+		b.samples[key.String()] = s
+		b.sampleOrder = append(b.sampleOrder, key.String())
+	}
+	s.count += n
+}
+
+// build returns the encoded Profile message.
+func (b *profileBuilder) build(start time.Time, duration time.Duration) []byte {
+	var p protoBuffer
+	valueType := func(field int, typ, unit string) {
+		var msg protoBuffer
+		msg.int64(1, b.str(typ))  // type
+		msg.int64(2, b.str(unit)) // unit
+		p.bytes(msg.message(field))
+	}
+	valueType(1, "samples", "count")    // sample_type
+	valueType(1, "time", "nanoseconds") // sample_type
+	for _, key := range b.sampleOrder {
+		s := b.samples[key]
+		var msg protoBuffer
+		msg.packedUint64(1, s.locations)                                     // location_id
+		msg.packedInt64(2, []int64{s.count, s.count * int64(profilePeriod)}) // value
+		p.bytes(msg.message(2))
+	}
+	p.bytes(b.locationPB.Bytes())
+	p.bytes(b.functionPB.Bytes())
+	valueType(11, "time", "nanoseconds") // period_type
+	p.int64(12, int64(profilePeriod))    // period
+	p.int64(9, start.UnixNano())         // time_nanos
+	p.int64(10, int64(duration))         // duration_nanos
+	for _, s := range b.stringTable {
+		p.string(6, s) // string_table
+	}
+	return p.buf
+}
+
+// A protoBuffer encodes the fields of a protocol buffer message.
+type protoBuffer struct{ buf []byte }
+
+func (p *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		p.buf = append(p.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	p.buf = append(p.buf, byte(x))
+}
+
+func (p *protoBuffer) tag(field, wireType int) { p.varint(uint64(field)<<3 | uint64(wireType)) }
+
+func (p *protoBuffer) uint64(field int, x uint64) {
+	p.tag(field, 0)
+	p.varint(x)
+}
+
+func (p *protoBuffer) int64(field int, x int64) { p.uint64(field, uint64(x)) }
+
+func (p *protoBuffer) string(field int, s string) {
+	p.tag(field, 2)
+	p.varint(uint64(len(s)))
+	p.buf = append(p.buf, s...)
+}
+
+func (p *protoBuffer) packedUint64(field int, xs []uint64) {
+	var q protoBuffer
+	for _, x := range xs {
+		q.varint(x)
+	}
+	p.string(field, string(q.buf))
+}
+
+func (p *protoBuffer) packedInt64(field int, xs []int64) {
+	var q protoBuffer
+	for _, x := range xs {
+		q.varint(uint64(x))
+	}
+	p.string(field, string(q.buf))
+}
+
+// bytes appends already encoded fields.
+func (p *protoBuffer) bytes(data []byte) { p.buf = append(p.buf, data...) }
+
+// message returns the encoding of p as the specified
+// field of an enclosing message.
+func (p *protoBuffer) message(field int) []byte {
+	var q protoBuffer
+	q.string(field, string(p.buf))
+	return q.buf
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlark_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+func TestProfile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := starlark.StartProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := starlark.StartProfile(&buf); err == nil {
+		t.Error("second StartProfile succeeded")
+	}
+
+	// sleep is a built-in that the profile should attribute time to.
+	sleep := starlark.NewBuiltin("sleep", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		time.Sleep(50 * time.Millisecond)
+		return starlark.None, nil
+	})
+	thread := new(starlark.Thread)
+	_, err := starlark.ExecFile(thread, "profile.star", `
+def busy():
+    n = 0
+    for i in range(1000000):
+        n += i
+    return n
+
+def idle():
+    sleep()
+
+busy()
+idle()
+`, starlark.StringDict{"sleep": sleep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := starlark.StopProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := starlark.StopProfile(); err == nil {
+		t.Error("second StopProfile succeeded")
+	}
+
+	// Rather than decode the protocol buffer, just check
+	// that the expected strings appear in its string table.
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"profile.star", "<builtin>", "busy", "idle", "sleep", "nanoseconds"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("profile does not mention %q", s)
+		}
+	}
+}
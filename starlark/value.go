@@ -135,6 +135,7 @@ var (
 	_ Comparable = False
 	_ Comparable = Float(0)
 	_ Comparable = String("")
+	_ Comparable = Bytes("")
 	_ Comparable = (*Dict)(nil)
 	_ Comparable = (*List)(nil)
 	_ Comparable = Tuple(nil)
@@ -208,8 +209,10 @@ var (
 	_ HasSetIndex = (*List)(nil)
 	_ Indexable   = Tuple(nil)
 	_ Indexable   = String("")
+	_ Indexable   = Bytes("")
 	_ Sliceable   = Tuple(nil)
 	_ Sliceable   = String("")
+	_ Sliceable   = Bytes("")
 	_ Sliceable   = (*List)(nil)
 )
 
@@ -248,6 +251,15 @@ type Mapping interface {
 
 var _ Mapping = (*Dict)(nil)
 
+// An IterableMapping is a mapping that supports key enumeration.
+type IterableMapping interface {
+	Mapping
+	Iterate() Iterator // see Iterable interface
+	Items() []Tuple    // a new slice containing all key/value pairs
+}
+
+var _ IterableMapping = (*Dict)(nil)
+
 // A HasSetKey supports map update using x[k]=v syntax, like a dictionary.
 type HasSetKey interface {
 	Mapping
@@ -289,6 +301,7 @@ type HasAttrs interface {
 
 var (
 	_ HasAttrs = String("")
+	_ HasAttrs = Bytes("")
 	_ HasAttrs = new(List)
 	_ HasAttrs = new(Dict)
 	_ HasAttrs = new(Set)
@@ -513,6 +526,73 @@ func (it *stringIterator) Next(p *Value) bool {
 
 func (*stringIterator) Done() {}
 
+// Bytes is the type of a Starlark binary string.
+//
+// A Bytes encapsulates an immutable sequence of bytes.
+// It is comparable, indexable, and sliceable, but not directly iterable;
+// use the bytes.elems() method for an iterable view.
+//
+// In this Go implementation, the elements of 'string' and 'bytes' are
+// both bytes, but the two types are distinct: a string is
+// conventionally UTF-8 text and a bytes value is arbitrary binary data.
+// Indexing a bytes value yields an int.
+type Bytes string
+
+func (b Bytes) String() string        { return "b" + strconv.Quote(string(b)) }
+func (b Bytes) Type() string          { return "bytes" }
+func (b Bytes) Freeze()               {} // immutable
+func (b Bytes) Truth() Bool           { return len(b) > 0 }
+func (b Bytes) Hash() (uint32, error) { return hashString(string(b)), nil }
+func (b Bytes) Len() int              { return len(b) }
+func (b Bytes) Index(i int) Value     { return MakeInt(int(b[i])) }
+
+func (b Bytes) Slice(start, end, step int) Value {
+	if step == 1 {
+		return b[start:end]
+	}
+
+	sign := signum(step)
+	var str []byte
+	for i := start; signum(end-i) == sign; i += step {
+		str = append(str, b[i])
+	}
+	return Bytes(str)
+}
+
+func (b Bytes) Attr(name string) (Value, error) { return builtinAttr(b, name, bytesMethods) }
+func (b Bytes) AttrNames() []string             { return builtinAttrNames(bytesMethods) }
+
+func (x Bytes) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error) {
+	y := y_.(Bytes)
+	return threeway(op, strings.Compare(string(x), string(y))), nil
+}
+
+// A bytesIterable is an iterable whose iterator yields
+// the numeric values of successive bytes of a Bytes.
+type bytesIterable struct{ b Bytes }
+
+var _ Iterable = (*bytesIterable)(nil)
+
+func (bi bytesIterable) String() string        { return bi.b.String() + ".elems()" }
+func (bi bytesIterable) Type() string          { return "bytes.elems" }
+func (bi bytesIterable) Freeze()               {} // immutable
+func (bi bytesIterable) Truth() Bool           { return True }
+func (bi bytesIterable) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", bi.Type()) }
+func (bi bytesIterable) Iterate() Iterator     { return &bytesIterator{bi.b} }
+
+type bytesIterator struct{ b Bytes }
+
+func (it *bytesIterator) Next(p *Value) bool {
+	if it.b == "" {
+		return false
+	}
+	*p = MakeInt(int(it.b[0]))
+	it.b = it.b[1:]
+	return true
+}
+
+func (*bytesIterator) Done() {}
+
 // A Function is a function defined by a Starlark def statement or lambda expression.
 // The initialization behavior of a Starlark module is also represented by a Function.
 type Function struct {
@@ -621,7 +701,7 @@ func (d *Dict) Items() []Tuple                                  { return d.ht.it
 func (d *Dict) Keys() []Value                                   { return d.ht.keys() }
 func (d *Dict) Len() int                                        { return int(d.ht.len) }
 func (d *Dict) Iterate() Iterator                               { return d.ht.iterate() }
-func (d *Dict) SetKey(k, v Value) error                         { return d.ht.insert(k, v) }
+func (d *Dict) SetKey(k, v Value) error                         { return d.ht.insert(nil, k, v) }
 func (d *Dict) String() string                                  { return toString(d) }
 func (d *Dict) Type() string                                    { return "dict" }
 func (d *Dict) Freeze()                                         { d.ht.freeze() }
@@ -871,18 +951,50 @@ type Set struct {
 	ht hashtable // values are all None
 }
 
-func (s *Set) Delete(k Value) (found bool, err error) { _, found, err = s.ht.delete(k); return }
-func (s *Set) Clear() error                           { return s.ht.clear() }
-func (s *Set) Has(k Value) (found bool, err error)    { _, found, err = s.ht.lookup(k); return }
-func (s *Set) Insert(k Value) error                   { return s.ht.insert(k, None) }
-func (s *Set) Len() int                               { return int(s.ht.len) }
-func (s *Set) Iterate() Iterator                      { return s.ht.iterate() }
-func (s *Set) String() string                         { return toString(s) }
-func (s *Set) Type() string                           { return "set" }
-func (s *Set) elems() []Value                         { return s.ht.keys() }
-func (s *Set) Freeze()                                { s.ht.freeze() }
-func (s *Set) Hash() (uint32, error)                  { return 0, fmt.Errorf("unhashable type: set") }
-func (s *Set) Truth() Bool                            { return s.Len() > 0 }
+func (s *Set) Has(k Value) (found bool, err error) { _, found, err = s.ht.lookup(k); return }
+func (s *Set) Insert(k Value) error                { return s.insert(nil, k) }
+func (s *Set) Len() int                            { return int(s.ht.len) }
+func (s *Set) Iterate() Iterator                   { return s.ht.iterate() }
+func (s *Set) String() string                      { return toString(s) }
+func (s *Set) Type() string                        { return "set" }
+func (s *Set) elems() []Value                      { return s.ht.keys() }
+func (s *Set) Freeze()                             { s.ht.freeze() }
+func (s *Set) Hash() (uint32, error)               { return 0, fmt.Errorf("unhashable type: set") }
+func (s *Set) Truth() Bool                         { return s.Len() > 0 }
+
+// checkMutable reports an error if the set should not be mutated.
+// verb+" set" should describe the operation.
+func (s *Set) checkMutable(verb string) error {
+	if s.ht.frozen {
+		return fmt.Errorf("cannot %s frozen set", verb)
+	}
+	if s.ht.itercount > 0 {
+		return fmt.Errorf("cannot %s set during iteration", verb)
+	}
+	return nil
+}
+
+func (s *Set) insert(thread *Thread, k Value) error {
+	if err := s.checkMutable("insert into"); err != nil {
+		return err
+	}
+	return s.ht.insert(thread, k, None)
+}
+
+func (s *Set) Delete(k Value) (found bool, err error) {
+	if err := s.checkMutable("delete from"); err != nil {
+		return false, err
+	}
+	_, found, err = s.ht.delete(k)
+	return found, err
+}
+
+func (s *Set) Clear() error {
+	if err := s.checkMutable("clear"); err != nil {
+		return err
+	}
+	return s.ht.clear()
+}
 
 func (s *Set) Attr(name string) (Value, error) { return builtinAttr(s, name, setMethods) }
 func (s *Set) AttrNames() []string             { return builtinAttrNames(setMethods) }
@@ -896,6 +1008,14 @@ func (x *Set) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error
 	case syntax.NEQ:
 		ok, err := setsEqual(x, y, depth)
 		return !ok, err
+	case syntax.LT: // proper subset
+		return x.Len() < y.Len() && x.isSubset(y), nil
+	case syntax.LE: // subset
+		return x.isSubset(y), nil
+	case syntax.GT: // proper superset
+		return x.Len() > y.Len() && y.isSubset(x), nil
+	case syntax.GE: // superset
+		return y.isSubset(x), nil
 	default:
 		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
 	}
@@ -913,20 +1033,156 @@ func setsEqual(x, y *Set, depth int) (bool, error) {
 	return true, nil
 }
 
-func (s *Set) Union(iter Iterator) (Value, error) {
+func (s *Set) Union(iter Iterator) (Value, error) { return s.union(nil, iter) }
+
+func (s *Set) Intersection(iter Iterator) (Value, error) {
+	other, err := makeSet(nil, iter)
+	if err != nil {
+		return nil, err
+	}
+	return s.intersection(nil, other)
+}
+
+func (s *Set) Difference(iter Iterator) (Value, error) {
+	other, err := makeSet(nil, iter)
+	if err != nil {
+		return nil, err
+	}
+	return s.difference(nil, other)
+}
+
+func (s *Set) SymmetricDifference(iter Iterator) (Value, error) {
+	other, err := makeSet(nil, iter)
+	if err != nil {
+		return nil, err
+	}
+	return s.symmetricDifference(nil, other)
+}
+
+func (s *Set) IsSubset(iter Iterator) (bool, error) {
+	other, err := makeSet(nil, iter)
+	if err != nil {
+		return false, err
+	}
+	return s.isSubset(other), nil
+}
+
+func (s *Set) IsSuperset(iter Iterator) (bool, error) {
+	other, err := makeSet(nil, iter)
+	if err != nil {
+		return false, err
+	}
+	return other.isSubset(s), nil
+}
+
+func (s *Set) IsDisjoint(iter Iterator) (bool, error) {
+	other, err := makeSet(nil, iter)
+	if err != nil {
+		return false, err
+	}
+	return s.isDisjoint(other), nil
+}
+
+func (s *Set) union(thread *Thread, iter Iterator) (Value, error) {
 	set := new(Set)
 	for _, elem := range s.elems() {
-		set.Insert(elem) // can't fail
+		if err := set.ht.insert(thread, elem, None); err != nil {
+			return nil, err
+		}
 	}
 	var x Value
 	for iter.Next(&x) {
-		if err := set.Insert(x); err != nil {
+		if err := set.ht.insert(thread, x, None); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// makeSet returns a new set containing the elements of iter.
+func makeSet(thread *Thread, iter Iterator) (*Set, error) {
+	set := new(Set)
+	var x Value
+	for iter.Next(&x) {
+		if err := set.ht.insert(thread, x, None); err != nil {
 			return nil, err
 		}
 	}
 	return set, nil
 }
 
+// intersection returns a new set of the elements of s that are also
+// in other, preserving the order of s.
+func (s *Set) intersection(thread *Thread, other *Set) (*Set, error) {
+	set := new(Set)
+	for _, elem := range s.elems() {
+		// Has cannot fail here.
+		if found, _ := other.Has(elem); found {
+			if err := set.ht.insert(thread, elem, None); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// difference returns a new set of the elements of s that are not in other.
+func (s *Set) difference(thread *Thread, other *Set) (*Set, error) {
+	set := new(Set)
+	for _, elem := range s.elems() {
+		if found, _ := other.Has(elem); !found {
+			if err := set.ht.insert(thread, elem, None); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// symmetricDifference returns a new set of the elements
+// that are in exactly one of s and other.
+func (s *Set) symmetricDifference(thread *Thread, other *Set) (*Set, error) {
+	set, err := s.difference(thread, other)
+	if err != nil {
+		return nil, err
+	}
+	for _, elem := range other.elems() {
+		if found, _ := s.Has(elem); !found {
+			if err := set.ht.insert(thread, elem, None); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// isSubset reports whether every element of s is in other.
+func (s *Set) isSubset(other *Set) bool {
+	if s.Len() > other.Len() {
+		return false
+	}
+	for _, elem := range s.elems() {
+		if found, _ := other.Has(elem); !found {
+			return false
+		}
+	}
+	return true
+}
+
+// isDisjoint reports whether s and other have no elements in common.
+func (s *Set) isDisjoint(other *Set) bool {
+	x, y := s, other
+	if x.Len() > y.Len() {
+		x, y = y, x // opt: range over smaller set
+	}
+	for _, elem := range x.elems() {
+		if found, _ := y.Has(elem); found {
+			return false
+		}
+	}
+	return true
+}
+
 // toString returns the string form of value v.
 // It may be more efficient than v.String() for larger values.
 func toString(v Value) string {
@@ -1170,7 +1426,7 @@ func b2i(b bool) int {
 	}
 }
 
-// Len returns the length of a string or sequence value,
+// Len returns the length of a string, bytes, or sequence value,
 // and -1 for all others.
 //
 // Warning: Len(x) >= 0 does not imply Iterate(x) != nil.
@@ -1179,6 +1435,8 @@ func Len(x Value) int {
 	switch x := x.(type) {
 	case String:
 		return x.Len()
+	case Bytes:
+		return x.Len()
 	case Sequence:
 		return x.Len()
 	}
@@ -0,0 +1,615 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package starlarkconv converts between Go and Starlark values using
+// reflection.
+//
+// ToValue converts a Go value to a Starlark value, and FromValue
+// converts a Starlark value to a Go variable of a given type.
+// For most types, the two functions are inverses.
+// Wrap exposes the fields and methods of a Go struct to Starlark
+// without copying it.
+//
+// The Starlark name of a Go struct field is its Go name, unless the
+// field's tag has a "starlark" key:
+//
+//	type Server struct {
+//		Port   int    `starlark:"port"`
+//		Secret string `starlark:"-"` // ignored
+//	}
+//
+// Unexported fields and fields tagged "-" are ignored.
+// The fields of an embedded struct without a tag are promoted,
+// as if they were fields of the outer struct.
+package starlarkconv // import "go.starlark.net/starlarkconv"
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/starlarktime"
+	"go.starlark.net/syntax"
+)
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	byteType     = reflect.TypeOf(byte(0))
+	durationType = reflect.TypeOf(time.Duration(0))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	threadType   = reflect.TypeOf((*starlark.Thread)(nil))
+	timeType     = reflect.TypeOf(time.Time{})
+	valueType    = reflect.TypeOf((*starlark.Value)(nil)).Elem()
+)
+
+// ToValue converts a Go value to a Starlark value, by cases:
+//   - A Go value that is already a starlark.Value is returned unchanged.
+//   - nil, and nil pointers and interfaces, become None.
+//   - bool, integers, floats, and strings become Bool, Int, Float, and String.
+//   - big.Int and *big.Int become Int.
+//   - time.Time and time.Duration become starlarktime.Time and Duration.
+//   - []byte and [N]byte become Bytes.
+//   - Other slices become lists, and arrays become tuples.
+//   - Maps become dicts whose entries are sorted by key.
+//   - Structs become starlarkstruct.Structs.
+//   - Non-nil pointers and interfaces are converted to the value they refer to.
+//
+// It is an error to convert channels, functions, complex numbers, and
+// values that refer to themselves.
+// The error message indicates the path to the offending value,
+// for example ["k"][2].X.
+func ToValue(x interface{}) (starlark.Value, error) {
+	c := converter{seen: make(map[ref]bool)}
+	v, err := c.toValue(reflect.ValueOf(x))
+	if err != nil {
+		return nil, c.wrap(err)
+	}
+	return v, nil
+}
+
+// FromValue converts the Starlark value x to a Go value and stores it
+// in the variable pointed to by target, which must be a non-nil pointer.
+// It applies the inverse of the conversions of ToValue, according to
+// the type of the target variable.
+//
+// A target of type interface{} receives a natural Go representation
+// of x: nil, bool, int64 (or *big.Int if too large), float64, string,
+// []byte, time.Time, time.Duration, []interface{} for lists, tuples,
+// and sets, and map[string]interface{} for structs and for dicts with
+// string keys. Other dicts become map[interface{}]interface{},
+// and other values are stored unchanged.
+//
+// A struct target may be populated from a struct or from a dict with
+// string keys; it is an error if x has a field that the target lacks.
+// A pointer target is set to nil if x is None, and otherwise to a
+// newly allocated variable.
+func FromValue(x starlark.Value, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("FromValue: target must be a non-nil pointer, got %T", target)
+	}
+	var c converter
+	if err := c.fromValue(x, ptr.Elem()); err != nil {
+		return c.wrap(err)
+	}
+	return nil
+}
+
+// A converter holds the state of a single conversion.
+// When a conversion fails, path indicates the offending value.
+type converter struct {
+	path []string     // path from the root to the current value, e.g. ["k"], [2], .x
+	seen map[ref]bool // references on the path, for cycle detection (ToValue only)
+}
+
+// A ref identifies the target of a Go pointer, map, or slice.
+type ref struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+func (c *converter) wrap(err error) error {
+	if len(c.path) > 0 {
+		return fmt.Errorf("at %s: %v", strings.Join(c.path, ""), err)
+	}
+	return err
+}
+
+func (c *converter) push(elem string) { c.path = append(c.path, elem) }
+func (c *converter) pop()             { c.path = c.path[:len(c.path)-1] }
+
+// enter records that the reference v is on the current path,
+// and reports an error if it already was.
+func (c *converter) enter(v reflect.Value) error {
+	r := ref{v.Pointer(), v.Type()}
+	if c.seen[r] {
+		return fmt.Errorf("cycle in Go value of type %s", v.Type())
+	}
+	c.seen[r] = true
+	return nil
+}
+
+func (c *converter) leave(v reflect.Value) { delete(c.seen, ref{v.Pointer(), v.Type()}) }
+
+func (c *converter) toValue(v reflect.Value) (starlark.Value, error) {
+	if !v.IsValid() {
+		return starlark.None, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return starlark.None, nil
+		}
+	}
+	if v.Type().Implements(valueType) {
+		return v.Interface().(starlark.Value), nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		return starlarktime.Time(v.Interface().(time.Time)), nil
+	case durationType:
+		return starlarktime.Duration(v.Int()), nil
+	case bigIntType:
+		x := v.Interface().(big.Int)
+		return starlark.MakeBigInt(new(big.Int).Set(&x)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return starlark.Bool(v.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return starlark.MakeInt64(v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return starlark.MakeUint64(v.Uint()), nil
+
+	case reflect.Float32, reflect.Float64:
+		return starlark.Float(v.Float()), nil
+
+	case reflect.String:
+		return starlark.String(v.String()), nil
+
+	case reflect.Ptr:
+		if v.Type().Elem() == bigIntType {
+			return starlark.MakeBigInt(new(big.Int).Set(v.Interface().(*big.Int))), nil
+		}
+		if err := c.enter(v); err != nil {
+			return nil, err
+		}
+		defer c.leave(v)
+		return c.toValue(v.Elem())
+
+	case reflect.Interface:
+		return c.toValue(v.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem() == byteType {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return starlark.Bytes(b), nil
+		}
+		if v.Kind() == reflect.Slice && v.Len() > 0 {
+			if err := c.enter(v); err != nil {
+				return nil, err
+			}
+			defer c.leave(v)
+		}
+		elems := make([]starlark.Value, v.Len())
+		for i := range elems {
+			c.push(fmt.Sprintf("[%d]", i))
+			elem, err := c.toValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			c.pop()
+			elems[i] = elem
+		}
+		if v.Kind() == reflect.Array {
+			return starlark.Tuple(elems), nil
+		}
+		return starlark.NewList(elems), nil
+
+	case reflect.Map:
+		if err := c.enter(v); err != nil {
+			return nil, err
+		}
+		defer c.leave(v)
+		type entry struct {
+			k starlark.Value
+			v reflect.Value
+		}
+		var entries []entry
+		for _, k := range v.MapKeys() {
+			key, err := c.toValue(k)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{key, v.MapIndex(k)})
+		}
+		// Sort the entries so that the result is deterministic.
+		// Keys of different types are left in an arbitrary order.
+		sort.SliceStable(entries, func(i, j int) bool {
+			less, _ := starlark.Compare(syntax.LT, entries[i].k, entries[j].k)
+			return less
+		})
+		dict := new(starlark.Dict)
+		for _, e := range entries {
+			c.push("[" + e.k.String() + "]")
+			val, err := c.toValue(e.v)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(e.k, val); err != nil {
+				return nil, err
+			}
+			c.pop()
+		}
+		return dict, nil
+
+	case reflect.Struct:
+		members := make(starlark.StringDict)
+		for _, f := range fields(v.Type()) {
+			c.push("." + f.name)
+			val, err := c.toValue(v.FieldByIndex(f.index))
+			if err != nil {
+				return nil, err
+			}
+			c.pop()
+			members[f.name] = val
+		}
+		return starlarkstruct.FromStringDict(starlarkstruct.Default, members), nil
+	}
+
+	return nil, fmt.Errorf("cannot convert Go value of type %s to Starlark", v.Type())
+}
+
+func (c *converter) fromValue(x starlark.Value, v reflect.Value) error {
+	t := v.Type()
+
+	// A variable of type interface{} holds a natural Go representation of x.
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		y, err := c.goValue(x)
+		if err != nil {
+			return err
+		}
+		if y == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(y))
+		}
+		return nil
+	}
+
+	// Starlark values are stored unchanged in variables of suitable type.
+	if reflect.TypeOf(x).AssignableTo(t) {
+		v.Set(reflect.ValueOf(x))
+		return nil
+	}
+
+	// A wrapped struct is shared if the variable is a pointer, or copied.
+	if w, ok := x.(*Wrapper); ok {
+		if w.ptr.Type().AssignableTo(t) {
+			v.Set(w.ptr)
+			return nil
+		} else if w.ptr.Type().Elem().AssignableTo(t) {
+			v.Set(w.ptr.Elem())
+			return nil
+		}
+	}
+
+	switch t {
+	case timeType:
+		if x, ok := x.(starlarktime.Time); ok {
+			v.Set(reflect.ValueOf(time.Time(x)))
+			return nil
+		}
+		return typeError(x, t)
+	case durationType:
+		if x, ok := x.(starlarktime.Duration); ok {
+			v.SetInt(int64(x))
+			return nil
+		}
+		return typeError(x, t)
+	case bigIntType:
+		if x, ok := x.(starlark.Int); ok {
+			v.Set(reflect.ValueOf(new(big.Int).Set(x.BigInt())).Elem())
+			return nil
+		}
+		return typeError(x, t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if x, ok := x.(starlark.Bool); ok {
+			v.SetBool(bool(x))
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if x, ok := x.(starlark.Int); ok {
+			i, ok := x.Int64()
+			if !ok || v.OverflowInt(i) {
+				return fmt.Errorf("%s out of range for Go type %s", x, t)
+			}
+			v.SetInt(i)
+			return nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if x, ok := x.(starlark.Int); ok {
+			u, ok := x.Uint64()
+			if !ok || v.OverflowUint(u) {
+				return fmt.Errorf("%s out of range for Go type %s", x, t)
+			}
+			v.SetUint(u)
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if f, ok := starlark.AsFloat(x); ok {
+			v.SetFloat(f)
+			return nil
+		}
+
+	case reflect.String:
+		if x, ok := x.(starlark.String); ok {
+			v.SetString(string(x))
+			return nil
+		}
+
+	case reflect.Ptr:
+		if x == starlark.None {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		p := reflect.New(t.Elem())
+		if err := c.fromValue(x, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem() == byteType {
+			var b []byte
+			switch x := x.(type) {
+			case starlark.Bytes:
+				b = []byte(x)
+			case starlark.String:
+				b = []byte(x)
+			default:
+				return typeError(x, t)
+			}
+			if t.Kind() == reflect.Array && len(b) != t.Len() {
+				return fmt.Errorf("got %d bytes, want %d", len(b), t.Len())
+			}
+			if t.Kind() == reflect.Slice {
+				v.Set(reflect.MakeSlice(t, len(b), len(b)))
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+		iterable, ok := x.(starlark.Iterable)
+		n := starlark.Len(x)
+		if !ok || n < 0 {
+			break
+		}
+		if t.Kind() == reflect.Array {
+			if n != t.Len() {
+				return fmt.Errorf("got %s of length %d, want %d", x.Type(), n, t.Len())
+			}
+		} else {
+			v.Set(reflect.MakeSlice(t, n, n))
+		}
+		iter := iterable.Iterate()
+		defer iter.Done()
+		var elem starlark.Value
+		for i := 0; i < n && iter.Next(&elem); i++ {
+			c.push(fmt.Sprintf("[%d]", i))
+			if err := c.fromValue(elem, v.Index(i)); err != nil {
+				return err
+			}
+			c.pop()
+		}
+		return nil
+
+	case reflect.Map:
+		m := reflect.MakeMap(t)
+		err := c.forEachEntry(x, func(key, val starlark.Value) error {
+			kv := reflect.New(t.Key()).Elem()
+			if err := c.fromValue(key, kv); err != nil {
+				return err
+			}
+			vv := reflect.New(t.Elem()).Elem()
+			if err := c.fromValue(val, vv); err != nil {
+				return err
+			}
+			m.SetMapIndex(kv, vv)
+			return nil
+		})
+		if err == errNotMapping {
+			break
+		} else if err != nil {
+			return err
+		}
+		v.Set(m)
+		return nil
+
+	case reflect.Struct:
+		fs := fields(t)
+		err := c.forEachEntry(x, func(key, val starlark.Value) error {
+			name, ok := starlark.AsString(key)
+			if !ok {
+				return fmt.Errorf("got %s key, want string", key.Type())
+			}
+			for _, f := range fs {
+				if f.name == name {
+					return c.fromValue(val, v.FieldByIndex(f.index))
+				}
+			}
+			return fmt.Errorf("Go type %s has no field %s", t, name)
+		})
+		if err == errNotMapping {
+			break
+		}
+		return err
+	}
+
+	return typeError(x, t)
+}
+
+var errNotMapping = fmt.Errorf("not a mapping")
+
+// forEachEntry calls f for each field of a struct or entry of a dict,
+// extending the path for the duration of each call.
+// It returns errNotMapping if x is neither a struct nor a dict.
+func (c *converter) forEachEntry(x starlark.Value, f func(key, val starlark.Value) error) error {
+	switch x := x.(type) {
+	case *starlarkstruct.Struct:
+		for _, name := range x.AttrNames() {
+			val, err := x.Attr(name)
+			if err != nil {
+				return err
+			}
+			c.push("." + name)
+			if err := f(starlark.String(name), val); err != nil {
+				return err
+			}
+			c.pop()
+		}
+		return nil
+	case starlark.IterableMapping:
+		for _, item := range x.Items() {
+			c.push("[" + item[0].String() + "]")
+			if err := f(item[0], item[1]); err != nil {
+				return err
+			}
+			c.pop()
+		}
+		return nil
+	}
+	return errNotMapping
+}
+
+// goValue returns the natural Go representation of x.
+func (c *converter) goValue(x starlark.Value) (interface{}, error) {
+	switch x := x.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(x), nil
+	case starlark.Int:
+		if i, ok := x.Int64(); ok {
+			return i, nil
+		}
+		return new(big.Int).Set(x.BigInt()), nil
+	case starlark.Float:
+		return float64(x), nil
+	case starlark.String:
+		return string(x), nil
+	case starlark.Bytes:
+		return []byte(x), nil
+	case starlarktime.Time:
+		return time.Time(x), nil
+	case starlarktime.Duration:
+		return time.Duration(x), nil
+	case *Wrapper:
+		return x.ptr.Interface(), nil
+	case *starlark.List, starlark.Tuple, *starlark.Set:
+		var elems []interface{}
+		if err := c.fromValue(x, reflect.ValueOf(&elems).Elem()); err != nil {
+			return nil, err
+		}
+		return elems, nil
+	case *starlarkstruct.Struct:
+		var m map[string]interface{}
+		if err := c.fromValue(x, reflect.ValueOf(&m).Elem()); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case starlark.IterableMapping:
+		stringKeys := true
+		for _, item := range x.Items() {
+			if _, ok := item[0].(starlark.String); !ok {
+				stringKeys = false
+				break
+			}
+		}
+		if stringKeys {
+			var m map[string]interface{}
+			if err := c.fromValue(x, reflect.ValueOf(&m).Elem()); err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{})
+		err := c.forEachEntry(x, func(key, val starlark.Value) error {
+			k, err := c.goValue(key)
+			if err != nil {
+				return err
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return fmt.Errorf("cannot use %s as Go map key", key.Type())
+			}
+			v, err := c.goValue(val)
+			if err != nil {
+				return err
+			}
+			m[k] = v
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return x, nil
+}
+
+func typeError(x starlark.Value, t reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to Go type %s", x.Type(), t)
+}
+
+// A field describes a Go struct field visible to Starlark.
+type field struct {
+	name  string // Starlark name
+	index []int  // for reflect.Value.FieldByIndex
+}
+
+// fields returns the fields of struct type t that are visible to
+// Starlark, in declaration order.
+func fields(t reflect.Type) []field {
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("starlark")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct {
+			// Promote the fields of an embedded struct.
+			for _, f := range fields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fs = append(fs, f)
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		name := sf.Name
+		if tag != "" {
+			name = tag
+		}
+		fs = append(fs, field{name, []int{i}})
+	}
+	return fs
+}
@@ -0,0 +1,314 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlarkconv_test
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkconv"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/starlarktest"
+)
+
+type server struct {
+	Host    string `starlark:"host"`
+	Port    int    `starlark:"port"`
+	Secret  string `starlark:"-"`
+	private int
+}
+
+type common struct {
+	Name string `starlark:"name"`
+}
+
+type config struct {
+	common
+	Servers []server          `starlark:"servers"`
+	Primary *server           `starlark:"primary"`
+	Labels  map[string]string `starlark:"labels"`
+	Timeout time.Duration     `starlark:"timeout"`
+	Start   time.Time         `starlark:"start"`
+	Big     *big.Int          `starlark:"big"`
+	Data    []byte            `starlark:"data"`
+	Weights [2]float64        `starlark:"weights"`
+	Enabled bool
+}
+
+func (c *config) Address(i int) (string, error) {
+	if i < 0 || i >= len(c.Servers) {
+		return "", fmt.Errorf("no server %d", i)
+	}
+	s := c.Servers[i]
+	return fmt.Sprintf("%s:%d", s.Host, s.Port), nil
+}
+
+func (c *config) AddServer(host string, port int) {
+	c.Servers = append(c.Servers, server{Host: host, Port: port})
+}
+
+func (c *config) Sum(thread *starlark.Thread, xs ...int) (int, string) {
+	sum := 0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum, thread.Name
+}
+
+func TestToValue(t *testing.T) {
+	big100, _ := new(big.Int).SetString("100000000000000000000", 10)
+	for _, test := range []struct {
+		x    interface{}
+		want string
+	}{
+		{nil, `None`},
+		{true, `True`},
+		{-3, `-3`},
+		{uint8(255), `255`},
+		{uint64(1 << 63), `9223372036854775808`},
+		{1.5, `1.5`},
+		{"hi", `"hi"`},
+		{[]byte("hi"), `b"hi"`},
+		{[2]byte{'h', 'i'}, `b"hi"`},
+		{[]int{1, 2}, `[1, 2]`},
+		{[]int(nil), `[]`},
+		{[2]string{"a", "b"}, `("a", "b")`},
+		{map[string]int{"b": 2, "a": 1, "c": 3}, `{"a": 1, "b": 2, "c": 3}`},
+		{map[int]bool{3: true, 1: false}, `{1: False, 3: True}`},
+		{map[string]int(nil), `{}`},
+		{(*int)(nil), `None`},
+		{new(int), `0`},
+		{big100, `100000000000000000000`},
+		{*big100, `100000000000000000000`},
+		{90 * time.Second, `1m30s`},
+		{time.Date(2018, 6, 15, 12, 30, 0, 0, time.UTC), `2018-06-15 12:30:00 +0000 UTC`},
+		{[]interface{}{1, "a", nil}, `[1, "a", None]`},
+		{starlark.String("x"), `"x"`},
+		{server{Host: "h", Port: 80, Secret: "s"}, `struct(host = "h", port = 80)`},
+		{config{common: common{Name: "n"}, Weights: [2]float64{1.5, 2.5}}, `struct(Enabled = False, big = None, data = b"", labels = {}, name = "n", primary = None, servers = [], start = 0001-01-01 00:00:00 +0000 UTC, timeout = 0s, weights = (1.5, 2.5))`},
+	} {
+		got, err := starlarkconv.ToValue(test.x)
+		if err != nil {
+			t.Errorf("ToValue(%#v) failed: %v", test.x, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("ToValue(%#v) = %s, want %s", test.x, got, test.want)
+		}
+	}
+}
+
+func TestToValueErrors(t *testing.T) {
+	type cyclic struct {
+		Next *cyclic
+	}
+	loop := &cyclic{}
+	loop.Next = loop
+
+	for _, test := range []struct {
+		x    interface{}
+		want string
+	}{
+		{make(chan int), `cannot convert Go value of type chan int to Starlark`},
+		{map[string][]interface{}{"k": {1, func() {}}}, `at ["k"][1]: cannot convert Go value of type func() to Starlark`},
+		{loop, `at .Next: cycle in Go value of type *starlarkconv_test.cyclic`},
+	} {
+		_, err := starlarkconv.ToValue(test.x)
+		if err == nil {
+			t.Errorf("ToValue(%#v) succeeded, want error %q", test.x, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("ToValue(%#v) failed with %q, want %q", test.x, err, test.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	big100, _ := new(big.Int).SetString("100000000000000000000", 10)
+	in := config{
+		common:  common{Name: "prod"},
+		Servers: []server{{Host: "a", Port: 1}, {Host: "b", Port: 2}},
+		Primary: &server{Host: "p", Port: 3},
+		Labels:  map[string]string{"env": "prod"},
+		Timeout: time.Minute,
+		Start:   time.Date(2018, 6, 15, 12, 30, 0, 0, time.UTC),
+		Big:     big100,
+		Data:    []byte{0, 1, 255},
+		Weights: [2]float64{0.5, 1.5},
+		Enabled: true,
+	}
+	v, err := starlarkconv.ToValue(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out config
+	if err := starlarkconv.FromValue(v, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip: got %+v, want %+v", out, in)
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	eval := func(expr string) starlark.Value {
+		v, err := starlark.EvalOptions(&resolve.Options{Float: true, Set: true}, new(starlark.Thread), "<expr>", expr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	var i int8
+	if err := starlarkconv.FromValue(eval("-7"), &i); err != nil || i != -7 {
+		t.Errorf("FromValue(-7, int8) = %d, %v", i, err)
+	}
+
+	var f float32
+	if err := starlarkconv.FromValue(eval("2"), &f); err != nil || f != 2 {
+		t.Errorf("FromValue(2, float32) = %v, %v", f, err)
+	}
+
+	var s server
+	if err := starlarkconv.FromValue(eval(`{"host": "h", "port": 8}`), &s); err != nil || s != (server{Host: "h", Port: 8}) {
+		t.Errorf("FromValue(dict, server) = %+v, %v", s, err)
+	}
+
+	var m map[int][]string
+	if err := starlarkconv.FromValue(eval(`{1: ["a"], 2: ("b", "c")}`), &m); err != nil ||
+		!reflect.DeepEqual(m, map[int][]string{1: {"a"}, 2: {"b", "c"}}) {
+		t.Errorf("FromValue(dict, map) = %v, %v", m, err)
+	}
+
+	var x interface{}
+	if err := starlarkconv.FromValue(eval(`[None, True, 1, 1.5, "s", b"b", {"k": (1,)}, {1: 2}, set([3])]`), &x); err != nil {
+		t.Errorf("FromValue(list, interface{}) failed: %v", err)
+	} else if want := []interface{}{nil, true, int64(1), 1.5, "s", []byte("b"),
+		map[string]interface{}{"k": []interface{}{int64(1)}},
+		map[interface{}]interface{}{int64(1): int64(2)},
+		[]interface{}{int64(3)},
+	}; !reflect.DeepEqual(x, want) {
+		t.Errorf("FromValue(list, interface{}) = %#v, want %#v", x, want)
+	}
+
+	var v starlark.Value
+	if err := starlarkconv.FromValue(eval(`[1]`), &v); err != nil || v.String() != "[1]" {
+		t.Errorf("FromValue(list, starlark.Value) = %v, %v", v, err)
+	}
+
+	for _, test := range []struct {
+		expr   string
+		target interface{}
+		want   string
+	}{
+		{`"x"`, new(int), `cannot convert string to Go type int`},
+		{`300`, new(uint8), `300 out of range for Go type uint8`},
+		{`-1`, new(uint), `-1 out of range for Go type uint`},
+		{`[1, "a"]`, new([]int), `at [1]: cannot convert string to Go type int`},
+		{`[1, 2, 3]`, new([2]int), `got list of length 3, want 2`},
+		{`{"host": "h", "port": "80"}`, new(server), `at ["port"]: cannot convert string to Go type int`},
+		{`{"host": "h", "Secret": "s"}`, new(server), `at ["Secret"]: Go type starlarkconv_test.server has no field Secret`},
+		{`{1: "h"}`, new(server), `at [1]: got int key, want string`},
+		{`{"a": [None]}`, new(map[string][]int), `at ["a"][0]: cannot convert NoneType to Go type int`},
+		{`{(1,): 2}`, new(interface{}), `at [(1,)]: cannot use tuple as Go map key`},
+		{`1`, 0, `FromValue: target must be a non-nil pointer, got int`},
+	} {
+		err := starlarkconv.FromValue(eval(test.expr), test.target)
+		if err == nil {
+			t.Errorf("FromValue(%s, %T) succeeded, want error %q", test.expr, test.target, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("FromValue(%s, %T) failed with %q, want %q", test.expr, test.target, err, test.want)
+		}
+	}
+}
+
+func TestWrapper(t *testing.T) {
+	cfg := &config{
+		common:  common{Name: "dev"},
+		Servers: []server{{Host: "localhost", Port: 8080}},
+		Primary: &server{Host: "primary", Port: 80},
+	}
+	const src = `
+load("assert.star", "assert")
+
+assert.eq(type(cfg), "starlarkconv_test.config")
+assert.eq(str(cfg), "<starlarkconv_test.config>")
+assert.eq(dir(cfg), ["AddServer", "Address", "Enabled", "Sum", "big", "data", "labels", "name",
+                     "primary", "servers", "start", "timeout", "weights"])
+assert.eq(cfg.name, "dev")
+assert.eq(cfg.servers, [struct(host = "localhost", port = 8080)])
+assert.eq(cfg.Address(0), "localhost:8080")
+assert.fails(lambda: cfg.Address(1), "Address: no server 1")
+assert.fails(lambda: cfg.Address("0"), "Address: for parameter 1: cannot convert string to Go type int")
+assert.fails(lambda: cfg.Address(), "Address: got 0 arguments, want 1")
+assert.eq(cfg.Sum(1, 2, 3), (6, "test"))
+assert.eq(cfg.AddServer("remote", 9090), None)
+assert.eq(cfg.Address(1), "remote:9090")
+
+# Assignments update the Go struct.
+cfg.name = "prod"
+cfg.timeout = cfg.timeout
+cfg.labels = {"env": "prod"}
+cfg.primary.port = 443
+assert.eq(cfg.primary.port, 443)
+assert.eq(cfg.primary, cfg.primary)
+cfg.servers = cfg.servers + [{"host": "extra", "port": 1}]
+
+def set_name(x):
+    x.name = 1
+
+def set_unknown(x):
+    x.nonesuch = 1
+
+assert.fails(lambda: set_name(cfg), "at .name: cannot convert int to Go type string")
+assert.fails(lambda: set_unknown(cfg), "starlarkconv_test.config has no .nonesuch field")
+`
+	thread := &starlark.Thread{Name: "test", Load: load}
+	starlarktest.SetReporter(thread, t)
+	predeclared := starlark.StringDict{
+		"cfg":    starlarkconv.Wrap(cfg),
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	}
+	opts := &resolve.Options{Lambda: true, NestedDef: true}
+	if _, err := starlark.ExecFileOptions(opts, thread, "wrapper.star", src, predeclared); err != nil {
+		if err, ok := err.(*starlark.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+
+	if cfg.Name != "prod" || cfg.Primary.Port != 443 || cfg.Labels["env"] != "prod" {
+		t.Errorf("assignments did not update Go struct: %+v", cfg)
+	}
+	if got, want := len(cfg.Servers), 3; got != want {
+		t.Errorf("len(cfg.Servers) = %d, want %d", got, want)
+	}
+
+	// A frozen wrapper rejects assignments, including to nested structs.
+	w := starlarkconv.Wrap(cfg)
+	w.Freeze()
+	primary, _ := w.Attr("primary")
+	for _, err := range []error{
+		w.SetField("name", starlark.String("x")),
+		primary.(starlark.HasSetField).SetField("port", starlark.MakeInt(1)),
+	} {
+		if err == nil || !strings.Contains(err.Error(), "frozen") {
+			t.Errorf("assignment to frozen wrapper returned %v, want frozen error", err)
+		}
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	if module == "assert.star" {
+		return starlarktest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
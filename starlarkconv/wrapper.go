@@ -0,0 +1,202 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlarkconv
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// A Wrapper is a Starlark value that provides access to a Go struct
+// through a pointer to it.
+//
+// The fields of the struct are Starlark fields of the Wrapper: reading
+// a field converts its value using ToValue, and assigning to it
+// converts the new value using FromValue, updating the Go struct in
+// place. A field of struct type, or a non-nil pointer to a struct, is
+// itself presented as a Wrapper, so that an assignment such as
+// config.server.port = 80 also updates the Go struct.
+//
+// The exported methods of the pointer type are Starlark methods of the
+// Wrapper. A method's arguments are converted using FromValue, and its
+// results using ToValue: a method with no results returns None, and a
+// method with several results returns a tuple. If a method's last
+// result is an error, a non-nil error causes the Starlark call to fail.
+// If a method's first parameter is a *starlark.Thread, it receives the
+// calling thread. Methods accept only positional arguments.
+//
+// Freezing a Wrapper prevents assignments to its fields, but does not
+// prevent its methods from modifying the struct.
+type Wrapper struct {
+	ptr    reflect.Value // pointer to struct
+	fields []field
+	frozen bool
+}
+
+var (
+	_ starlark.HasAttrs    = (*Wrapper)(nil)
+	_ starlark.HasSetField = (*Wrapper)(nil)
+	_ starlark.Comparable  = (*Wrapper)(nil)
+)
+
+// Wrap returns a Wrapper for the struct to which ptr points.
+// It panics if ptr is not a non-nil pointer to a struct.
+func Wrap(ptr interface{}) *Wrapper {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("starlarkconv.Wrap: got %T, want non-nil pointer to struct", ptr))
+	}
+	return wrap(v, false)
+}
+
+func wrap(ptr reflect.Value, frozen bool) *Wrapper {
+	return &Wrapper{ptr: ptr, fields: fields(ptr.Type().Elem()), frozen: frozen}
+}
+
+// Ptr returns the pointer to the wrapped struct.
+func (w *Wrapper) Ptr() interface{} { return w.ptr.Interface() }
+
+func (w *Wrapper) String() string        { return fmt.Sprintf("<%s>", w.Type()) }
+func (w *Wrapper) Type() string          { return w.ptr.Type().Elem().String() }
+func (w *Wrapper) Freeze()               { w.frozen = true }
+func (w *Wrapper) Truth() starlark.Bool  { return true }
+func (w *Wrapper) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", w.Type()) }
+
+// CompareSameType reports whether two Wrappers refer to the same Go variable.
+func (x *Wrapper) CompareSameType(op syntax.Token, y_ starlark.Value, depth int) (bool, error) {
+	y := y_.(*Wrapper)
+	same := x.ptr.Type() == y.ptr.Type() && x.ptr.Pointer() == y.ptr.Pointer()
+	switch op {
+	case syntax.EQL:
+		return same, nil
+	case syntax.NEQ:
+		return !same, nil
+	}
+	return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
+}
+
+func (w *Wrapper) field(name string) (reflect.Value, bool) {
+	for _, f := range w.fields {
+		if f.name == name {
+			return w.ptr.Elem().FieldByIndex(f.index), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func (w *Wrapper) Attr(name string) (starlark.Value, error) {
+	if v, ok := w.field(name); ok {
+		switch {
+		case v.Kind() == reflect.Struct && isPlainStruct(v.Type()):
+			return wrap(v.Addr(), w.frozen), nil
+		case v.Kind() == reflect.Ptr && !v.IsNil() && isPlainStruct(v.Type().Elem()):
+			return wrap(v, w.frozen), nil
+		}
+		return ToValue(v.Interface())
+	}
+	if m := w.ptr.MethodByName(name); m.IsValid() {
+		return starlark.NewBuiltin(name, method(m)).BindReceiver(w), nil
+	}
+	return nil, nil // no such field or method
+}
+
+func (w *Wrapper) AttrNames() []string {
+	var names []string
+	for _, f := range w.fields {
+		names = append(names, f.name)
+	}
+	t := w.ptr.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		names = append(names, t.Method(i).Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (w *Wrapper) SetField(name string, val starlark.Value) error {
+	if w.frozen {
+		return fmt.Errorf("cannot set .%s field of frozen %s", name, w.Type())
+	}
+	v, ok := w.field(name)
+	if !ok {
+		return fmt.Errorf("%s has no .%s field", w.Type(), name)
+	}
+	c := converter{path: []string{"." + name}}
+	if err := c.fromValue(val, v); err != nil {
+		return c.wrap(err)
+	}
+	return nil
+}
+
+// isPlainStruct reports whether a value of struct type t
+// should be wrapped, as opposed to converted by ToValue.
+func isPlainStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && t != bigIntType
+}
+
+// method returns the implementation of a Starlark builtin
+// that calls the bound Go method m.
+func method(m reflect.Value) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+		}
+		t := m.Type()
+		var in []reflect.Value
+		params := t.NumIn()
+		if params > 0 && t.In(0) == threadType {
+			in = append(in, reflect.ValueOf(thread))
+			params--
+		}
+		if t.IsVariadic() {
+			if len(args) < params-1 {
+				return nil, fmt.Errorf("%s: got %d arguments, want at least %d", b.Name(), len(args), params-1)
+			}
+		} else if len(args) != params {
+			return nil, fmt.Errorf("%s: got %d arguments, want %d", b.Name(), len(args), params)
+		}
+		for i, arg := range args {
+			var pt reflect.Type
+			if j := len(in); t.IsVariadic() && j >= t.NumIn()-1 {
+				pt = t.In(t.NumIn() - 1).Elem()
+			} else {
+				pt = t.In(j)
+			}
+			p := reflect.New(pt).Elem()
+			var c converter
+			if err := c.fromValue(arg, p); err != nil {
+				return nil, fmt.Errorf("%s: for parameter %d: %v", b.Name(), i+1, c.wrap(err))
+			}
+			in = append(in, p)
+		}
+
+		out := m.Call(in)
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1].Interface(); err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			out = out[:n-1]
+		}
+		results := make(starlark.Tuple, len(out))
+		for i, v := range out {
+			res, err := ToValue(v.Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s: result %d: %v", b.Name(), i+1, err)
+			}
+			results[i] = res
+		}
+		switch len(results) {
+		case 0:
+			return starlark.None, nil
+		case 1:
+			return results[0], nil
+		}
+		return results, nil
+	}
+}
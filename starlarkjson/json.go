@@ -0,0 +1,213 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package starlarkjson defines utilities for converting Starlark values
+// to and from JSON strings. The most recent IETF standard for JSON is
+// https://www.ietf.org/rfc/rfc7159.txt.
+package starlarkjson // import "go.starlark.net/starlarkjson"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Module json is a Starlark module of JSON-related functions.
+//
+//	json = module(
+//	   encode,
+//	   decode,
+//	   indent,
+//	)
+//
+// def encode(x):
+//
+// The encode function accepts one required positional argument,
+// which it converts to JSON by cases:
+//   - None, True, and False are converted to null, true, and false, respectively.
+//   - Starlark int values, no matter how large, are encoded as decimal integers.
+//     Some decoders may not be able to decode very large integers.
+//   - Starlark float values are encoded using a decimal point or exponent,
+//     even if the value is an integer.
+//     It is an error to encode a non-finite floating-point value.
+//   - Starlark strings are encoded as JSON strings.
+//     Invalid UTF-8 sequences are replaced by U+FFFD.
+//   - Starlark bytes cannot be encoded, as JSON has no binary data type.
+//   - a Starlark IterableMapping (e.g. dict) is encoded as a JSON object.
+//     It is an error if any key is not a string.
+//   - any other Starlark Iterable (e.g. list, tuple) is encoded as a JSON array.
+//   - a Starlark HasAttrs (e.g. struct) is encoded as a JSON object.
+//
+// If an application-defined type implements the json.Marshaler interface
+// of the Go standard library, its MarshalJSON method is used instead.
+// Encoding any other value yields an error.
+// An error message for a value within a list, dict, or struct
+// indicates the path to that value, for example ["k"][2].x.
+//
+// def decode(x):
+//
+// The decode function accepts one positional parameter, a JSON string.
+// It returns the Starlark value that the string denotes.
+//   - Numbers are parsed as int or float, depending on whether they
+//     contain a decimal point.
+//   - JSON objects are parsed as new unfrozen Starlark dicts.
+//   - JSON arrays are parsed as new unfrozen Starlark lists.
+//
+// Decoding fails if x is not a valid JSON string.
+//
+// def indent(str, prefix="", indent="\t"):
+//
+// The indent function pretty-prints a valid JSON encoding,
+// and returns a string containing the indented form.
+// It accepts one required positional parameter, the JSON string,
+// and two optional string parameters, prefix and indent,
+// that specify a prefix of each new line, and the unit of indentation.
+var Module = &starlarkstruct.Module{
+	Name: "json",
+	Members: starlark.StringDict{
+		"encode": starlark.NewBuiltin("json.encode", encode),
+		"decode": starlark.NewBuiltin("json.decode", decode),
+		"indent": starlark.NewBuiltin("json.indent", indent),
+	},
+}
+
+func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := starlarkstruct.EncodeJSON(buf, x); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if err := thread.AddAllocs(int64(buf.Len())); err != nil {
+		return nil, err
+	}
+	return starlark.String(buf.String()), nil
+}
+
+func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	// The decoded value is roughly proportional in size to its encoding.
+	if err := thread.AddAllocs(int64(len(s))); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	x, err := decodeValue(dec)
+	if err == nil {
+		if _, err2 := dec.Token(); err2 != io.EOF {
+			err = fmt.Errorf("unexpected text after JSON value")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return x, nil
+}
+
+// decodeValue decodes the next JSON value from dec.
+func decodeValue(dec *json.Decoder) (starlark.Value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("unexpected end of JSON input")
+		}
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(tok), nil
+	case string:
+		return starlark.String(tok), nil
+	case json.Number:
+		return decodeNumber(string(tok))
+	case json.Delim:
+		switch tok {
+		case '[':
+			var elems []starlark.Value
+			for dec.More() {
+				elem, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, elem)
+			}
+			if _, err := dec.Token(); err != nil { // ']'
+				return nil, err
+			}
+			return starlark.NewList(elems), nil
+
+		case '{':
+			dict := new(starlark.Dict)
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				dict.SetKey(starlark.String(k.(string)), v) // can't fail
+			}
+			if _, err := dec.Token(); err != nil { // '}'
+				return nil, err
+			}
+			return dict, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", tok) // unreachable
+}
+
+// decodeNumber returns the int or float denoted by the JSON number s.
+func decodeNumber(s string) (starlark.Value, error) {
+	if strings.ContainsAny(s, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", s)
+		}
+		return starlark.Float(f), nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return starlark.MakeInt64(i), nil
+	}
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number: %s", s)
+	}
+	return starlark.MakeBigInt(i), nil
+}
+
+func indent(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	prefix, indent := "", "\t"
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"str", &s,
+		"prefix?", &prefix,
+		"indent?", &indent,
+	); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), prefix, indent); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if err := thread.AddAllocs(int64(buf.Len())); err != nil {
+		return nil, err
+	}
+	return starlark.String(buf.String()), nil
+}
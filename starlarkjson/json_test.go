@@ -0,0 +1,57 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlarkjson_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/starlarktest"
+)
+
+func Test(t *testing.T) {
+	testdata := starlarktest.DataFile("starlarkjson", ".")
+	thread := &starlark.Thread{Load: load}
+	starlarktest.SetReporter(thread, t)
+	filename := filepath.Join(testdata, "testdata/json.star")
+	predeclared := starlark.StringDict{
+		"json":   starlarkjson.Module,
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"point":  point{3, 4},
+	}
+	opts := &resolve.Options{Float: true, Lambda: true}
+	if _, err := starlark.ExecFileOptions(opts, thread, filename, nil, predeclared); err != nil {
+		if err, ok := err.(*starlark.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	if module == "assert.star" {
+		return starlarktest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
+
+// A point is an application-defined value with a custom JSON encoding.
+type point struct{ x, y int }
+
+func (p point) String() string        { return fmt.Sprintf("point(%d, %d)", p.x, p.y) }
+func (p point) Type() string          { return "point" }
+func (p point) Freeze()               {} // immutable
+func (p point) Truth() starlark.Bool  { return true }
+func (p point) Hash() (uint32, error) { return uint32(p.x ^ p.y), nil }
+
+func (p point) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%d,%d]", p.x, p.y)), nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package starlarkmath defines the Starlark 'math' module,
+// a collection of mathematical functions and constants.
+package starlarkmath // import "go.starlark.net/starlarkmath"
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Module math is a Starlark module of mathematical functions.
+// Its Members may also be returned directly by a Thread's Load
+// function, so that a program may write load("math", "sqrt").
+//
+// All functions accept both int and float arguments. An int is
+// converted to the nearest float; it is an error if the int is too
+// large to be represented as a finite float. Unlike Python, invalid
+// arguments to floating-point functions do not cause an error, but
+// yield nan or an infinity, following IEEE 754 and the Go math package.
+//
+// The ceil and floor functions, and the gcd function, compute exact
+// int results, no matter how large.
+//
+//	ceil(x)		the least int not less than x
+//	floor(x)	the greatest int not greater than x
+//	gcd(x, y)	the non-negative greatest common divisor of ints x and y
+//
+//	fabs(x)		absolute value of x, as a float
+//	copysign(x, y)	a float with the magnitude of x and the sign of y
+//	mod(x, y)	floating-point remainder of x/y, with the sign of x
+//	remainder(x, y)	IEEE 754 floating-point remainder of x/y
+//	round(x)	nearest integer to x, rounding half away from zero, as a float
+//
+//	sqrt(x)		square root of x
+//	pow(x, y)	x raised to the power y
+//	exp(x)		e raised to the power x
+//	log(x, base=e)	logarithm of x in the specified base
+//	hypot(x, y)	Euclidean norm, sqrt(x*x + y*y)
+//
+//	sin(x), cos(x), tan(x)		trigonometric functions (of radians)
+//	asin(x), acos(x), atan(x)	inverse trigonometric functions
+//	atan2(y, x)			arc tangent of y/x, using the signs of both
+//	sinh(x), cosh(x), tanh(x)	hyperbolic functions
+//	asinh(x), acosh(x), atanh(x)	inverse hyperbolic functions
+//	degrees(x)			converts radians to degrees
+//	radians(x)			converts degrees to radians
+//
+//	isnan(x)	reports whether x is nan
+//	isinf(x)	reports whether x is an infinity
+//	isfinite(x)	reports whether x is neither nan nor an infinity
+//
+//	e		the base of natural logarithms
+//	pi		the ratio of a circle's circumference to its diameter
+//	inf		positive infinity
+//	nan		not a number
+var Module = &starlarkstruct.Module{
+	Name: "math",
+	Members: starlark.StringDict{
+		"ceil":  starlark.NewBuiltin("math.ceil", ceil),
+		"floor": starlark.NewBuiltin("math.floor", floor),
+		"gcd":   starlark.NewBuiltin("math.gcd", gcd),
+
+		"fabs":      newUnaryBuiltin("fabs", math.Abs),
+		"copysign":  newBinaryBuiltin("copysign", math.Copysign),
+		"mod":       newBinaryBuiltin("mod", math.Mod),
+		"remainder": newBinaryBuiltin("remainder", math.Remainder),
+		"round":     newUnaryBuiltin("round", math.Round),
+
+		"sqrt":  newUnaryBuiltin("sqrt", math.Sqrt),
+		"pow":   newBinaryBuiltin("pow", math.Pow),
+		"exp":   newUnaryBuiltin("exp", math.Exp),
+		"log":   starlark.NewBuiltin("math.log", log),
+		"hypot": newBinaryBuiltin("hypot", math.Hypot),
+
+		"sin":     newUnaryBuiltin("sin", math.Sin),
+		"cos":     newUnaryBuiltin("cos", math.Cos),
+		"tan":     newUnaryBuiltin("tan", math.Tan),
+		"asin":    newUnaryBuiltin("asin", math.Asin),
+		"acos":    newUnaryBuiltin("acos", math.Acos),
+		"atan":    newUnaryBuiltin("atan", math.Atan),
+		"atan2":   newBinaryBuiltin("atan2", math.Atan2),
+		"sinh":    newUnaryBuiltin("sinh", math.Sinh),
+		"cosh":    newUnaryBuiltin("cosh", math.Cosh),
+		"tanh":    newUnaryBuiltin("tanh", math.Tanh),
+		"asinh":   newUnaryBuiltin("asinh", math.Asinh),
+		"acosh":   newUnaryBuiltin("acosh", math.Acosh),
+		"atanh":   newUnaryBuiltin("atanh", math.Atanh),
+		"degrees": newUnaryBuiltin("degrees", degrees),
+		"radians": newUnaryBuiltin("radians", radians),
+
+		"isnan":    newPredicateBuiltin("isnan", func(x float64) bool { return math.IsNaN(x) }),
+		"isinf":    newPredicateBuiltin("isinf", func(x float64) bool { return math.IsInf(x, 0) }),
+		"isfinite": newPredicateBuiltin("isfinite", isfinite),
+
+		"e":   starlark.Float(math.E),
+		"pi":  starlark.Float(math.Pi),
+		"inf": starlark.Float(math.Inf(+1)),
+		"nan": starlark.Float(math.NaN()),
+	},
+}
+
+// newUnaryBuiltin wraps a unary floating-point Go function
+// as a Starlark built-in that accepts int or float.
+func newUnaryBuiltin(name string, fn func(float64) float64) *starlark.Builtin {
+	return starlark.NewBuiltin("math."+name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		f, err := toFloat(b.Name(), x)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(fn(f)), nil
+	})
+}
+
+// newBinaryBuiltin wraps a binary floating-point Go function
+// as a Starlark built-in that accepts int or float.
+func newBinaryBuiltin(name string, fn func(float64, float64) float64) *starlark.Builtin {
+	return starlark.NewBuiltin("math."+name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x, y starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &y); err != nil {
+			return nil, err
+		}
+		fx, err := toFloat(b.Name(), x)
+		if err != nil {
+			return nil, err
+		}
+		fy, err := toFloat(b.Name(), y)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(fn(fx, fy)), nil
+	})
+}
+
+// newPredicateBuiltin wraps a floating-point Go predicate
+// as a Starlark built-in that accepts int or float.
+func newPredicateBuiltin(name string, fn func(float64) bool) *starlark.Builtin {
+	return starlark.NewBuiltin("math."+name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		if _, ok := x.(starlark.Int); ok {
+			// Every int, even one too large for a float,
+			// is finite, so it behaves like zero.
+			return starlark.Bool(fn(0)), nil
+		}
+		f, err := toFloat(b.Name(), x)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Bool(fn(f)), nil
+	})
+}
+
+// toFloat converts an int or float argument to a float64.
+// An int too large to be represented as a finite float is an error.
+func toFloat(fnname string, x starlark.Value) (float64, error) {
+	switch x := x.(type) {
+	case starlark.Float:
+		return float64(x), nil
+	case starlark.Int:
+		f := float64(x.Float())
+		if math.IsInf(f, 0) {
+			return 0, fmt.Errorf("%s: int too large to convert to float", fnname)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%s: got %s, want float or int", fnname, x.Type())
+}
+
+func ceil(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return rounding(b.Name(), args, kwargs, math.Ceil)
+}
+
+func floor(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return rounding(b.Name(), args, kwargs, math.Floor)
+}
+
+// rounding is the common implementation of ceil and floor.
+// An int argument is returned unchanged; a float is rounded by fn
+// and converted exactly to an int.
+func rounding(fnname string, args starlark.Tuple, kwargs []starlark.Tuple, fn func(float64) float64) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(fnname, args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case starlark.Int:
+		return x, nil
+	case starlark.Float:
+		i, err := starlark.NumberToInt(starlark.Float(fn(float64(x))))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fnname, err)
+		}
+		return i, nil
+	}
+	return nil, fmt.Errorf("%s: got %s, want float or int", fnname, x.Type())
+}
+
+func gcd(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y starlark.Int
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &y); err != nil {
+		return nil, err
+	}
+	// big.Int.GCD requires positive operands.
+	a := new(big.Int).Abs(x.BigInt())
+	c := new(big.Int).Abs(y.BigInt())
+	switch {
+	case a.Sign() == 0:
+		return starlark.MakeBigInt(c), nil
+	case c.Sign() == 0:
+		return starlark.MakeBigInt(a), nil
+	}
+	return starlark.MakeBigInt(new(big.Int).GCD(nil, nil, a, c)), nil
+}
+
+func log(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, base starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "base?", &base); err != nil {
+		return nil, err
+	}
+	fx, err := toFloat(b.Name(), x)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return starlark.Float(math.Log(fx)), nil
+	}
+	fbase, err := toFloat(b.Name(), base)
+	if err != nil {
+		return nil, err
+	}
+	switch fbase {
+	case 2:
+		return starlark.Float(math.Log2(fx)), nil
+	case 10:
+		return starlark.Float(math.Log10(fx)), nil
+	}
+	return starlark.Float(math.Log(fx) / math.Log(fbase)), nil
+}
+
+func degrees(x float64) float64 { return x * 180 / math.Pi }
+func radians(x float64) float64 { return x * math.Pi / 180 }
+func isfinite(x float64) bool   { return !math.IsInf(x, 0) && !math.IsNaN(x) }
@@ -0,0 +1,44 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlarkmath_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkmath"
+	"go.starlark.net/starlarktest"
+)
+
+func Test(t *testing.T) {
+	testdata := starlarktest.DataFile("starlarkmath", ".")
+	thread := &starlark.Thread{Load: load}
+	starlarktest.SetReporter(thread, t)
+	filename := filepath.Join(testdata, "testdata/math.star")
+	predeclared := starlark.StringDict{
+		"math": starlarkmath.Module,
+	}
+	opts := &resolve.Options{Float: true, Lambda: true, Bitwise: true}
+	if _, err := starlark.ExecFileOptions(opts, thread, filename, nil, predeclared); err != nil {
+		if err, ok := err.(*starlark.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	switch module {
+	case "assert.star":
+		return starlarktest.LoadAssertModule()
+	case "math":
+		return starlarkmath.Module.Members, nil
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
@@ -25,7 +25,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
+	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
@@ -226,25 +230,20 @@ func (s *Struct) Attr(name string) (starlark.Value, error) {
 		return s.entries[i].value, nil
 	}
 
-	// TODO(adonovan): there may be a nice feature for core
-	// starlark.Value here, especially for JSON, but the current
-	// features are incomplete and underspecified.
-	//
 	// to_{json,proto} are deprecated, appropriately; see Google issue b/36412967.
+	// The json module (go.starlark.net/starlarkjson) supersedes to_json.
 	switch name {
 	case "to_json", "to_proto":
 		return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 			var buf bytes.Buffer
 			var err error
 			if name == "to_json" {
-				err = writeJSON(&buf, s)
+				err = writeJSON(&buf, s, ", ", ": ")
 			} else {
 				err = writeProtoStruct(&buf, 0, s)
 			}
 			if err != nil {
-				// TODO(adonovan): improve error error messages
-				// to show the path through the object graph.
-				return nil, err
+				return nil, fmt.Errorf("%s: %v", name, err)
 			}
 			return starlark.String(buf.String()), nil
 		}), nil
@@ -315,71 +314,183 @@ func writeProtoField(out *bytes.Buffer, depth int, field string, v starlark.Valu
 	return nil
 }
 
-// writeJSON writes the JSON representation of a Starlark value to out.
-// TODO(adonovan): there may be a nice feature for core starlark.Value here,
-// but the current feature is incomplete and underspecified.
-func writeJSON(out *bytes.Buffer, v starlark.Value) error {
-	switch v := v.(type) {
+// EncodeJSON appends the JSON encoding of x to out. It is the encoder
+// of the encode function of the json module (go.starlark.net/starlarkjson),
+// whose documentation describes the encoding of each type of value.
+// If x cannot be encoded, the error indicates the path to the offending
+// value, for example "at ["k"][2].x: cannot encode function as JSON".
+func EncodeJSON(out *bytes.Buffer, x starlark.Value) error {
+	return writeJSON(out, x, ",", ":")
+}
+
+// writeJSON writes the JSON encoding of x to out, using the specified
+// separators between elements, and between keys and values.
+// The deprecated to_json method uses ", " and ": ".
+func writeJSON(out *bytes.Buffer, x starlark.Value, comma, colon string) error {
+	e := jsonEncoder{out: out, comma: comma, colon: colon, seen: make(map[starlark.Value]bool)}
+	if err := e.encode(x); err != nil {
+		if len(e.path) > 0 {
+			return fmt.Errorf("at %s: %v", strings.Join(e.path, ""), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// A jsonEncoder holds the state of a call to writeJSON.
+type jsonEncoder struct {
+	out          *bytes.Buffer
+	comma, colon string
+	path         []string                // path from the root to the current value, e.g. ["k"], [2], .x
+	seen         map[starlark.Value]bool // reference values on the path, for cycle detection
+}
+
+// encode appends the encoding of x to e.out.
+// When it returns an error, e.path indicates the offending value.
+func (e *jsonEncoder) encode(x starlark.Value) error {
+	// Detect cycles by recording each reference value
+	// (list, dict, struct) on the path from the root.
+	if reflect.ValueOf(x).Kind() == reflect.Ptr {
+		if e.seen[x] {
+			return fmt.Errorf("cycle in JSON structure")
+		}
+		e.seen[x] = true
+		defer delete(e.seen, x)
+	}
+
+	out := e.out
+	switch x := x.(type) {
+	case json.Marshaler:
+		// Application-defined starlark.Value types
+		// may define their own JSON encoding.
+		data, err := x.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("%s.MarshalJSON returned invalid JSON", x.(starlark.Value).Type())
+		}
+		out.Write(data)
+
 	case starlark.NoneType:
 		out.WriteString("null")
+
 	case starlark.Bool:
-		fmt.Fprintf(out, "%t", v)
+		fmt.Fprintf(out, "%t", x)
+
 	case starlark.Int:
-		// TODO(adonovan): test large numbers.
-		out.WriteString(v.String())
+		out.WriteString(x.String())
+
 	case starlark.Float:
-		// TODO(adonovan): test.
-		fmt.Fprintf(out, "%g", v)
+		f := float64(x)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("cannot encode non-finite float %v", x)
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		out.WriteString(s)
+		if !strings.ContainsAny(s, ".e") {
+			out.WriteString(".0") // preserve float-ness of integral values
+		}
+
 	case starlark.String:
-		s := string(v)
-		if goQuoteIsSafe(s) {
-			fmt.Fprintf(out, "%q", s)
-		} else {
-			// vanishingly rare for text strings
-			data, _ := json.Marshal(s)
-			out.Write(data)
+		e.quote(string(x))
+
+	case starlark.Bytes:
+		// JSON has no representation of binary data.
+		return fmt.Errorf("cannot encode bytes as JSON")
+
+	case starlark.IterableMapping:
+		// e.g. dict (must have string keys)
+		out.WriteByte('{')
+		for i, item := range x.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return fmt.Errorf("%s has %s key, want string", x.Type(), item[0].Type())
+			}
+			if i > 0 {
+				out.WriteString(e.comma)
+			}
+			e.quote(k)
+			out.WriteString(e.colon)
+			e.path = append(e.path, "["+strconv.Quote(k)+"]")
+			if err := e.encode(item[1]); err != nil {
+				return err
+			}
+			e.path = e.path[:len(e.path)-1]
 		}
-	case starlark.Indexable: // Tuple, List
+		out.WriteByte('}')
+
+	case starlark.Iterable:
+		// e.g. tuple, list
 		out.WriteByte('[')
-		for i, n := 0, starlark.Len(v); i < n; i++ {
+		iter := x.Iterate()
+		defer iter.Done()
+		var elem starlark.Value
+		for i := 0; iter.Next(&elem); i++ {
 			if i > 0 {
-				out.WriteString(", ")
+				out.WriteString(e.comma)
 			}
-			if err := writeJSON(out, v.Index(i)); err != nil {
+			e.path = append(e.path, fmt.Sprintf("[%d]", i))
+			if err := e.encode(elem); err != nil {
 				return err
 			}
+			e.path = e.path[:len(e.path)-1]
 		}
 		out.WriteByte(']')
-	case *Struct:
+
+	case starlark.HasAttrs:
+		// e.g. struct
 		out.WriteByte('{')
-		for i, e := range v.entries {
-			if i > 0 {
-				out.WriteString(", ")
+		for i, name := range x.AttrNames() {
+			v, err := x.Attr(name)
+			if err != nil || v == nil {
+				return fmt.Errorf("%s.Attr(%s) failed: %v", x.Type(), name, err)
 			}
-			if err := writeJSON(out, starlark.String(e.name)); err != nil {
-				return err
+			if i > 0 {
+				out.WriteString(e.comma)
 			}
-			out.WriteString(": ")
-			if err := writeJSON(out, e.value); err != nil {
+			e.quote(name)
+			out.WriteString(e.colon)
+			e.path = append(e.path, "."+name)
+			if err := e.encode(v); err != nil {
 				return err
 			}
+			e.path = e.path[:len(e.path)-1]
 		}
 		out.WriteByte('}')
+
 	default:
-		return fmt.Errorf("cannot convert %s to JSON", v.Type())
+		return fmt.Errorf("cannot encode %s as JSON", x.Type())
 	}
 	return nil
 }
 
-func goQuoteIsSafe(s string) bool {
+// quote appends the JSON string literal for s to e.out.
+// Invalid UTF-8 sequences are replaced by U+FFFD.
+func (e *jsonEncoder) quote(s string) {
+	out := e.out
+	out.WriteByte('"')
 	for _, r := range s {
-		// JSON doesn't like Go's \xHH escapes for ASCII control codes,
-		// nor its \UHHHHHHHH escapes for runes >16 bits.
-		if r < 0x20 || r >= 0x10000 {
-			return false
+		switch r {
+		case '"', '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				// JSON doesn't permit Go's \xHH escapes for ASCII control codes.
+				fmt.Fprintf(out, `\u%04x`, r)
+			} else {
+				out.WriteRune(r)
+			}
 		}
 	}
-	return true
+	out.WriteByte('"')
 }
 
 func (s *Struct) len() int { return len(s.entries) }
@@ -0,0 +1,533 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package starlarktime defines the Starlark 'time' module,
+// which provides time and duration values.
+package starlarktime // import "go.starlark.net/starlarktime"
+
+import (
+	"archive/zip"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// Module time is a Starlark module of time-related functions and
+// constants. It defines two data types, time.time and time.duration,
+// which support comparison and arithmetic:
+//
+//	time - time		-> duration
+//	time + duration		-> time
+//	time - duration		-> time
+//	duration + duration	-> duration
+//	duration - duration	-> duration
+//	duration * int		-> duration
+//	duration / int		-> duration
+//	duration / duration	-> float
+//	duration // duration	-> int
+//
+// The module's functions are:
+//
+//	now()
+//		Returns the current time, according to the clock of the
+//		thread (see SetNow).
+//	time(year=1970, month=1, day=1, hour=0, minute=0, second=0, nanosecond=0, location="UTC")
+//		Returns the time denoted by the specified fields.
+//	from_timestamp(sec, nsec=0)
+//		Returns the time denoted by the specified Unix time.
+//	parse_time(x, format="2006-01-02T15:04:05Z07:00", location="UTC")
+//		Parses a time using a Go layout string, such as time.RFC3339.
+//	parse_duration(d)
+//		Parses a duration string such as "1h30m" or "-1.5s".
+//	is_valid_timezone(name)
+//		Reports whether name is a valid IANA time zone, such as "Europe/Paris".
+//
+// and the constants nanosecond, microsecond, millisecond, second,
+// minute, and hour are durations.
+//
+// Time zones are identified by IANA names such as "Europe/Paris". The
+// name "Local", for the host's time zone, is not supported. Zones are
+// loaded only from a copy of the time zone database embedded in the
+// executable, never from the host, so every host knows the same zones
+// and rules.
+var Module = &starlarkstruct.Module{
+	Name: "time",
+	Members: starlark.StringDict{
+		"now":               starlark.NewBuiltin("time.now", now),
+		"time":              starlark.NewBuiltin("time.time", newTime),
+		"from_timestamp":    starlark.NewBuiltin("time.from_timestamp", fromTimestamp),
+		"parse_time":        starlark.NewBuiltin("time.parse_time", parseTime),
+		"parse_duration":    starlark.NewBuiltin("time.parse_duration", parseDuration),
+		"is_valid_timezone": starlark.NewBuiltin("time.is_valid_timezone", isValidTimezone),
+
+		"nanosecond":  Duration(time.Nanosecond),
+		"microsecond": Duration(time.Microsecond),
+		"millisecond": Duration(time.Millisecond),
+		"second":      Duration(time.Second),
+		"minute":      Duration(time.Minute),
+		"hour":        Duration(time.Hour),
+	},
+}
+
+// nowKey is the thread-local key for the clock function; see SetNow.
+const nowKey = "go.starlark.net/starlarktime.now"
+
+// SetNow sets the clock used by time.now() in the specified thread.
+// Hosts may use it to make a computation deterministic, for example
+// in tests. By default, time.now() reports the time of the host's
+// clock. SetNow must not be called after execution begins.
+func SetNow(thread *starlark.Thread, now func() time.Time) {
+	thread.SetLocal(nowKey, now)
+}
+
+func now(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	clock, ok := thread.Local(nowKey).(func() time.Time)
+	if !ok {
+		clock = time.Now
+	}
+	return Time(clock()), nil
+}
+
+func newTime(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	year, month, day := 1970, 1, 1
+	var hour, minute, second, nanosecond int
+	location := "UTC"
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"year?", &year,
+		"month?", &month,
+		"day?", &day,
+		"hour?", &hour,
+		"minute?", &minute,
+		"second?", &second,
+		"nanosecond?", &nanosecond,
+		"location?", &location,
+	); err != nil {
+		return nil, err
+	}
+	loc, err := loadLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return Time(time.Date(year, time.Month(month), day, hour, minute, second, nanosecond, loc)), nil
+}
+
+func fromTimestamp(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var sec starlark.Int
+	nsec := starlark.MakeInt(0)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &sec, &nsec); err != nil {
+		return nil, err
+	}
+	s, ok := sec.Int64()
+	if !ok {
+		return nil, fmt.Errorf("%s: sec out of range", b.Name())
+	}
+	ns, ok := nsec.Int64()
+	if !ok {
+		return nil, fmt.Errorf("%s: nsec out of range", b.Name())
+	}
+	return Time(time.Unix(s, ns).UTC()), nil
+}
+
+func parseTime(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x string
+	format, location := time.RFC3339, "UTC"
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"x", &x,
+		"format?", &format,
+		"location?", &location,
+	); err != nil {
+		return nil, err
+	}
+	loc, err := loadLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	t, err := time.ParseInLocation(format, x, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return Time(t), nil
+}
+
+func parseDuration(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case Duration:
+		return x, nil
+	case starlark.String:
+		d, err := time.ParseDuration(string(x))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		return Duration(d), nil
+	}
+	return nil, fmt.Errorf("%s: got %s, want string or duration", b.Name(), x.Type())
+}
+
+func isValidTimezone(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name); err != nil {
+		return nil, err
+	}
+	_, err := loadLocation(name)
+	return starlark.Bool(err == nil), nil
+}
+
+// zoneinfo is the time zone database: a copy of
+// $GOROOT/lib/time/zoneinfo.zip, which maps each zone name
+// to the zone's data in the format of a tzfile(5).
+//
+//go:embed zoneinfo.zip
+var zoneinfo []byte
+
+var zones struct {
+	once  sync.Once
+	files map[string]*zip.File // entries of zoneinfo, by name
+	err   error
+
+	mu    sync.Mutex
+	cache map[string]*time.Location
+}
+
+// loadLocation returns the time zone with the specified name.
+// Unlike time.LoadLocation, it consults only the embedded database,
+// and it rejects "Local", whose meaning depends on the host.
+func loadLocation(name string) (*time.Location, error) {
+	switch name {
+	case "", "UTC":
+		return time.UTC, nil
+	case "Local":
+		return nil, fmt.Errorf("unsupported time zone %s", name)
+	}
+
+	zones.once.Do(func() {
+		r, err := zip.NewReader(bytes.NewReader(zoneinfo), int64(len(zoneinfo)))
+		if err != nil {
+			zones.err = fmt.Errorf("reading time zone database: %v", err)
+			return
+		}
+		zones.files = make(map[string]*zip.File)
+		for _, f := range r.File {
+			zones.files[f.Name] = f
+		}
+		zones.cache = make(map[string]*time.Location)
+	})
+	if zones.err != nil {
+		return nil, zones.err
+	}
+
+	zones.mu.Lock()
+	defer zones.mu.Unlock()
+	if loc, ok := zones.cache[name]; ok {
+		return loc, nil
+	}
+	f, ok := zones.files[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown time zone %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocationFromTZData(name, data)
+	if err != nil {
+		return nil, err
+	}
+	zones.cache[name] = loc
+	return loc, nil
+}
+
+// A Duration is a Starlark value that represents the elapsed time
+// between two instants, as an int64 nanosecond count.
+type Duration time.Duration
+
+var (
+	_ starlark.Comparable = Duration(0)
+	_ starlark.HasBinary  = Duration(0)
+	_ starlark.HasAttrs   = Duration(0)
+)
+
+func (d Duration) String() string        { return time.Duration(d).String() }
+func (d Duration) Type() string          { return "time.duration" }
+func (d Duration) Freeze()               {} // immutable
+func (d Duration) Truth() starlark.Bool  { return d != 0 }
+func (d Duration) Hash() (uint32, error) { return uint32(d) ^ uint32(int64(d)>>32), nil }
+
+func (d Duration) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	return threeway(op, cmp(int64(d), int64(y.(Duration)))), nil
+}
+
+var durationAttrs = map[string]func(d time.Duration) starlark.Value{
+	"hours":        func(d time.Duration) starlark.Value { return starlark.Float(d.Hours()) },
+	"minutes":      func(d time.Duration) starlark.Value { return starlark.Float(d.Minutes()) },
+	"seconds":      func(d time.Duration) starlark.Value { return starlark.Float(d.Seconds()) },
+	"milliseconds": func(d time.Duration) starlark.Value { return starlark.MakeInt64(int64(d) / 1e6) },
+	"microseconds": func(d time.Duration) starlark.Value { return starlark.MakeInt64(int64(d) / 1e3) },
+	"nanoseconds":  func(d time.Duration) starlark.Value { return starlark.MakeInt64(int64(d)) },
+}
+
+func (d Duration) Attr(name string) (starlark.Value, error) {
+	if f, ok := durationAttrs[name]; ok {
+		return f(time.Duration(d)), nil
+	}
+	return nil, nil
+}
+
+func (d Duration) AttrNames() []string {
+	names := make([]string, 0, len(durationAttrs))
+	for name := range durationAttrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d Duration) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	x := time.Duration(d)
+	switch op {
+	case syntax.PLUS:
+		switch y := y.(type) {
+		case Duration:
+			return Duration(x + time.Duration(y)), nil
+		case Time:
+			return Time(time.Time(y).Add(x)), nil // duration + time
+		}
+
+	case syntax.MINUS:
+		if y, ok := y.(Duration); ok {
+			if side == starlark.Right {
+				return Duration(time.Duration(y) - x), nil
+			}
+			return Duration(x - time.Duration(y)), nil
+		}
+
+	case syntax.STAR:
+		if y, ok := y.(starlark.Int); ok {
+			n, ok := y.Int64()
+			if !ok {
+				return nil, fmt.Errorf("duration multiplier out of range")
+			}
+			return Duration(x * time.Duration(n)), nil
+		}
+
+	case syntax.SLASH:
+		switch y := y.(type) {
+		case Duration:
+			num, den := x, time.Duration(y)
+			if side == starlark.Right {
+				num, den = den, num
+			}
+			if den == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return starlark.Float(float64(num) / float64(den)), nil
+		case starlark.Int:
+			if side == starlark.Right {
+				return nil, nil // int / duration is undefined
+			}
+			n, ok := y.Int64()
+			if !ok {
+				return nil, fmt.Errorf("duration divisor out of range")
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return Duration(x / time.Duration(n)), nil
+		}
+
+	case syntax.SLASHSLASH:
+		if y, ok := y.(Duration); ok {
+			num, den := x, time.Duration(y)
+			if side == starlark.Right {
+				num, den = den, num
+			}
+			if den == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return starlark.MakeInt64(floorDiv(int64(num), int64(den))), nil
+		}
+	}
+	return nil, nil // unhandled
+}
+
+// floorDiv returns x/y rounded towards negative infinity.
+func floorDiv(x, y int64) int64 {
+	q := x / y
+	if (x%y != 0) && ((x < 0) != (y < 0)) {
+		q--
+	}
+	return q
+}
+
+// A Time is a Starlark value that represents an instant in time
+// with nanosecond precision, and an associated location.
+type Time time.Time
+
+var (
+	_ starlark.Comparable = Time{}
+	_ starlark.HasBinary  = Time{}
+	_ starlark.HasAttrs   = Time{}
+)
+
+func (t Time) String() string {
+	return time.Time(t).Format("2006-01-02 15:04:05.999999999 -0700 MST")
+}
+func (t Time) Type() string         { return "time.time" }
+func (t Time) Freeze()              {} // immutable
+func (t Time) Truth() starlark.Bool { return starlark.Bool(!time.Time(t).IsZero()) }
+func (t Time) Hash() (uint32, error) {
+	// Equal instants in different locations must have the same hash.
+	u := time.Time(t).UnixNano()
+	return uint32(u) ^ uint32(u>>32), nil
+}
+
+func (t Time) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	x, yt := time.Time(t), time.Time(y.(Time))
+	c := 0
+	if x.Before(yt) {
+		c = -1
+	} else if x.After(yt) {
+		c = +1
+	}
+	return threeway(op, c), nil
+}
+
+var timeAttrs = map[string]func(t time.Time) starlark.Value{
+	"year":       func(t time.Time) starlark.Value { return starlark.MakeInt(t.Year()) },
+	"month":      func(t time.Time) starlark.Value { return starlark.MakeInt(int(t.Month())) },
+	"day":        func(t time.Time) starlark.Value { return starlark.MakeInt(t.Day()) },
+	"hour":       func(t time.Time) starlark.Value { return starlark.MakeInt(t.Hour()) },
+	"minute":     func(t time.Time) starlark.Value { return starlark.MakeInt(t.Minute()) },
+	"second":     func(t time.Time) starlark.Value { return starlark.MakeInt(t.Second()) },
+	"nanosecond": func(t time.Time) starlark.Value { return starlark.MakeInt(t.Nanosecond()) },
+	"weekday":    func(t time.Time) starlark.Value { return starlark.MakeInt(int(t.Weekday())) },
+	"unix":       func(t time.Time) starlark.Value { return starlark.MakeInt64(t.Unix()) },
+	"unix_nano":  func(t time.Time) starlark.Value { return starlark.MakeInt64(t.UnixNano()) },
+	"location":   func(t time.Time) starlark.Value { return starlark.String(t.Location().String()) },
+}
+
+var timeMethods = map[string]func(fnname string, t time.Time, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error){
+	"format":      timeFormat,
+	"in_location": timeInLocation,
+}
+
+func (t Time) Attr(name string) (starlark.Value, error) {
+	if f, ok := timeAttrs[name]; ok {
+		return f(time.Time(t)), nil
+	}
+	if method, ok := timeMethods[name]; ok {
+		return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return method(name, time.Time(t), args, kwargs)
+		}), nil
+	}
+	return nil, nil
+}
+
+func (t Time) AttrNames() []string {
+	names := make([]string, 0, len(timeAttrs)+len(timeMethods))
+	for name := range timeAttrs {
+		names = append(names, name)
+	}
+	for name := range timeMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// https://golang.org/pkg/time/#Time.Format
+func timeFormat(fnname string, t time.Time, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var layout string
+	if err := starlark.UnpackPositionalArgs(fnname, args, kwargs, 1, &layout); err != nil {
+		return nil, err
+	}
+	return starlark.String(t.Format(layout)), nil
+}
+
+// https://golang.org/pkg/time/#Time.In
+func timeInLocation(fnname string, t time.Time, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var location string
+	if err := starlark.UnpackPositionalArgs(fnname, args, kwargs, 1, &location); err != nil {
+		return nil, err
+	}
+	loc, err := loadLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fnname, err)
+	}
+	return Time(t.In(loc)), nil
+}
+
+func (t Time) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	x := time.Time(t)
+	switch op {
+	case syntax.PLUS:
+		if y, ok := y.(Duration); ok {
+			return Time(x.Add(time.Duration(y))), nil // time + duration
+		}
+
+	case syntax.MINUS:
+		switch y := y.(type) {
+		case Duration:
+			if side == starlark.Left {
+				return Time(x.Add(-time.Duration(y))), nil // time - duration
+			}
+		case Time:
+			// time - time
+			if side == starlark.Right {
+				return Duration(time.Time(y).Sub(x)), nil
+			}
+			return Duration(x.Sub(time.Time(y))), nil
+		}
+	}
+	return nil, nil // unhandled
+}
+
+func cmp(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return +1
+	}
+	return 0
+}
+
+// threeway interprets a three-way comparison value cmp (-1, 0, +1)
+// as a boolean comparison (e.g. x < y).
+func threeway(op syntax.Token, cmp int) bool {
+	switch op {
+	case syntax.EQL:
+		return cmp == 0
+	case syntax.NEQ:
+		return cmp != 0
+	case syntax.LE:
+		return cmp <= 0
+	case syntax.LT:
+		return cmp < 0
+	case syntax.GE:
+		return cmp >= 0
+	case syntax.GT:
+		return cmp > 0
+	}
+	panic(op)
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package starlarktime_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarktest"
+	"go.starlark.net/starlarktime"
+)
+
+func Test(t *testing.T) {
+	testdata := starlarktest.DataFile("starlarktime", ".")
+	thread := &starlark.Thread{Load: load}
+	starlarktest.SetReporter(thread, t)
+	starlarktime.SetNow(thread, func() time.Time {
+		return time.Date(2018, time.June, 15, 12, 30, 0, 0, time.UTC)
+	})
+	filename := filepath.Join(testdata, "testdata/time.star")
+	predeclared := starlark.StringDict{
+		"time": starlarktime.Module,
+	}
+	opts := &resolve.Options{Float: true, Lambda: true}
+	if _, err := starlark.ExecFileOptions(opts, thread, filename, nil, predeclared); err != nil {
+		if err, ok := err.(*starlark.EvalError); ok {
+			t.Fatal(err.Backtrace())
+		}
+		t.Fatal(err)
+	}
+}
+
+// load implements the 'load' operation as used in the evaluator tests.
+func load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	if module == "assert.star" {
+		return starlarktest.LoadAssertModule()
+	}
+	return nil, fmt.Errorf("load not implemented")
+}
+
+// TestHostIndependence checks that time zones are loaded from the
+// embedded database, not from the host's, by substituting a host
+// database that defines a zone "Fake/Zone" and redefines Europe/Paris.
+func TestHostIndependence(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"Fake/Zone", "Europe/Paris"} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(tzfile(9*3600, "JST"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "starlarktime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	zipfile := filepath.Join(dir, "zoneinfo.zip")
+	if err := ioutil.WriteFile(zipfile, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("ZONEINFO", os.Getenv("ZONEINFO"))
+	os.Setenv("ZONEINFO", zipfile)
+	if _, err := time.LoadLocation("Fake/Zone"); err != nil {
+		t.Fatalf("the substitute host database is not in use: %v", err)
+	}
+
+	thread := new(starlark.Thread)
+	call := func(fn string, args starlark.Tuple, kwargs ...starlark.Tuple) (starlark.Value, error) {
+		return starlark.Call(thread, starlarktime.Module.Members[fn], args, kwargs)
+	}
+	if v, err := call("is_valid_timezone", starlark.Tuple{starlark.String("Fake/Zone")}); err != nil || v != starlark.False {
+		t.Errorf("is_valid_timezone(Fake/Zone) = %v, %v, want False", v, err)
+	}
+	v, err := call("time", nil,
+		starlark.Tuple{starlark.String("year"), starlark.MakeInt(2020)},
+		starlark.Tuple{starlark.String("location"), starlark.String("Europe/Paris")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.String(), "2020-01-01 00:00:00 +0100 CET"; got != want {
+		t.Errorf("time(year=2020, location=Europe/Paris) = %s, want %s", got, want)
+	}
+}
+
+// tzfile returns the data of a tzfile(5) for a zone
+// with a fixed offset east of UTC, in seconds.
+func tzfile(offset int32, abbrev string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("TZif")
+	buf.Write(make([]byte, 16)) // version 1, reserved
+	// isutcnt, isstdcnt, leapcnt, timecnt, typecnt, charcnt
+	for _, n := range []uint32{0, 0, 0, 0, 1, uint32(len(abbrev) + 1)} {
+		binary.Write(&buf, binary.BigEndian, n)
+	}
+	binary.Write(&buf, binary.BigEndian, offset)
+	buf.Write([]byte{0, 0}) // isdst, abbreviation index
+	buf.WriteString(abbrev + "\x00")
+	return buf.Bytes()
+}
@@ -0,0 +1,692 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package syntax
+
+// This file defines Format, a pretty-printer for syntax trees.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Format returns the canonical source form of the file f.
+//
+// Statements are indented by four spaces per level, operators and
+// commas are followed by a single space, and string literals use
+// double quotes unless that would require additional escapes.
+// A bracketed list of expressions (a list, dict, tuple, call, or
+// parameter list) is printed on a single line unless it spanned
+// several lines in the original or contains comments, in which case
+// each element is printed on its own line followed by a comma.
+// At most one blank line between statements is preserved.
+//
+// Comments recorded by Parse in RetainComments mode are preserved:
+// line comments are printed before the node to which they are
+// attached, and suffix comments at the end of the line on which it
+// ends.
+func Format(f *File) []byte {
+	p := &printer{atLineStart: true}
+	p.stmts(f.Stmts, nil)
+	if c := f.Comments(); c != nil {
+		for _, comment := range c.After {
+			p.comment(comment)
+		}
+	}
+	return p.buf.Bytes()
+}
+
+// FormatExpr returns the canonical source form of the expression e.
+// Any comments attached to e or its subexpressions are printed
+// at the end.
+func FormatExpr(e Expr) string {
+	p := &printer{atLineStart: true}
+	p.expr(e, precTuple)
+	for i, c := range p.comments {
+		if i > 0 {
+			p.buf.WriteByte('\n')
+		} else {
+			p.buf.WriteString("  ")
+		}
+		p.buf.WriteString(c.Text)
+	}
+	return p.buf.String()
+}
+
+// Precedence levels of expressions, in addition to the binary
+// operator levels defined by preclevels.
+const (
+	precTuple   = -3 // unparenthesized tuple
+	precLambda  = -2 // lambda expression, keyword argument
+	precCond    = -1 // conditional expression
+	precUnary   = len(preclevels)
+	precPrimary = precUnary + 1
+)
+
+const indentWidth = 4
+
+type printer struct {
+	buf         bytes.Buffer
+	indent      int       // current indentation level
+	atLineStart bool      // nothing has been printed on the current line
+	comments    []Comment // suffix comments pending until the end of the line
+	lastLine    int32     // source line of the last statement or comment, or 0
+}
+
+// write prints s, preceded by indentation if at the start of a line.
+func (p *printer) write(s string) {
+	if p.atLineStart {
+		p.buf.WriteString(strings.Repeat(" ", indentWidth*p.indent))
+		p.atLineStart = false
+	}
+	p.buf.WriteString(s)
+}
+
+// newline ends the current line, after printing any pending comments.
+func (p *printer) newline() {
+	for i, c := range p.comments {
+		if i > 0 {
+			p.buf.WriteByte('\n')
+			p.atLineStart = true
+			p.write(c.Text)
+		} else if p.atLineStart {
+			p.write(c.Text)
+		} else {
+			p.buf.WriteString("  ")
+			p.buf.WriteString(c.Text)
+		}
+	}
+	p.comments = nil
+	p.buf.WriteByte('\n')
+	p.atLineStart = true
+}
+
+// comment prints a line comment on its own line,
+// preceded by a blank line if there was one in the original.
+func (p *printer) comment(c Comment) {
+	p.blankLine(c.Start.Line)
+	p.write(strings.TrimRight(c.Text, " \t"))
+	p.newline()
+	p.lastLine = c.Start.Line
+}
+
+// blankLine prints a blank line if the item on source line
+// is separated from the previous one by a blank line.
+func (p *printer) blankLine(line int32) {
+	if p.lastLine > 0 && line > p.lastLine+1 {
+		p.buf.WriteByte('\n')
+	}
+}
+
+// before prints the line comments attached to n. At the start of a
+// line they are printed on lines of their own; otherwise they are
+// deferred until the end of the current line.
+func (p *printer) before(n Node) {
+	c := n.Comments()
+	if c == nil {
+		return
+	}
+	for _, comment := range c.Before {
+		if p.atLineStart {
+			p.write(strings.TrimRight(comment.Text, " \t"))
+			p.newline()
+		} else {
+			p.comments = append(p.comments, comment)
+		}
+	}
+}
+
+// suffix defers the suffix comments attached to n
+// until the end of the current line.
+func (p *printer) suffix(n Node) {
+	if c := n.Comments(); c != nil {
+		p.comments = append(p.comments, c.Suffix...)
+	}
+}
+
+// stmts prints a sequence of statements.
+// The tail comments are printed at the end of the last one.
+func (p *printer) stmts(stmts []Stmt, tail []Comment) {
+	p.lastLine = 0 // no blank line at the start of a block
+	for i, stmt := range stmts {
+		var t []Comment
+		if i == len(stmts)-1 {
+			t = tail
+		}
+		p.stmt(stmt, t)
+	}
+}
+
+// block prints the indented body of a compound statement.
+func (p *printer) block(stmts []Stmt, tail []Comment) {
+	p.indent++
+	p.stmts(stmts, tail)
+	p.indent--
+}
+
+func (p *printer) stmt(stmt Stmt, tail []Comment) {
+	start, end := stmt.Span()
+	var suffix []Comment
+	if c := stmt.Comments(); c != nil {
+		for _, comment := range c.Before {
+			p.comment(comment)
+		}
+		suffix = c.Suffix
+	}
+	p.blankLine(start.Line)
+	// Comments at the end of a compound statement belong
+	// to the last line of its body.
+	if len(tail) > 0 {
+		suffix = append(suffix[:len(suffix):len(suffix)], tail...)
+	}
+
+	switch stmt := stmt.(type) {
+	case *AssignStmt:
+		p.expr(stmt.LHS, precTuple)
+		p.write(" " + stmt.Op.String() + " ")
+		p.expr(stmt.RHS, precTuple)
+
+	case *BranchStmt:
+		p.write(stmt.Token.String())
+
+	case *DefStmt:
+		p.write("def ")
+		p.expr(stmt.Name, precPrimary)
+		p.params(stmt.Def, stmt.Params)
+		p.write(":")
+		p.newline()
+		p.block(stmt.Body, suffix)
+		p.lastLine = end.Line
+		return
+
+	case *ExprStmt:
+		p.expr(stmt.X, precTuple)
+
+	case *ForStmt:
+		p.write("for ")
+		p.expr(stmt.Vars, precTuple)
+		p.write(" in ")
+		p.expr(stmt.X, precTuple)
+		p.write(":")
+		p.newline()
+		p.block(stmt.Body, suffix)
+		p.lastLine = end.Line
+		return
+
+	case *IfStmt:
+		p.ifStmt(stmt, suffix)
+		p.lastLine = end.Line
+		return
+
+	case *LoadStmt:
+		p.loadStmt(stmt)
+
+	case *ReturnStmt:
+		p.write("return")
+		if stmt.Result != nil {
+			p.write(" ")
+			p.expr(stmt.Result, precTuple)
+		}
+
+	case *WhileStmt:
+		p.write("while ")
+		p.expr(stmt.Cond, precTuple)
+		p.write(":")
+		p.newline()
+		p.block(stmt.Body, suffix)
+		p.lastLine = end.Line
+		return
+
+	case *TryStmt:
+		p.write("try:")
+		p.newline()
+		p.block(stmt.Body, nil)
+		p.write("except")
+		if stmt.Name != nil {
+			p.write(" as ")
+			p.expr(stmt.Name, precTuple)
+		}
+		p.write(":")
+		p.newline()
+		p.block(stmt.Handler, suffix)
+		p.lastLine = end.Line
+		return
+
+	default:
+		panic(fmt.Sprintf("unexpected statement %T", stmt))
+	}
+	p.comments = append(p.comments, suffix...)
+	p.newline()
+	p.lastLine = end.Line
+}
+
+// ifStmt prints an if statement, including its elif and else clauses.
+func (p *printer) ifStmt(stmt *IfStmt, tail []Comment) {
+	p.write("if ")
+	for {
+		p.expr(stmt.Cond, precTuple)
+		p.write(":")
+		p.newline()
+		if len(stmt.False) == 0 {
+			p.block(stmt.True, tail)
+			return
+		}
+		p.block(stmt.True, nil)
+
+		// An elif clause is represented by a nested
+		// IfStmt that starts at the ELIF token.
+		if elif, ok := stmt.False[0].(*IfStmt); ok && len(stmt.False) == 1 && elif.If == stmt.ElsePos {
+			if c := elif.Comments(); c != nil {
+				for _, comment := range c.Before {
+					p.comment(comment)
+				}
+				if len(c.Suffix) > 0 {
+					tail = append(c.Suffix[:len(c.Suffix):len(c.Suffix)], tail...)
+				}
+			}
+			p.write("elif ")
+			stmt = elif
+			continue
+		}
+
+		p.write("else:")
+		p.newline()
+		p.block(stmt.False, tail)
+		return
+	}
+}
+
+func (p *printer) loadStmt(stmt *LoadStmt) {
+	p.write("load(")
+	multi := p.multiline(stmt.Load, len(stmt.To)+1, func(i int) Node {
+		if i == 0 {
+			return stmt.Module
+		}
+		return stmt.To[i-1]
+	}, stmt.Rparen)
+	for i := 0; i <= len(stmt.To); i++ {
+		p.sep(i, multi)
+		if i == 0 {
+			p.expr(stmt.Module, precLambda)
+		} else {
+			from, to := stmt.From[i-1], stmt.To[i-1]
+			p.before(to)
+			if from.Name != to.Name {
+				p.write(to.Name + "=")
+			}
+			p.write(quote(from.Name, false))
+			if from != to {
+				p.suffix(from)
+			}
+			p.suffix(to)
+		}
+	}
+	p.end(len(stmt.To)+1, multi, true)
+	p.write(")")
+}
+
+// params prints a parenthesized parameter list.
+func (p *printer) params(open Position, params []Expr) {
+	p.write("(")
+	multi := p.multiline(open, len(params), func(i int) Node { return params[i] }, Position{})
+	for i, param := range params {
+		p.sep(i, multi)
+		p.expr(param, precLambda)
+	}
+	p.end(len(params), multi, !hasStars(params))
+	p.write(")")
+}
+
+// multiline reports whether a bracketed list of n elements should be
+// printed one element per line: that is, if any element has comments,
+// begins on a later line than the open bracket, or is followed by a
+// close bracket on a later line.
+func (p *printer) multiline(open Position, n int, elem func(i int) Node, close Position) bool {
+	for i := 0; i < n; i++ {
+		if hasComments(elem(i)) {
+			return true
+		}
+	}
+	if n == 0 || !open.IsValid() {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if start, _ := elem(i).Span(); start.Line > open.Line {
+			return true
+		}
+	}
+	_, end := elem(n - 1).Span()
+	return close.IsValid() && close.Line > end.Line
+}
+
+func hasComments(n Node) bool {
+	if e, ok := n.(*DictEntry); ok && (hasComments(e.Key) || hasComments(e.Value)) {
+		return true
+	}
+	c := n.Comments()
+	return c != nil && (len(c.Before) > 0 || len(c.Suffix) > 0)
+}
+
+func hasStars(list []Expr) bool {
+	for _, x := range list {
+		if u, ok := x.(*UnaryExpr); ok && (u.Op == STAR || u.Op == STARSTAR) {
+			return true
+		}
+	}
+	return false
+}
+
+// sep prints the separator before the ith element of a bracketed list.
+func (p *printer) sep(i int, multi bool) {
+	if multi {
+		if i == 0 {
+			p.indent++
+		} else {
+			p.write(",")
+		}
+		p.newline()
+	} else if i > 0 {
+		p.write(", ")
+	}
+}
+
+// end prints the terminator of a bracketed list of n elements.
+func (p *printer) end(n int, multi, trailingComma bool) {
+	if multi {
+		if trailingComma {
+			p.write(",")
+		}
+		p.newline()
+		p.indent--
+	}
+}
+
+// exprs prints a bracketed list of expressions.
+func (p *printer) exprs(open string, lpos Position, list []Expr, rpos Position, close string) {
+	p.write(open)
+	multi := p.multiline(lpos, len(list), func(i int) Node { return list[i] }, rpos)
+	for i, x := range list {
+		p.sep(i, multi)
+		p.expr(x, precLambda)
+	}
+	p.end(len(list), multi, !hasStars(list))
+	p.write(close)
+}
+
+// exprPrec returns the precedence of expression e.
+func exprPrec(e Expr) int {
+	switch e := e.(type) {
+	case *TupleExpr:
+		if !e.Lparen.IsValid() {
+			return precTuple
+		}
+	case *LambdaExpr:
+		return precLambda
+	case *CondExpr:
+		return precCond
+	case *BinaryExpr:
+		if e.Op == EQ {
+			return precLambda // keyword argument or parameter default
+		}
+		return int(precedence[e.Op])
+	case *UnaryExpr:
+		if e.Op == NOT {
+			return int(precedence[NOT])
+		}
+		return precUnary
+	}
+	return precPrimary
+}
+
+// expr prints expression e, parenthesized if its precedence is less than prec.
+func (p *printer) expr(e Expr, prec int) {
+	p.before(e)
+	if exprPrec(e) < prec {
+		p.write("(")
+		defer p.write(")")
+	}
+	defer p.suffix(e)
+
+	switch e := e.(type) {
+	case *BinaryExpr:
+		if e.Op == EQ {
+			p.expr(e.X, precPrimary)
+			p.write("=")
+			p.expr(e.Y, precLambda)
+			break
+		}
+		level := int(precedence[e.Op])
+		left, right := level, level+1
+		if level == int(precedence[EQL]) {
+			left++ // comparisons are nonassociative
+		}
+		p.expr(e.X, left)
+		p.write(" " + e.Op.String() + " ")
+		p.expr(e.Y, right)
+
+	case *CallExpr:
+		p.expr(e.Fn, precPrimary)
+		p.exprs("(", e.Lparen, e.Args, e.Rparen, ")")
+
+	case *Comprehension:
+		open, close := "[", "]"
+		if e.Curly {
+			open, close = "{", "}"
+		}
+		p.write(open)
+		if entry, ok := e.Body.(*DictEntry); ok {
+			p.dictEntry(entry)
+		} else {
+			p.expr(e.Body, precLambda)
+		}
+		for _, clause := range e.Clauses {
+			switch clause := clause.(type) {
+			case *ForClause:
+				p.write(" for ")
+				p.expr(clause.Vars, precTuple)
+				p.write(" in ")
+				p.expr(clause.X, 0)
+			case *IfClause:
+				p.write(" if ")
+				prec := 0
+				if lambda, ok := clause.Cond.(*LambdaExpr); ok {
+					// The body of a lambda in this position
+					// may not be a conditional expression.
+					if _, ok := lambda.Body[0].(*ReturnStmt).Result.(*CondExpr); !ok {
+						prec = precLambda
+					}
+				}
+				p.expr(clause.Cond, prec)
+			}
+		}
+		p.write(close)
+
+	case *CondExpr:
+		p.expr(e.True, 0)
+		p.write(" if ")
+		p.expr(e.Cond, 0)
+		p.write(" else ")
+		p.expr(e.False, precLambda)
+
+	case *DictExpr:
+		p.write("{")
+		multi := p.multiline(e.Lbrace, len(e.List), func(i int) Node { return e.List[i] }, e.Rbrace)
+		for i, entry := range e.List {
+			p.sep(i, multi)
+			p.dictEntry(entry.(*DictEntry))
+		}
+		p.end(len(e.List), multi, true)
+		p.write("}")
+
+	case *DotExpr:
+		p.expr(e.X, precPrimary)
+		if lit, ok := e.X.(*Literal); ok && lit.Token == INT {
+			p.write(" ") // "2 .f", as "2.f" would scan as a float
+		}
+		p.write(".")
+		p.expr(e.Name, precPrimary)
+
+	case *Ident:
+		p.write(e.Name)
+
+	case *IndexExpr:
+		p.expr(e.X, precPrimary)
+		p.write("[")
+		p.expr(e.Y, precTuple)
+		p.write("]")
+
+	case *LambdaExpr:
+		p.write("lambda")
+		for i, param := range e.Params {
+			if i > 0 {
+				p.write(",")
+			}
+			p.write(" ")
+			p.expr(param, precLambda)
+		}
+		p.write(": ")
+		p.expr(e.Body[0].(*ReturnStmt).Result, precLambda)
+
+	case *ListExpr:
+		p.exprs("[", e.Lbrack, e.List, e.Rbrack, "]")
+
+	case *Literal:
+		p.write(formatLiteral(e))
+
+	case *ParenExpr:
+		p.write("(")
+		p.expr(e.X, precTuple)
+		p.write(")")
+
+	case *SliceExpr:
+		p.expr(e.X, precPrimary)
+		p.write("[")
+		if e.Lo != nil {
+			p.expr(e.Lo, precLambda)
+		}
+		p.write(":")
+		if e.Hi != nil {
+			p.expr(e.Hi, precLambda)
+		}
+		if e.Step != nil {
+			p.write(":")
+			p.expr(e.Step, precLambda)
+		}
+		p.write("]")
+
+	case *TupleExpr:
+		if e.Lparen.IsValid() || len(e.List) == 0 {
+			p.write("(")
+			multi := p.multiline(e.Lparen, len(e.List), func(i int) Node { return e.List[i] }, e.Rparen)
+			for i, x := range e.List {
+				p.sep(i, multi)
+				p.expr(x, precLambda)
+			}
+			if len(e.List) == 1 && !multi {
+				p.write(",")
+			}
+			p.end(len(e.List), multi, true)
+			p.write(")")
+			break
+		}
+		// Any necessary parentheses were added above.
+		for i, x := range e.List {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expr(x, precLambda)
+		}
+		if len(e.List) == 1 {
+			p.write(",")
+		}
+
+	case *UnaryExpr:
+		switch e.Op {
+		case NOT:
+			p.write("not ")
+			p.expr(e.X, int(precedence[NOT]))
+		case STAR, STARSTAR:
+			p.write(e.Op.String())
+			if e.X != nil {
+				p.expr(e.X, precLambda)
+			}
+		default:
+			p.write(e.Op.String())
+			p.expr(e.X, precUnary)
+		}
+
+	default:
+		panic(fmt.Sprintf("unexpected expression %T", e))
+	}
+}
+
+func (p *printer) dictEntry(e *DictEntry) {
+	p.before(e)
+	defer p.suffix(e)
+	p.expr(e.Key, precLambda)
+	p.write(": ")
+	p.expr(e.Value, precLambda)
+}
+
+// formatLiteral returns the canonical form of a literal.
+func formatLiteral(e *Literal) string {
+	if e.Raw == "" {
+		// Synthesized literal.
+		switch v := e.Value.(type) {
+		case string:
+			if e.Token == BYTES {
+				return "b" + quote(v, false)
+			}
+			return quote(v, false)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	if e.Token == STRING || e.Token == BYTES {
+		return requote(e.Raw)
+	}
+	return e.Raw
+}
+
+// requote returns the string literal raw using double quotes in place
+// of single quotes, unless the literal contains a double quote. Any
+// escape sequences other than \' are preserved as written.
+func requote(raw string) string {
+	n := stringPrefixLen([]byte(raw))
+	prefix, lit := raw[:n], raw[n:]
+	if len(lit) < 2 || lit[0] != '\'' {
+		return raw
+	}
+	q, dq := "'", `"`
+	if len(lit) >= 6 && strings.HasPrefix(lit, "'''") {
+		q, dq = "'''", `"""`
+	}
+	body := lit[len(q) : len(lit)-len(q)]
+	if strings.Contains(body, `"`) {
+		return raw
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	buf.WriteString(dq)
+	if strings.Contains(prefix, "r") {
+		// In a raw string, \' denotes two characters.
+		buf.WriteString(body)
+	} else {
+		for i := 0; i < len(body); i++ {
+			c := body[i]
+			if c == '\\' && i+1 < len(body) {
+				i++
+				if body[i] != '\'' {
+					buf.WriteByte(c)
+				}
+				c = body[i]
+			}
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteString(dq)
+	return buf.String()
+}
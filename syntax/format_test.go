@@ -0,0 +1,165 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package syntax_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlarktest"
+	"go.starlark.net/syntax"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		input, want string
+	}{
+		// spacing and quoting
+		{`x=1+2*3`, `x = 1 + 2 * 3`},
+		{`x+=-y`, `x += -y`},
+		{`f(a,b,*c,**d)`, `f(a, b, *c, **d)`},
+		{`f(a, k = 1)`, `f(a, k=1)`},
+		{`x = 'abc'`, `x = "abc"`},
+		{`x = 'say "hi"'`, `x = 'say "hi"'`},
+		{`x = 'it\'s'`, `x = "it's"`},
+		{`x = r'\d+'`, `x = r"\d+"`},
+		{`x = b'\x00'`, `x = b"\x00"`},
+		{`x = '\\'`, `x = "\\"`},
+		{`x = 0x1F`, `x = 0x1F`},
+		{`x = not a in b`, `x = not a in b`},
+		{`x = (a+b)*c`, `x = (a + b) * c`},
+		{`x = a.b[1][2:3][::2](4)`, `x = a.b[1][2:3][::2](4)`},
+		{`x = [y for y in z if y]`, `x = [y for y in z if y]`},
+		{`x = {k:v for k, v in z}`, `x = {k: v for k, v in z}`},
+		{`x = a if b else c`, `x = a if b else c`},
+		{`f = lambda x,y=1:x+y`, `f = lambda x, y=1: x + y`},
+		{`x = -2 .bit_length()`, `x = -2 .bit_length()`},
+		{`x = 0x1F .f`, `x = 0x1F .f`},
+		{`x = 2.5.f`, `x = 2.5.f`},
+
+		// tuples
+		{`x,y = 1,2`, `x, y = 1, 2`},
+		{`x = (1,)`, `x = (1,)`},
+		{`x = ()`, `x = ()`},
+		{`for k,v in d.items(): pass`, "for k, v in d.items():\n    pass"},
+		{`f((1, 2))`, `f((1, 2))`},
+
+		// statements and indentation
+		{"def f(x,*args,**kwargs):\n  return", "def f(x, *args, **kwargs):\n    return"},
+		{"if a:\n  pass\nelif b:\n  pass\nelse:\n  pass", "if a:\n    pass\nelif b:\n    pass\nelse:\n    pass"},
+		{"if a:\n  pass\nelse:\n  if b:\n    pass", "if a:\n    pass\nelse:\n    if b:\n        pass"},
+		{"while x:\n\tbreak", "while x:\n    break"},
+		{"try:\n  f()\nexcept as e:\n  g(e)", "try:\n    f()\nexcept as e:\n    g(e)"},
+		{`load('a.star', 'x', y='z')`, `load("a.star", "x", y="z")`},
+
+		// blank lines
+		{"x = 1\n\n\n\ny = 2", "x = 1\n\ny = 2"},
+		{"def f():\n\n  x = 1\n\n  y = 2", "def f():\n    x = 1\n\n    y = 2"},
+
+		// multi-line lists
+		{"x = [1,\n 2]", "x = [\n    1,\n    2,\n]"},
+		{"x = {'a': 1,\n}", "x = {\n    \"a\": 1,\n}"},
+		{"f(a,\n  *b)", "f(\n    a,\n    *b\n)"},
+		{"x = [1, 2,\n]", "x = [\n    1,\n    2,\n]"},
+
+		// comments
+		{"# hello\nx = 1 # one\n# bye", "# hello\nx = 1  # one\n# bye"},
+		{"x = [\n  # first\n  1, # one\n  2 # two\n]", "x = [\n    # first\n    1,  # one\n    2,  # two\n]"},
+		{"def f(): # f\n  return 1 # one", "def f():  # f\n    return 1  # one"},
+		{"if x:\n  pass\n# other\nelif y:\n  pass # y", "if x:\n    pass\n# other\nelif y:\n    pass  # y"},
+		{"x = (1 +\n  # two\n  2)", "x = (1 + 2)  # two"},
+	} {
+		f, err := syntax.Parse("in.star", test.input, syntax.RetainComments)
+		if err != nil {
+			t.Errorf("parse `%s` failed: %v", test.input, err)
+			continue
+		}
+		got := strings.TrimSuffix(string(syntax.Format(f)), "\n")
+		if got != test.want {
+			t.Errorf("format `%s` = `%s`, want `%s`", test.input, got, test.want)
+		}
+		if _, err := syntax.Parse("out.star", got, syntax.RetainComments); err != nil {
+			t.Errorf("format `%s` = `%s`, which does not parse: %v", test.input, got, err)
+		}
+	}
+}
+
+func TestFormatExpr(t *testing.T) {
+	// Synthesized trees require parentheses.
+	x, y, z := &syntax.Ident{Name: "x"}, &syntax.Ident{Name: "y"}, &syntax.Ident{Name: "z"}
+	for _, test := range []struct {
+		expr syntax.Expr
+		want string
+	}{
+		{&syntax.BinaryExpr{Op: syntax.STAR, X: &syntax.BinaryExpr{Op: syntax.PLUS, X: x, Y: y}, Y: z}, `(x + y) * z`},
+		{&syntax.BinaryExpr{Op: syntax.MINUS, X: x, Y: &syntax.BinaryExpr{Op: syntax.MINUS, X: y, Y: z}}, `x - (y - z)`},
+		{&syntax.BinaryExpr{Op: syntax.LT, X: &syntax.BinaryExpr{Op: syntax.LT, X: x, Y: y}, Y: z}, `(x < y) < z`},
+		{&syntax.UnaryExpr{Op: syntax.MINUS, X: &syntax.UnaryExpr{Op: syntax.NOT, X: x}}, `-(not x)`},
+		{&syntax.DotExpr{X: &syntax.CondExpr{Cond: x, True: y, False: z}, Name: &syntax.Ident{Name: "f"}}, `(y if x else z).f`},
+		{&syntax.ListExpr{List: []syntax.Expr{&syntax.TupleExpr{List: []syntax.Expr{x, y}}}}, `[(x, y)]`},
+		{&syntax.Literal{Token: syntax.STRING, Value: "a\"b"}, `"a\"b"`},
+		{&syntax.Literal{Token: syntax.INT, Value: int64(42)}, `42`},
+		{&syntax.DotExpr{X: &syntax.Literal{Token: syntax.INT, Value: int64(2)}, Name: &syntax.Ident{Name: "f"}}, `2 .f`},
+	} {
+		got := syntax.FormatExpr(test.expr)
+		if got != test.want {
+			t.Errorf("FormatExpr = `%s`, want `%s`", got, test.want)
+		}
+		if _, err := syntax.ParseExpr("out.star", got, 0); err != nil {
+			t.Errorf("FormatExpr = `%s`, which does not parse: %v", got, err)
+		}
+	}
+}
+
+// TestFormatTestdata checks that formatting the test files preserves
+// their syntax trees and comments, and is idempotent.
+func TestFormatTestdata(t *testing.T) {
+	files, err := filepath.Glob(starlarktest.DataFile("starlark", "testdata/*.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, chunk := range strings.Split(string(data), "\n---\n") {
+			f, err := syntax.Parse(filename, chunk, syntax.RetainComments)
+			if err != nil {
+				continue // chunk contains deliberate errors
+			}
+			out := syntax.Format(f)
+			f2, err := syntax.Parse(filename, out, syntax.RetainComments)
+			if err != nil {
+				t.Errorf("%s: formatted output does not parse: %v", filename, err)
+				continue
+			}
+			if got, want := treeString(f2), treeString(f); got != want {
+				t.Errorf("%s: formatting changed the syntax tree", filename)
+			}
+			if got, want := countComments(f2), countComments(f); got != want {
+				t.Errorf("%s: formatting changed number of comments from %d to %d", filename, want, got)
+			}
+			if out2 := syntax.Format(f2); string(out2) != string(out) {
+				t.Errorf("%s: formatting is not idempotent", filename)
+			}
+		}
+	}
+}
+
+func countComments(f *syntax.File) int {
+	var n int
+	syntax.Walk(f, func(x syntax.Node) bool {
+		if x != nil {
+			if c := x.Comments(); c != nil {
+				n += len(c.Before) + len(c.Suffix) + len(c.After)
+			}
+		}
+		return true
+	})
+	return n
+}
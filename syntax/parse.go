@@ -11,7 +11,10 @@ package syntax
 // package.  Verify that error positions are correct using the
 // chunkedfile mechanism.
 
-import "log"
+import (
+	"fmt"
+	"log"
+)
 
 // Enable this flag to print the token stream and log.Fatal on the first error.
 const debug = false
@@ -30,32 +33,41 @@ const (
 // The type of the argument for the src parameter must be string,
 // []byte, or io.Reader.
 // If src == nil, ParseFile parses the file specified by filename.
+//
+// If the input contains syntax errors, Parse returns an ErrorList.
+// After an error, the parser skips to the start of the next line
+// (or the end of the bracketed expression spanning it) and resumes at
+// the next statement, so that a single call reports the errors
+// in all statements of the file.
 func Parse(filename string, src interface{}, mode Mode) (f *File, err error) {
 	in, err := newScanner(filename, src, mode&RetainComments != 0)
 	if err != nil {
 		return nil, err
 	}
 	p := parser{in: in}
-	defer p.in.recover(&err)
+	defer p.recover(&err)
 
-	p.nextToken() // read first lookahead token
+	for !p.sync() { // read first lookahead token
+	}
 	f = p.parseFile()
-	if f != nil {
-		f.Path = filename
+	if len(p.errors) > 0 {
+		return nil, p.errors
 	}
+	f.Path = filename
 	p.assignComments(f)
 	return f, nil
 }
 
 // ParseExpr parses a Starlark expression.
 // See Parse for explanation of parameters.
+// A syntax error is reported as an ErrorList of one element.
 func ParseExpr(filename string, src interface{}, mode Mode) (expr Expr, err error) {
 	in, err := newScanner(filename, src, mode&RetainComments != 0)
 	if err != nil {
 		return nil, err
 	}
 	p := parser{in: in}
-	defer p.in.recover(&err)
+	defer p.recover(&err)
 
 	p.nextToken() // read first lookahead token
 	expr = p.parseTest()
@@ -77,6 +89,65 @@ type parser struct {
 	in     *scanner
 	tok    Token
 	tokval tokenValue
+	errors ErrorList // errors from statements that have been skipped
+}
+
+// recover converts a panic during parsing into an ErrorList containing
+// all errors reported so far. The parser panics both for routine errors
+// like syntax errors and for programmer bugs like array index errors;
+// catching bug panics is especially important when processing many files.
+func (p *parser) recover(err *error) {
+	switch e := recover().(type) {
+	case nil:
+		// no panic
+	case Error:
+		p.errors = append(p.errors, e)
+	default:
+		p.errors = append(p.errors, Error{p.in.pos, fmt.Sprintf("internal error: %v", e)})
+		if debug {
+			log.Fatal(p.errors)
+		}
+	}
+	if len(p.errors) > 0 {
+		*err = p.errors
+	}
+}
+
+// parseStmtRecover parses a statement. If the statement contains a
+// syntax error, it records the error, skips to the next line, and
+// returns stmts unchanged.
+func (p *parser) parseStmtRecover(stmts []Stmt) (result []Stmt) {
+	defer func() {
+		if e := recover(); e != nil {
+			err, ok := e.(Error)
+			if !ok {
+				panic(e) // not a syntax error
+			}
+			p.errors = append(p.errors, err)
+			for !p.sync() {
+			}
+			result = stmts
+		}
+	}()
+	return p.parseStmt(stmts)
+}
+
+// sync skips the remainder of the current line and reads the next
+// token. It reports false if the scanner reported an error, which
+// it records.
+func (p *parser) sync() (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			err, ok := e.(Error)
+			if !ok {
+				panic(e)
+			}
+			p.errors = append(p.errors, err)
+		}
+	}()
+	p.in.skipLine()
+	p.nextToken()
+	return true
 }
 
 // nextToken advances the scanner and returns the position of the
@@ -93,15 +164,38 @@ func (p *parser) nextToken() Position {
 
 // file_input = (NEWLINE | stmt)* EOF
 func (p *parser) parseFile() *File {
+	return &File{Stmts: p.parseStmts(true)}
+}
+
+// parseStmts parses a sequence of statements up to the OUTDENT token
+// that ends the current block, or to EOF if top is set.
+//
+// After a syntax error, an INDENT token may begin a block whose
+// header was skipped. Such blocks are parsed as if they were part
+// of the current one.
+func (p *parser) parseStmts(top bool) []Stmt {
 	var stmts []Stmt
+	strays := 0 // number of unmatched INDENT tokens skipped after an error
 	for p.tok != EOF {
-		if p.tok == NEWLINE {
+		switch {
+		case p.tok == NEWLINE:
 			p.nextToken()
-			continue
+		case p.tok == INDENT && len(p.errors) > 0:
+			strays++
+			p.nextToken()
+		case p.tok == OUTDENT:
+			if strays == 0 && !top {
+				return stmts // end of block
+			}
+			if strays > 0 {
+				strays--
+			}
+			p.nextToken()
+		default:
+			stmts = p.parseStmtRecover(stmts)
 		}
-		stmts = p.parseStmt(stmts)
 	}
-	return &File{Stmts: stmts}
+	return stmts
 }
 
 func (p *parser) parseStmt(stmts []Stmt) []Stmt {
@@ -113,6 +207,8 @@ func (p *parser) parseStmt(stmts []Stmt) []Stmt {
 		return append(stmts, p.parseForStmt())
 	} else if p.tok == WHILE {
 		return append(stmts, p.parseWhileStmt())
+	} else if p.tok == TRY {
+		return append(stmts, p.parseTryStmt())
 	}
 	return p.parseSimpleStmt(stmts)
 }
@@ -196,6 +292,27 @@ func (p *parser) parseWhileStmt() Stmt {
 	}
 }
 
+func (p *parser) parseTryStmt() Stmt {
+	trypos := p.nextToken() // consume TRY
+	p.consume(COLON)
+	body := p.parseSuite()
+	exceptpos := p.consume(EXCEPT)
+	var name *Ident
+	if p.tok == AS {
+		p.nextToken() // consume AS
+		name = p.parseIdent()
+	}
+	p.consume(COLON)
+	handler := p.parseSuite()
+	return &TryStmt{
+		Try:     trypos,
+		Body:    body,
+		Except:  exceptpos,
+		Name:    name,
+		Handler: handler,
+	}
+}
+
 // Equivalent to 'exprlist' production in Python grammar.
 //
 // loop_variables = primary_with_suffix (COMMA primary_with_suffix)* COMMA?
@@ -347,10 +464,7 @@ func (p *parser) parseSuite() []Stmt {
 	if p.tok == NEWLINE {
 		p.nextToken() // consume NEWLINE
 		p.consume(INDENT)
-		var stmts []Stmt
-		for p.tok != OUTDENT && p.tok != EOF {
-			stmts = p.parseStmt(stmts)
-		}
+		stmts := p.parseStmts(false)
 		p.consume(OUTDENT)
 		return stmts
 	}
@@ -745,7 +859,7 @@ func (p *parser) parseArgs() []Expr {
 
 //  primary = IDENT
 //          | INT | FLOAT
-//          | STRING
+//          | STRING | BYTES
 //          | '[' ...                    // list literal or comprehension
 //          | '{' ...                    // dict literal or comprehension
 //          | '(' ...                    // tuple or parenthesized expression
@@ -755,7 +869,7 @@ func (p *parser) parsePrimary() Expr {
 	case IDENT:
 		return p.parseIdent()
 
-	case INT, FLOAT, STRING:
+	case INT, FLOAT, STRING, BYTES:
 		var val interface{}
 		tok := p.tok
 		switch tok {
@@ -767,7 +881,7 @@ func (p *parser) parsePrimary() Expr {
 			}
 		case FLOAT:
 			val = p.tokval.float
-		case STRING:
+		case STRING, BYTES:
 			val = p.tokval.string
 		}
 		raw := p.tokval.raw
@@ -135,6 +135,10 @@ func TestStmtParseTrees(t *testing.T) {
 			`(ForStmt Vars=i X="abc" Body=((BranchStmt Token=continue)))`},
 		{`for x, y in z: pass`,
 			`(ForStmt Vars=(TupleExpr List=(x y)) X=z Body=((BranchStmt Token=pass)))`},
+		{"try:\n  f()\nexcept as e:\n  g(e)",
+			`(TryStmt Body=((ExprStmt X=(CallExpr Fn=f))) Name=e Handler=((ExprStmt X=(CallExpr Fn=g Args=(e)))))`},
+		{"try:\n  pass\nexcept:\n  pass",
+			`(TryStmt Body=((BranchStmt Token=pass)) Handler=((BranchStmt Token=pass)))`},
 		{`if True: pass`,
 			`(IfStmt Cond=True True=((BranchStmt Token=pass)))`},
 		{`if True: break`,
@@ -338,8 +342,10 @@ func TestParseErrors(t *testing.T) {
 		switch err := err.(type) {
 		case nil:
 			// ok
-		case syntax.Error:
-			chunk.GotError(int(err.Pos.Line), err.Msg)
+		case syntax.ErrorList:
+			for _, err := range err {
+				chunk.GotError(int(err.Pos.Line), err.Msg)
+			}
 		default:
 			t.Error(err)
 		}
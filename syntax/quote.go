@@ -49,13 +49,22 @@ var esc = [256]byte{
 const notEsc = " !#$%&()*+,-./:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ{|}~"
 
 // unquote unquotes the quoted string, returning the actual
-// string value, whether the original was triple-quoted, and
-// an error describing invalid input.
-func unquote(quoted string) (s string, triple bool, err error) {
+// string value, whether the original was triple-quoted,
+// whether it was a bytes literal, and an error describing
+// invalid input.
+func unquote(quoted string) (s string, triple, isBytes bool, err error) {
 	// Check for raw prefix: means don't interpret the inner \.
+	// Check for bytes prefix: means the literal denotes bytes.
+	// The two prefixes may appear in either order.
 	raw := false
-	if strings.HasPrefix(quoted, "r") {
-		raw = true
+	for i := 0; i < 2 && len(quoted) > 0; i++ {
+		if quoted[0] == 'r' && !raw {
+			raw = true
+		} else if quoted[0] == 'b' && !isBytes {
+			isBytes = true
+		} else {
+			break
+		}
 		quoted = quoted[1:]
 	}
 
@@ -62,10 +62,16 @@ func TestQuote(t *testing.T) {
 
 func TestUnquote(t *testing.T) {
 	for _, tt := range quoteTests {
-		s, triple, err := unquote(tt.q)
+		s, triple, isBytes, err := unquote(tt.q)
 		wantTriple := strings.HasPrefix(tt.q, `"""`) || strings.HasPrefix(tt.q, `'''`)
-		if s != tt.s || triple != wantTriple || err != nil {
-			t.Errorf("unquote(%s) = %#q, %v, %v want %#q, %v, nil", tt.q, s, triple, err, tt.s, wantTriple)
+		if s != tt.s || triple != wantTriple || isBytes || err != nil {
+			t.Errorf("unquote(%s) = %#q, %v, %v, %v want %#q, %v, false, nil", tt.q, s, triple, isBytes, err, tt.s, wantTriple)
+		}
+
+		// The same literal with a b prefix denotes bytes.
+		s, triple, isBytes, err = unquote("b" + tt.q)
+		if s != tt.s || triple != wantTriple || !isBytes || err != nil {
+			t.Errorf("unquote(b%s) = %#q, %v, %v, %v want %#q, %v, true, nil", tt.q, s, triple, isBytes, err, tt.s, wantTriple)
 		}
 	}
 }
@@ -35,6 +35,7 @@ const (
 	INT    // 123
 	FLOAT  // 1.23e45
 	STRING // "foo" or 'foo' or '''foo''' or r'foo' or r"foo"
+	BYTES  // b"foo", etc
 
 	// Punctuation
 	PLUS          // +
@@ -81,11 +82,13 @@ const (
 
 	// Keywords
 	AND
+	AS
 	BREAK
 	CONTINUE
 	DEF
 	ELIF
 	ELSE
+	EXCEPT
 	FOR
 	IF
 	IN
@@ -96,6 +99,7 @@ const (
 	OR
 	PASS
 	RETURN
+	TRY
 	WHILE
 
 	maxToken
@@ -122,6 +126,7 @@ var tokenNames = [...]string{
 	INT:           "int literal",
 	FLOAT:         "float literal",
 	STRING:        "string literal",
+	BYTES:         "bytes literal",
 	PLUS:          "+",
 	MINUS:         "-",
 	STAR:          "*",
@@ -164,11 +169,13 @@ var tokenNames = [...]string{
 	GTGT_EQ:       ">>=",
 	STARSTAR:      "**",
 	AND:           "and",
+	AS:            "as",
 	BREAK:         "break",
 	CONTINUE:      "continue",
 	DEF:           "def",
 	ELIF:          "elif",
 	ELSE:          "else",
+	EXCEPT:        "except",
 	FOR:           "for",
 	IF:            "if",
 	IN:            "in",
@@ -179,6 +186,7 @@ var tokenNames = [...]string{
 	OR:            "or",
 	PASS:          "pass",
 	RETURN:        "return",
+	TRY:           "try",
 }
 
 // A Position describes the location of a rune of input.
@@ -286,6 +294,17 @@ type Error struct {
 
 func (e Error) Error() string { return e.Pos.String() + ": " + e.Msg }
 
+// An ErrorList is a non-empty list of scanner or parser errors,
+// in order of position.
+type ErrorList []Error // len > 0
+
+func (e ErrorList) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
 // errorf is called to report an error.
 // errorf does not return: it panics.
 func (sc *scanner) error(pos Position, s string) {
@@ -314,6 +333,65 @@ func (sc *scanner) recover(err *error) {
 	}
 }
 
+// skipLine discards the remainder of the current line, if the scanner
+// is not at the start of one, so that scanning resumes at the start of
+// the next line. If the line ends within a bracketed expression, the
+// subsequent lines up to its end are discarded too, so long as they
+// are indented more than the current block.
+func (sc *scanner) skipLine() {
+	depth := sc.depth
+	sc.depth = 0
+	if sc.lineStart {
+		return
+	}
+	for !sc.eof() {
+		switch c := sc.readRune(); c {
+		case '\n':
+			if depth <= 0 || !sc.continues() {
+				sc.lineStart = true
+				return
+			}
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '#':
+			for !sc.eof() && sc.peekRune() != '\n' {
+				sc.readRune()
+			}
+		case '"', '\'':
+			// Skip a string literal, if it ends on this line.
+			for !sc.eof() && sc.peekRune() != '\n' {
+				if r := sc.readRune(); r == c {
+					break
+				} else if r == '\\' && !sc.eof() && sc.peekRune() != '\n' {
+					sc.readRune()
+				}
+			}
+		}
+	}
+}
+
+// continues reports whether the next non-blank line appears to
+// continue a bracketed expression: it is indented more than the
+// current block, or starts with a closing bracket.
+func (sc *scanner) continues() bool {
+	col := 0
+	for col < len(sc.rest) && (sc.rest[col] == ' ' || sc.rest[col] == '\t') {
+		col++
+	}
+	if col == len(sc.rest) {
+		return false // end of file
+	}
+	switch sc.rest[col] {
+	case '\r', '\n', '#':
+		return true // blank or comment line
+	case ')', ']', '}':
+		return true
+	}
+	return col > sc.indentstk[len(sc.indentstk)-1]
+}
+
 // eof reports whether the input has reached end of file.
 func (sc *scanner) eof() bool {
 	return len(sc.rest) == 0
@@ -377,7 +455,7 @@ type tokenValue struct {
 	int    int64    // decoded int
 	bigInt *big.Int // decoded integers > int64
 	float  float64  // decoded float
-	string string   // decoded string
+	string string   // decoded string or bytes
 	pos    Position // start position of token
 }
 
@@ -581,9 +659,11 @@ start:
 
 	// identifier or keyword
 	if isIdentStart(c) {
-		// raw string literal
-		if c == 'r' && len(sc.rest) > 1 && (sc.rest[1] == '"' || sc.rest[1] == '\'') {
-			sc.readRune()
+		// raw string or bytes literal: r"...", b"...", rb"...", br"..."
+		if n := stringPrefixLen(sc.rest); n > 0 {
+			for i := 0; i < n; i++ {
+				sc.readRune()
+			}
 			c = sc.peekRune()
 			return sc.scanString(val, c)
 		}
@@ -790,14 +870,34 @@ func (sc *scanner) scanString(val *tokenValue, quote rune) Token {
 	}
 
 	sc.endToken(val)
-	s, _, err := unquote(val.raw)
+	s, _, isBytes, err := unquote(val.raw)
 	if err != nil {
 		sc.error(start, err.Error())
 	}
 	val.string = s
+	if isBytes {
+		return BYTES
+	}
 	return STRING
 }
 
+// stringPrefixLen returns the length of the prefix (r, b, rb, or br)
+// of the string or bytes literal at the start of s, or zero if s does
+// not start with a prefixed literal.
+func stringPrefixLen(s []byte) int {
+	n := 0
+	if len(s) > 0 && (s[0] == 'r' || s[0] == 'b') {
+		n = 1
+		if len(s) > 1 && (s[1] == 'r' || s[1] == 'b') && s[1] != s[0] {
+			n = 2
+		}
+	}
+	if n > 0 && len(s) > n && (s[n] == '"' || s[n] == '\'') {
+		return n
+	}
+	return 0
+}
+
 func (sc *scanner) scanNumber(val *tokenValue, c rune) Token {
 	// https://github.com/google/starlark-go/blob/master/doc/spec.md#lexical-elements
 	//
@@ -984,11 +1084,13 @@ func isbdigit(c rune) bool { return '0' == c || c == '1' }
 // strings that should not be treated as ordinary identifiers.
 var keywordToken = map[string]Token{
 	"and":      AND,
+	"as":       AS,
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"def":      DEF,
 	"elif":     ELIF,
 	"else":     ELSE,
+	"except":   EXCEPT,
 	"for":      FOR,
 	"if":       IF,
 	"in":       IN,
@@ -998,14 +1100,13 @@ var keywordToken = map[string]Token{
 	"or":       OR,
 	"pass":     PASS,
 	"return":   RETURN,
+	"try":      TRY,
 	"while":    WHILE,
 
 	// reserved words:
-	"as": ILLEGAL,
 	// "assert":   ILLEGAL, // heavily used by our tests
 	"class":    ILLEGAL,
 	"del":      ILLEGAL,
-	"except":   ILLEGAL,
 	"finally":  ILLEGAL,
 	"from":     ILLEGAL,
 	"global":   ILLEGAL,
@@ -1013,7 +1114,6 @@ var keywordToken = map[string]Token{
 	"is":       ILLEGAL,
 	"nonlocal": ILLEGAL,
 	"raise":    ILLEGAL,
-	"try":      ILLEGAL,
 	"with":     ILLEGAL,
 	"yield":    ILLEGAL,
 }
@@ -44,6 +44,8 @@ func scan(src interface{}) (tokens string, err error) {
 			fmt.Fprintf(&buf, "%e", val.float)
 		case STRING:
 			fmt.Fprintf(&buf, "%q", val.string)
+		case BYTES:
+			fmt.Fprintf(&buf, "b%q", val.string)
 		default:
 			buf.WriteString(tok.String())
 		}
@@ -130,6 +132,13 @@ pass`, "pass newline pass EOF"}, // consecutive newlines are consolidated
 		{"x = '''a\rb'''", `x = "a\nb" EOF`},
 		{"x = '''a\r\nb'''", `x = "a\nb" EOF`},
 		{"x = '''a\n\rb'''", `x = "a\n\nb" EOF`},
+		{`x = b'a\x00\xffb'`, `x = b"a\x00\xffb" EOF`},
+		{`x = b"\""`, `x = b"\"" EOF`},
+		{`x = rb'a\nb'`, `x = b"a\\nb" EOF`},
+		{`x = br'a\nb'`, `x = b"a\\nb" EOF`},
+		{"x = b'''a\nb'''", `x = b"a\nb" EOF`},
+		{`x = bb'a'`, `x = bb "a" EOF`},
+		{`x = b + r`, `x = b + r EOF`},
 		{"x = r'a\\\nb'", `x = "a\\\nb" EOF`},
 		{"x = r'a\\\rb'", `x = "a\\\nb" EOF`},
 		{"x = r'a\\\r\nb'", `x = "a\\\nb" EOF`},
@@ -96,6 +96,7 @@ func (*DefStmt) stmt()    {}
 func (*ExprStmt) stmt()   {}
 func (*ForStmt) stmt()    {}
 func (*WhileStmt) stmt()  {}
+func (*TryStmt) stmt()    {}
 func (*IfStmt) stmt()     {}
 func (*LoadStmt) stmt()   {}
 func (*ReturnStmt) stmt() {}
@@ -272,10 +273,10 @@ func (x *Ident) Span() (start, end Position) {
 // A Literal represents a literal string or number.
 type Literal struct {
 	commentsRef
-	Token    Token // = STRING | INT
+	Token    Token // = STRING | BYTES | INT | FLOAT
 	TokenPos Position
 	Raw      string      // uninterpreted text
-	Value    interface{} // = string | int64 | *big.Int
+	Value    interface{} // = string | int64 | *big.Int | float64
 }
 
 func (x *Literal) Span() (start, end Position) {
@@ -365,6 +366,22 @@ func (x *WhileStmt) Span() (start, end Position) {
 	return x.While, end
 }
 
+// A TryStmt represents a try statement:
+// try: Body except: Handler, or try: Body except as Name: Handler.
+type TryStmt struct {
+	commentsRef
+	Try     Position
+	Body    []Stmt
+	Except  Position
+	Name    *Ident // may be nil
+	Handler []Stmt
+}
+
+func (x *TryStmt) Span() (start, end Position) {
+	_, end = x.Handler[len(x.Handler)-1].Span()
+	return x.Try, end
+}
+
 // A ForClause represents a for clause in a list comprehension: for Vars in X.
 type ForClause struct {
 	commentsRef
@@ -422,7 +439,7 @@ func (x *DictEntry) Span() (start, end Position) {
 //
 // Although they may be added in future, lambda expressions are not
 // currently part of the Starlark spec, so their use is controlled by the
-// resolver.AllowLambda flag.
+// resolve.Options.Lambda setting.
 type LambdaExpr struct {
 	commentsRef
 	Lambda Position
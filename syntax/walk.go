@@ -31,8 +31,8 @@ func Walk(n Node, f func(Node) bool) {
 		walkStmts(n.False, f)
 
 	case *AssignStmt:
-		Walk(n.RHS, f)
 		Walk(n.LHS, f)
+		Walk(n.RHS, f)
 
 	case *DefStmt:
 		Walk(n.Name, f)
@@ -46,6 +46,17 @@ func Walk(n Node, f func(Node) bool) {
 		Walk(n.X, f)
 		walkStmts(n.Body, f)
 
+	case *WhileStmt:
+		Walk(n.Cond, f)
+		walkStmts(n.Body, f)
+
+	case *TryStmt:
+		walkStmts(n.Body, f)
+		if n.Name != nil {
+			Walk(n.Name, f)
+		}
+		walkStmts(n.Handler, f)
+
 	case *ReturnStmt:
 		if n.Result != nil {
 			Walk(n.Result, f)